@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// APIError describes a gm-control-api request that did not produce a
+// result: either a non-200 response or a body that is not the JSON
+// envelope. ErrorMap holds the members of the envelope's error object, of
+// which Code and Message are the ones the server normally sets.
+// NotEnvelope is set when the body is not a JSON object or, for a non-200
+// response, has no error object; ErrorMap is nil then.
+type APIError struct {
+	StatusCode  int
+	Method      string
+	URL         string
+	Code        string
+	Message     string
+	ErrorMap    map[string]string
+	NotEnvelope bool
+	Body        []byte
+	RequestID   string
+}
+
+func (apiErr *APIError) Error() string {
+	message := apiErr.Message
+	if message == "" {
+		message = http.StatusText(apiErr.StatusCode)
+	}
+	text := fmt.Sprintf("%s %s: (%d)", apiErr.Method, apiErr.URL, apiErr.StatusCode)
+	if apiErr.Code != "" {
+		text += " " + apiErr.Code
+	}
+	text += ": " + message
+	if apiErr.RequestID != "" {
+		text += fmt.Sprintf(" [request %s]", apiErr.RequestID)
+	}
+	return text
+}
+
+// setErrorMap decodes the error member of the response envelope. Members
+// that are not strings are kept as their JSON text.
+func (apiErr *APIError) setErrorMap(rawMessage json.RawMessage) {
+	var rawMap map[string]json.RawMessage
+	err := json.Unmarshal(rawMessage, &rawMap)
+	if err == nil && rawMap == nil {
+		err = errors.New("no error object")
+	}
+	if err != nil {
+		apiErr.Message = fmt.Sprintf("error envelope not decodable: %s", err)
+		apiErr.NotEnvelope = true
+		return
+	}
+
+	apiErr.ErrorMap = make(map[string]string, len(rawMap))
+	for key, value := range rawMap {
+		var text string
+		if err := json.Unmarshal(value, &text); err != nil {
+			text = string(value)
+		}
+		apiErr.ErrorMap[key] = text
+	}
+	apiErr.Code = apiErr.ErrorMap["code"]
+	apiErr.Message = apiErr.ErrorMap["message"]
+}
+
+// asAPIError returns the APIError at the root of err, if there is one.
+func asAPIError(err error) (*APIError, bool) {
+	apiErr, ok := errors.Cause(err).(*APIError)
+	return apiErr, ok
+}
+
+// IsNotFound reports whether err is a 404 from gm-control-api.
+func IsNotFound(err error) bool {
+	apiErr, ok := asAPIError(err)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IsConflict reports whether err is gm-control-api refusing a write because
+// the checksum sent with it no longer matches the stored object. A delete
+// refused by IsInUse answers 409 as well, so a 409 to a delete is both;
+// the caller knows which one it provoked.
+func IsConflict(err error) bool {
+	apiErr, ok := asAPIError(err)
+	if !ok {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return true
+	}
+	code := strings.ToLower(apiErr.Code)
+	return strings.Contains(code, "checksum") || strings.Contains(code, "conflict")
+}
+
+// IsValidation reports whether err is gm-control-api rejecting a request
+// body or parameters as invalid.
+func IsValidation(err error) bool {
+	apiErr, ok := asAPIError(err)
+	if !ok {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return !IsConflict(err)
+	}
+	return false
+}
+
+// gm-control-api does not document the error codes of its referential
+// integrity refusals and none from a real server has been captured, so
+// IsInUse and IsMissingReference go by the status and the method alone.
+// The codes the fake gm-control-api sends are its own.
+
+// IsInUse reports whether err is gm-control-api refusing to delete an
+// object because other objects still refer to it: a 409 to a DELETE.
+func IsInUse(err error) bool {
+	apiErr, ok := asAPIError(err)
+	return ok && apiErr.StatusCode == http.StatusConflict && apiErr.Method == http.MethodDelete
+}
+
+// IsMissingReference reports whether err is gm-control-api rejecting an
+// object because a key in it names an object that does not exist: a 400 to
+// a POST. It cannot be told from other validation errors on the same
+// request, so the caller makes sure the reference is the only fault.
+func IsMissingReference(err error) bool {
+	apiErr, ok := asAPIError(err)
+	return ok && apiErr.StatusCode == http.StatusBadRequest && apiErr.Method == http.MethodPost
+}
+
+// IsUnsupported reports whether err is gm-control-api answering that it
+// has no such endpoint or does not support the method on it.
+func IsUnsupported(err error) bool {
+	apiErr, ok := asAPIError(err)
+	if !ok {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	api "github.com/deciphernow/gm-control-api/api"
+)
+
+type applyAction string
+
+const (
+	applyCreate applyAction = "create"
+	applyUpdate applyAction = "update"
+	applyDelete applyAction = "delete"
+)
+
+var applyActionSymbols = map[applyAction]string{
+	applyCreate: "+",
+	applyUpdate: "~",
+	applyDelete: "-",
+}
+
+// applyStep is one change that brings the control plane closer to a
+// declarative file. kind is nil for a step that creates a zone.
+type applyStep struct {
+	action   applyAction
+	zone     *applyZone
+	kind     *snapshotKind
+	identity string
+	fields   []string
+	desired  snapshotObject
+	live     liveObject
+}
+
+// applyZone is the state of one declared zone while a plan is made and
+// carried out. Until the plan runs, index holds placeholder keys for the
+// objects it will create.
+type applyZone struct {
+	name   string
+	zone   api.Zone
+	exists bool
+	index  *zoneIndex
+}
+
+// applyPlan is the ordered list of changes: creates and updates in
+// dependency order, then deletes in reverse dependency order.
+type applyPlan struct {
+	steps []applyStep
+}
+
+// planApply compares the desired state with what the control plane holds.
+// Only the zones named in desired are considered; within them every object
+// not declared is deleted, but zones that are not declared are left alone.
+func planApply(ctx context.Context, client *clientStruct, desired snapshot) (applyPlan, error) {
+	var plan applyPlan
+	var deletes []applyStep
+
+	for i := range desired.Zones {
+		declared := &desired.Zones[i]
+		zone := &applyZone{name: declared.Name, index: newZoneIndex()}
+
+		live := liveZone{objects: make(map[string][]liveObject)}
+		var err error
+		zone.zone, zone.exists, err = findZone(ctx, client, declared.Name)
+		if err != nil {
+			return plan, err
+		}
+		if zone.exists {
+			live, err = readLiveZone(ctx, client, zone.zone, true)
+			if err != nil {
+				return plan, errors.Wrapf(err, "zone %s", declared.Name)
+			}
+		} else {
+			plan.steps = append(plan.steps, applyStep{
+				action:   applyCreate,
+				zone:     zone,
+				identity: declared.Name,
+			})
+		}
+
+		// objects refer only to types earlier in snapshotKinds, so every
+		// name a declared object uses is in the index by the time it is
+		// resolved; undeclared objects are never added, since they are
+		// about to be deleted
+		var zoneDeletes []applyStep
+		for k := range snapshotKinds {
+			kind := &snapshotKinds[k]
+			segment := kind.resource.segment
+
+			byIdentity := make(map[string]liveObject)
+			for _, object := range live.objects[segment] {
+				byIdentity[kind.identity(object.snapshot)] = object
+			}
+
+			for _, object := range *kind.section(declared) {
+				identity := kind.identity(object)
+				existing, ok := byIdentity[identity]
+				delete(byIdentity, identity)
+
+				step := applyStep{
+					action:   applyCreate,
+					zone:     zone,
+					kind:     kind,
+					identity: identity,
+					desired:  object,
+				}
+				key := fmt.Sprintf("(new %s %s)", segment, identity)
+				if ok {
+					step.action = applyUpdate
+					step.live = existing
+					key = existing.key
+				}
+
+				step.fields, err = changedFields(kind, object, existing, zone)
+				if err != nil {
+					return plan, errors.Wrapf(err, "zone %s: %s %s", zone.name, segment, identity)
+				}
+				if name, ok := object["name"].(string); ok {
+					zone.index.add(segment, name, key)
+				}
+
+				switch {
+				case step.action == applyCreate:
+					step.fields = nil
+					plan.steps = append(plan.steps, step)
+				case len(step.fields) > 0:
+					plan.steps = append(plan.steps, step)
+				}
+			}
+
+			var undeclared []applyStep
+			for identity, object := range byIdentity {
+				undeclared = append(undeclared, applyStep{
+					action:   applyDelete,
+					zone:     zone,
+					kind:     kind,
+					identity: identity,
+					live:     object,
+				})
+			}
+			sort.Slice(undeclared, func(i, j int) bool { return undeclared[i].identity < undeclared[j].identity })
+			zoneDeletes = append(undeclared, zoneDeletes...)
+		}
+		deletes = append(deletes, zoneDeletes...)
+	}
+
+	plan.steps = append(plan.steps, deletes...)
+	return plan, nil
+}
+
+// changedFields returns the names of the fields the declared object sets
+// to values other than the live object's. Fields it leaves out are not
+// managed and keep their live values. Both sides are compared after a
+// round trip through the api type, so that members the declaration leaves
+// out of nested objects compare as their zero values.
+func changedFields(kind *snapshotKind, declared snapshotObject, existing liveObject, zone *applyZone) ([]string, error) {
+	fields, err := fromSnapshotObject(*kind, declared, zone.zone.ZoneKey, zone.index)
+	if err != nil {
+		return nil, err
+	}
+
+	merged, err := mergeFields(kind.resource, existing.fields, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	for field := range fields {
+		same, err := sameJSON(merged[field], existing.fields[field])
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			changed = append(changed, field)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// mergeFields overlays fields on the JSON form of a live object and
+// returns the result as its api type would encode it.
+func mergeFields(r resource, live snapshotObject, fields snapshotObject) (snapshotObject, error) {
+	merged := make(snapshotObject, len(live))
+	for field, value := range live {
+		merged[field] = value
+	}
+	for field, value := range fields {
+		merged[field] = value
+	}
+
+	object, err := fromFields(r, merged)
+	if err != nil {
+		return nil, err
+	}
+	return toFields(object)
+}
+
+// sameJSON reports whether two values have the same JSON form, once
+// decoded, so that 443 and 443.0 or differently ordered maps compare equal.
+func sameJSON(a interface{}, b interface{}) (bool, error) {
+	var decoded [2]interface{}
+	for i, value := range []interface{}{a, b} {
+		contents, err := json.Marshal(value)
+		if err != nil {
+			return false, errors.Wrap(err, "Marshal")
+		}
+		if err = json.Unmarshal(contents, &decoded[i]); err != nil {
+			return false, errors.Wrap(err, "Unmarshal")
+		}
+	}
+	return reflect.DeepEqual(decoded[0], decoded[1]), nil
+}
+
+// write prints the plan, one change per line.
+func (plan applyPlan) write(w io.Writer) error {
+	if len(plan.steps) == 0 {
+		_, err := fmt.Fprintln(w, "no changes")
+		return err
+	}
+
+	counts := make(map[applyAction]int)
+	for _, step := range plan.steps {
+		counts[step.action]++
+		line := fmt.Sprintf("%s zone %s", applyActionSymbols[step.action], step.zone.name)
+		if step.kind != nil {
+			line += fmt.Sprintf(": %s %s", step.kind.resource.segment, step.identity)
+		}
+		if len(step.fields) > 0 {
+			line += " (" + strings.Join(step.fields, ", ") + ")"
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d to create, %d to update, %d to delete\n",
+		counts[applyCreate], counts[applyUpdate], counts[applyDelete])
+	return err
+}
+
+// execute carries out the plan, stopping at the first failure.
+func (plan applyPlan) execute(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	for _, step := range plan.steps {
+		stepLogger := logger.With().Str("action", string(step.action)).
+			Str("zone", step.zone.name).Str("object", step.identity).Logger()
+
+		var err error
+		if step.kind == nil {
+			stepLogger.Info().Msg("creating zone")
+			step.zone.zone, err = createZone(ctx, client, step.zone.name)
+			step.zone.exists = err == nil
+		} else {
+			stepLogger = stepLogger.With().Str("type", step.kind.resource.segment).Logger()
+			stepLogger.Info().Msg("applying")
+			err = step.apply(ctx, client)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "%s zone %s %s", step.action, step.zone.name, step.identity)
+		}
+	}
+
+	return nil
+}
+
+func (step applyStep) apply(ctx context.Context, client *clientStruct) error {
+	rc := resourceClient{client: client, resource: step.kind.resource}
+
+	if step.action == applyDelete {
+		return rc.remove(ctx, step.live.value)
+	}
+
+	fields, err := fromSnapshotObject(*step.kind, step.desired, step.zone.zone.ZoneKey, step.zone.index)
+	if err != nil {
+		return err
+	}
+
+	if step.action == applyUpdate {
+		merged, err := mergeFields(step.kind.resource, step.live.fields, fields)
+		if err != nil {
+			return err
+		}
+		object, err := fromFields(step.kind.resource, merged)
+		if err != nil {
+			return err
+		}
+		return rc.update(ctx, object, step.kind.resource.newObject())
+	}
+
+	object, err := fromFields(step.kind.resource, fields)
+	if err != nil {
+		return err
+	}
+	result := step.kind.resource.newObject()
+	if err = rc.create(ctx, object, result); err != nil {
+		return err
+	}
+	key, _ := step.kind.resource.identify(result)
+	if name, ok := step.desired["name"].(string); ok {
+		step.zone.index.add(step.kind.resource.segment, name, key)
+	}
+
+	return nil
+}
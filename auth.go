@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// credentials are attached to every request the client sends. Any
+// combination may be set; empty fields are not sent. required records that
+// the control plane is expected to reject requests without them.
+type credentials struct {
+	required      bool
+	orgKeyHeader  string
+	orgKey        string
+	bearerToken   string
+	basicUser     string
+	basicPassword string
+	headers       http.Header
+}
+
+func (creds credentials) empty() bool {
+	return creds.orgKey == "" &&
+		creds.bearerToken == "" &&
+		creds.basicUser == "" &&
+		len(creds.headers) == 0
+}
+
+func (creds credentials) apply(request *http.Request) {
+	for name, values := range creds.headers {
+		for _, value := range values {
+			request.Header.Add(name, value)
+		}
+	}
+	if creds.orgKey != "" && creds.orgKeyHeader != "" {
+		request.Header.Set(creds.orgKeyHeader, creds.orgKey)
+	}
+	if creds.bearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+creds.bearerToken)
+	}
+	if creds.basicUser != "" {
+		request.SetBasicAuth(creds.basicUser, creds.basicPassword)
+	}
+}
+
+// missing describes the first configured credential that request lacks or
+// carries with the wrong value, or returns "" if it carries them all.
+// Bearer and basic credentials share the Authorization header; as in apply,
+// basic wins when both are set.
+func (creds credentials) missing(request *http.Request) string {
+	for name, values := range creds.headers {
+		if !sameValues(request.Header[http.CanonicalHeaderKey(name)], values) {
+			return "missing or wrong " + name + " header"
+		}
+	}
+	if creds.orgKey != "" && creds.orgKeyHeader != "" &&
+		request.Header.Get(creds.orgKeyHeader) != creds.orgKey {
+		return "missing or wrong " + creds.orgKeyHeader + " header"
+	}
+	if creds.basicUser != "" {
+		user, password, ok := request.BasicAuth()
+		if !ok || user != creds.basicUser || password != creds.basicPassword {
+			return "missing or wrong basic credentials"
+		}
+	} else if creds.bearerToken != "" &&
+		request.Header.Get("Authorization") != "Bearer "+creds.bearerToken {
+		return "missing or wrong bearer token"
+	}
+	return ""
+}
+
+func sameValues(got []string, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseHeaders reads custom headers written as "Name=value,Name=value".
+func parseHeaders(text string) (http.Header, error) {
+	headers := http.Header{}
+	for _, pair := range strings.Split(text, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, errors.Errorf("invalid header %q: want Name=value", pair)
+		}
+		headers.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return headers, nil
+}
+
+// IsUnauthorized reports whether err is gm-control-api refusing a request
+// for missing or bad credentials.
+func IsUnauthorized(err error) bool {
+	apiErr, ok := asAPIError(err)
+	return ok && (apiErr.StatusCode == http.StatusUnauthorized ||
+		apiErr.StatusCode == http.StatusForbidden)
+}
+
+// checkCredentialsRequired verifies that a secured control plane rejects
+// requests that lack the configured credentials. It is skipped unless
+// credentials are configured and marked as required.
+func checkCredentialsRequired(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	if !client.credentials.required || client.credentials.empty() {
+		return skipStep("credentials not configured as required")
+	}
+
+	logger.Debug().Msg("verifying that a request without credentials is rejected")
+	anonymous := *client
+	anonymous.credentials = credentials{required: true}
+	_, err := anonymous.zones().List(ctx)
+	if !IsUnauthorized(err) {
+		return errors.Errorf("request without credentials: expected 401 or 403, got: %v", err)
+	}
+
+	// a basic user replaces the bearer token in the Authorization header
+	if client.credentials.bearerToken != "" && client.credentials.basicUser == "" {
+		logger.Debug().Msg("verifying that a request with a bad bearer token is rejected")
+		forged := *client
+		forged.credentials.bearerToken = client.credentials.bearerToken + "-forged"
+		_, err = forged.zones().List(ctx)
+		if !IsUnauthorized(err) {
+			return errors.Errorf("request with bad bearer token: expected 401 or 403, got: %v", err)
+		}
+	}
+
+	if client.credentials.basicUser != "" {
+		logger.Debug().Msg("verifying that a request with a bad basic password is rejected")
+		forged := *client
+		forged.credentials.basicPassword = client.credentials.basicPassword + "-forged"
+		_, err = forged.zones().List(ctx)
+		if !IsUnauthorized(err) {
+			return errors.Errorf("request with bad basic password: expected 401 or 403, got: %v", err)
+		}
+	}
+
+	for name := range client.credentials.headers {
+		logger.Debug().Str("header", name).Msg("verifying that a request without a custom header is rejected")
+		noHeader := *client
+		noHeader.credentials.headers = http.Header{}
+		for other, values := range client.credentials.headers {
+			if other != name {
+				noHeader.credentials.headers[other] = values
+			}
+		}
+		_, err = noHeader.zones().List(ctx)
+		if !IsUnauthorized(err) {
+			return errors.Errorf("request without %s header: expected 401 or 403, got: %v", name, err)
+		}
+	}
+
+	if client.credentials.orgKey != "" && client.credentials.orgKeyHeader != "" {
+		logger.Debug().Msg("verifying that a request without the org key is rejected")
+		noOrg := *client
+		noOrg.credentials.orgKey = ""
+		_, err = noOrg.zones().List(ctx)
+		if !IsUnauthorized(err) {
+			return errors.Errorf("request without org key: expected 401 or 403, got: %v", err)
+		}
+	}
+
+	return nil
+}
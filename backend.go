@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/spf13/viper"
+
+	"github.com/dougfort/gm-control-api-integration/persister"
+)
+
+// backendResources are the object types the file persister stores, by
+// segment.
+var backendResources = map[string]resource{
+	"zone":         zoneResource,
+	"cluster":      clusterResource,
+	"domain":       domainResource,
+	"listener":     listenerResource,
+	"shared_rules": sharedRulesResource,
+	"route":        routeResource,
+	"proxy":        proxyResource,
+}
+
+// backendScenario checks the file persister's backend.json against the
+// API once the other scenarios have run.
+func backendScenario() scenario {
+	return scenario{
+		name:        "backend",
+		description: "file persister contents",
+		steps: []step{
+			{
+				name:        "compareBackend",
+				description: "compare backend.json with every object the API reports",
+				tags:        []string{"backend", "persister"},
+				run:         compareBackend,
+			},
+		},
+	}
+}
+
+// compareBackend reads the persister file named by
+// GM_CONTROL_API_BACKEND_PATH and checks that it is valid and holds
+// exactly the objects the API lists, with the same checksums and, compared
+// as api values, the same fields. The persister may write some time after
+// a request returns, so the comparison is repeated until it passes or
+// BACKEND_SETTLE_TIMEOUT ends.
+func compareBackend(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	path := viper.GetString("gm_control_api_backend_path")
+	if path == "" {
+		return skipStep("GM_CONTROL_API_BACKEND_PATH is not set")
+	}
+
+	deadline := time.Now().Add(viper.GetDuration("backend_settle_timeout"))
+	for {
+		differences, err := backendDifferences(ctx, client, path)
+		if err != nil {
+			return err
+		}
+		if len(differences) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			for _, difference := range differences {
+				logger.Error().Str("path", path).Msg(difference)
+			}
+			return errors.Errorf("%s: %d differences from the API, first: %s",
+				path, len(differences), differences[0])
+		}
+
+		logger.Debug().Int("differences", len(differences)).Msg("waiting for the persister")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// backendDifferences returns one line for every problem in the persister
+// file and every object that differs between it and the API.
+func backendDifferences(ctx context.Context, client *clientStruct, path string) ([]string, error) {
+	backend, err := persister.Read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var differences []string
+	for _, problem := range backend.Validate() {
+		differences = append(differences, problem.String())
+	}
+
+	for _, segment := range persister.Segments() {
+		r := backendResources[segment]
+		rc := resourceClient{client: client, resource: r}
+
+		var listed []json.RawMessage
+		if err = rc.list(ctx, []interface{}{}, &listed); err != nil {
+			return nil, errors.Wrapf(err, "list %s", segment)
+		}
+
+		stored := backend.Objects[segment]
+		seen := make(map[string]bool)
+		for _, message := range listed {
+			value := r.newObject()
+			if err = json.Unmarshal(message, value); err != nil {
+				return nil, errors.Wrapf(err, "decode %s", segment)
+			}
+			key, checksum := r.identify(value)
+			seen[key] = true
+
+			object, ok := stored[key]
+			if !ok {
+				differences = append(differences, fmt.Sprintf("%s %s is missing from the file", segment, key))
+				continue
+			}
+			if storedChecksum, _ := object["checksum"].(string); storedChecksum != checksum {
+				differences = append(differences, fmt.Sprintf("%s %s: checksum %s in the file, %s from the API",
+					segment, key, storedChecksum, checksum))
+				continue
+			}
+			storedValue, err := fromFields(r, snapshotObject(object))
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s %s in the file", segment, key)
+			}
+			if !r.equal(value, storedValue) {
+				differences = append(differences, fmt.Sprintf("%s %s: fields in the file differ from the API", segment, key))
+			}
+		}
+
+		for _, key := range backend.Keys(segment) {
+			if !seen[key] {
+				differences = append(differences, fmt.Sprintf("%s %s is in the file but not the API", segment, key))
+			}
+		}
+	}
+
+	return differences, nil
+}
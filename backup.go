@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// backup asks gm-control-api to back up every object. It returns the
+// backup when the response carries one, either as the result member of
+// the envelope or as a plain body, and nothing when the server keeps the
+// backup itself.
+func (client *clientStruct) backup(ctx context.Context) (json.RawMessage, error) {
+	request, err := client.newRequest(ctx, "POST", "/admin/backup", nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "newRequest")
+	}
+
+	var contents json.RawMessage
+	envelope, body, err := client.doEnvelope(request)
+	switch apiErr, ok := asAPIError(err); {
+	case ok && apiErr.StatusCode == http.StatusOK:
+		// not a JSON object: the body is the backup
+		contents = apiErr.Body
+	case err != nil:
+		return nil, err
+	default:
+		result, ok := envelope["result"]
+		if !ok {
+			// an object without a result member: the body is the backup
+			result = body
+		}
+		contents = result
+	}
+
+	if trimmed := bytes.TrimSpace(contents); len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return nil, nil
+	}
+	return contents, nil
+}
+
+// restore replaces every object gm-control-api holds with those in a
+// backup returned by backup.
+func (client *clientStruct) restore(ctx context.Context, contents json.RawMessage) error {
+	request, err := client.newRequest(ctx, "POST", "/admin/restore", nil, contents)
+	if err != nil {
+		return errors.Wrap(err, "newRequest")
+	}
+
+	return client.doJSON(request, nil)
+}
+
+// backupRoundTrip is the state shared by the steps of the backup
+// scenario: the objects as they were when backed up, and the backup.
+// allowRestore must be set for the wipe and restore steps to run, since a
+// restore replaces every object on the control plane, not just the run's.
+type backupRoundTrip struct {
+	model        *Model
+	allowRestore bool
+	before       liveZone
+	contents     json.RawMessage
+}
+
+// backupScenario builds and edits a set of objects, backs up the control
+// plane, deletes the objects, restores the backup and checks that every
+// object came back with the same key, checksum and fields.
+func backupScenario(trip *backupRoundTrip) scenario {
+	model := trip.model
+	steps := loadSteps(model)
+	steps = append(steps, []step{
+		{
+			name:        "modifyCluster",
+			description: "edit the cluster and add and remove an instance",
+			dependsOn:   []string{"loadCluster"},
+			tags:        []string{"cluster", "edit", "instance"},
+			run:         model.modifyCluster,
+		},
+		{
+			name:        "modifyDomain",
+			description: "edit the domain port",
+			dependsOn:   []string{"loadDomain"},
+			tags:        []string{"domain", "edit"},
+			run:         model.modifyDomain,
+		},
+		{
+			name:        "modifySharedRules",
+			description: "edit the shared rules properties",
+			dependsOn:   []string{"loadSharedRules"},
+			tags:        []string{"shared_rules", "edit"},
+			run:         model.modifySharedRules,
+		},
+		{
+			name:        "modifyRoute",
+			description: "edit the route prefix rewrite",
+			dependsOn:   []string{"loadRoute"},
+			tags:        []string{"route", "edit"},
+			run:         model.modifyRoute,
+		},
+		{
+			name:        "modifyProxy",
+			description: "edit the proxy active filters",
+			dependsOn:   []string{"loadProxy"},
+			tags:        []string{"proxy", "edit"},
+			run:         model.modifyProxy,
+		},
+		{
+			name:        "takeBackup",
+			description: "record every object in the zone and back up the control plane",
+			dependsOn:   []string{"loadProxy"},
+			tags:        []string{"backup"},
+			run:         trip.takeBackup,
+		},
+		{
+			name:        "wipeZone",
+			description: "delete every object in the zone and the zone itself",
+			dependsOn:   []string{"takeBackup"},
+			tags:        []string{"backup", "delete"},
+			run:         trip.wipeZone,
+		},
+		{
+			name:        "restoreBackup",
+			description: "restore the backup",
+			dependsOn:   []string{"wipeZone"},
+			tags:        []string{"backup", "restore"},
+			run:         trip.restoreBackup,
+		},
+		{
+			name:        "verifyRestore",
+			description: "check every object came back with the same key, checksum and fields",
+			dependsOn:   []string{"restoreBackup"},
+			tags:        []string{"backup", "restore"},
+			run:         trip.verifyRestore,
+		},
+	}...)
+
+	return scenario{
+		name:        "backup",
+		description: "back up, wipe and restore a set of objects",
+		steps:       steps,
+	}
+}
+
+func (trip *backupRoundTrip) takeBackup(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	var err error
+	trip.before, err = readLiveZone(ctx, client, trip.model.Zone, true)
+	if err != nil {
+		return errors.Wrap(err, "readLiveZone")
+	}
+	if trip.before.zone, err = getZoneByKey(ctx, client, trip.model.Zone.ZoneKey); err != nil {
+		return errors.Wrap(err, "getZoneByKey")
+	}
+
+	logger.Debug().Msg("backing up")
+	trip.contents, err = client.backup(ctx)
+	if err != nil {
+		if IsUnsupported(err) {
+			return skipStep("gm-control-api has no backup endpoint: %s", err)
+		}
+		return errors.Wrap(err, "backup")
+	}
+	logger.Debug().Int("bytes", len(trip.contents)).Msg("backed up")
+
+	return nil
+}
+
+// wipeZone deletes the zone and its objects, dependents first. It skips
+// when restores are not allowed or the backup came back empty, since
+// nothing could restore them.
+func (trip *backupRoundTrip) wipeZone(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	if !trip.allowRestore {
+		return skipStep("restore replaces every object on the control plane; set ALLOW_RESTORE=true to run it")
+	}
+	if len(trip.contents) == 0 {
+		return skipStep("the backup was kept by the server, so it cannot be restored from here")
+	}
+
+	for i := len(snapshotKinds) - 1; i >= 0; i-- {
+		r := snapshotKinds[i].resource
+		rc := resourceClient{client: client, resource: r}
+		for _, object := range trip.before.objects[r.segment] {
+			logger.Debug().Str("type", r.segment).Str("key", object.key).Msg("deleting")
+			if err := rc.remove(ctx, object.value); err != nil {
+				return errors.Wrapf(err, "delete %s %s", r.segment, object.key)
+			}
+			trip.model.cleanup.forgetObject(r, object.key)
+		}
+	}
+
+	if err := client.zones().Delete(ctx, trip.before.zone); err != nil {
+		return errors.Wrap(err, "delete zone")
+	}
+	trip.model.cleanup.forgetObject(zoneResource, string(trip.before.zone.ZoneKey))
+
+	if _, err := getZoneByKey(ctx, client, trip.before.zone.ZoneKey); !IsNotFound(err) {
+		return errors.Errorf("zone still readable after delete: %v", err)
+	}
+
+	return nil
+}
+
+func (trip *backupRoundTrip) restoreBackup(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	if !trip.allowRestore {
+		return skipStep("restore replaces every object on the control plane; set ALLOW_RESTORE=true to run it")
+	}
+
+	logger.Warn().Msg("restoring: replacing every object on the control plane")
+	if err := client.restore(ctx, trip.contents); err != nil {
+		if IsUnsupported(err) {
+			return skipStep("gm-control-api has no restore endpoint: %s", err)
+		}
+		return errors.Wrap(err, "restore")
+	}
+
+	// registered zone first, so that cleanup deletes it last
+	trip.model.cleanup.registerObject(client.zones().resourceClient, string(trip.before.zone.ZoneKey))
+	for _, kind := range snapshotKinds {
+		rc := resourceClient{client: client, resource: kind.resource}
+		for _, object := range trip.before.objects[kind.resource.segment] {
+			trip.model.cleanup.registerObject(rc, object.key)
+		}
+	}
+
+	return nil
+}
+
+func (trip *backupRoundTrip) verifyRestore(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	zone, err := getZoneByKey(ctx, client, trip.before.zone.ZoneKey)
+	if err != nil {
+		return errors.Wrap(err, "getZoneByKey")
+	}
+	if !zone.Equals(trip.before.zone) {
+		return errors.Errorf("restored zone %+v, backed up %+v", zone, trip.before.zone)
+	}
+
+	after, err := readLiveZone(ctx, client, zone, true)
+	if err != nil {
+		return errors.Wrap(err, "readLiveZone")
+	}
+
+	var problems []string
+	for _, kind := range snapshotKinds {
+		r := kind.resource
+		restored := make(map[string]liveObject)
+		for _, object := range after.objects[r.segment] {
+			restored[object.key] = object
+		}
+
+		for _, object := range trip.before.objects[r.segment] {
+			found, ok := restored[object.key]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s %s was not restored", r.segment, object.key))
+				continue
+			}
+			delete(restored, object.key)
+			_, checksum := r.identify(object.value)
+			_, foundChecksum := r.identify(found.value)
+			switch {
+			case foundChecksum != checksum:
+				problems = append(problems, fmt.Sprintf("%s %s: checksum %s, backed up %s",
+					r.segment, object.key, foundChecksum, checksum))
+			case !r.equal(found.value, object.value):
+				problems = append(problems, fmt.Sprintf("%s %s: restored %+v, backed up %+v",
+					r.segment, object.key, found.value, object.value))
+			}
+		}
+		for key := range restored {
+			problems = append(problems, fmt.Sprintf("%s %s was not backed up", r.segment, key))
+		}
+	}
+
+	for _, problem := range problems {
+		logger.Error().Msg(problem)
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("%d objects did not survive the round trip, first: %s", len(problems), problems[0])
+	}
+
+	logger.Debug().Msg("every object restored intact")
+	return nil
+}
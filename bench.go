@@ -0,0 +1,612 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	api "github.com/deciphernow/gm-control-api/api"
+	service "github.com/deciphernow/gm-control-api/api/service"
+)
+
+// benchOperation is one kind of request the bench drives against routes.
+type benchOperation string
+
+const (
+	benchCreate benchOperation = "create"
+	benchGet    benchOperation = "get"
+	benchQuery  benchOperation = "query"
+	benchEdit   benchOperation = "edit"
+	benchDelete benchOperation = "delete"
+)
+
+var benchOperations = []benchOperation{benchCreate, benchGet, benchQuery, benchEdit, benchDelete}
+
+// benchSettings are the bench command's flags. zones are seeded with
+// clusters, domains and routes each; routes are spread over the domains,
+// and their shared rules split traffic over the clusters.
+type benchSettings struct {
+	zones      int
+	clusters   int
+	domains    int
+	routes     int
+	mix        map[benchOperation]int
+	rate       float64
+	workers    int
+	duration   time.Duration
+	operations int
+	seed       int64
+	keep       bool
+}
+
+// parseBenchMix parses "create=1,get=5" into a weight per operation.
+// Operations left out get no weight.
+func parseBenchMix(text string) (map[benchOperation]int, error) {
+	mix := make(map[benchOperation]int)
+	total := 0
+	for _, pair := range parseTags(text) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid mix entry %q: want operation=weight", pair)
+		}
+		operation := benchOperation(strings.TrimSpace(parts[0]))
+		known := false
+		for _, candidate := range benchOperations {
+			known = known || candidate == operation
+		}
+		if !known {
+			return nil, errors.Errorf("unknown operation %q in mix", operation)
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || weight < 0 {
+			return nil, errors.Errorf("invalid weight in mix entry %q", pair)
+		}
+		mix[operation] = weight
+		total += weight
+	}
+	if total == 0 {
+		return nil, errors.New("mix gives no operation any weight")
+	}
+	return mix, nil
+}
+
+// benchDomain is a seeded domain, with the shared rules its zone's routes
+// use.
+type benchDomain struct {
+	zone        api.Zone
+	domain      api.Domain
+	sharedRules api.SharedRules
+}
+
+// benchRoutes is the pool of routes the operations act on. A route being
+// edited or deleted is checked out, so that no two workers write it at
+// once.
+type benchRoutes struct {
+	mu   sync.Mutex
+	idle []api.Route
+}
+
+func (pool *benchRoutes) peek(pick int) (api.Route, bool) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if len(pool.idle) == 0 {
+		return api.Route{}, false
+	}
+	return pool.idle[pick%len(pool.idle)], true
+}
+
+func (pool *benchRoutes) checkout(pick int) (api.Route, bool) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if len(pool.idle) == 0 {
+		return api.Route{}, false
+	}
+	i := pick % len(pool.idle)
+	route := pool.idle[i]
+	pool.idle[i] = pool.idle[len(pool.idle)-1]
+	pool.idle = pool.idle[:len(pool.idle)-1]
+	return route, true
+}
+
+func (pool *benchRoutes) checkin(route api.Route) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	pool.idle = append(pool.idle, route)
+}
+
+// benchRecorder collects the latencies and failures of one operation.
+type benchRecorder struct {
+	mu         sync.Mutex
+	latencies  []time.Duration
+	errors     int
+	skipped    int
+	firstError string
+}
+
+func (recorder *benchRecorder) record(latency time.Duration, err error) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	recorder.latencies = append(recorder.latencies, latency)
+	if err != nil {
+		recorder.errors++
+		if recorder.firstError == "" {
+			recorder.firstError = err.Error()
+		}
+	}
+}
+
+func (recorder *benchRecorder) skip() {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	recorder.skipped++
+}
+
+// bench seeds a control plane, drives the operation mix against it and
+// reports latencies.
+type bench struct {
+	logger   zerolog.Logger
+	client   *clientStruct
+	settings benchSettings
+	names    namer
+
+	zones           []api.Zone
+	sharedRules     []api.SharedRules
+	clusters        []api.Cluster
+	domains         []benchDomain
+	routes          benchRoutes
+	created         int64
+	recorders       map[benchOperation]*benchRecorder
+	seedTime        time.Duration
+	runTime         time.Duration
+	runStarted      time.Time
+	cleanupFailures int
+}
+
+func newBench(logger zerolog.Logger, client *clientStruct, settings benchSettings, names namer) *bench {
+	b := bench{
+		logger:    logger,
+		client:    writerClient(client),
+		settings:  settings,
+		names:     names,
+		recorders: make(map[benchOperation]*benchRecorder),
+	}
+	for _, operation := range benchOperations {
+		b.recorders[operation] = &benchRecorder{}
+	}
+	return &b
+}
+
+// parallel calls do for every i below n from the bench's workers and
+// returns the first error.
+func (b *bench) parallel(ctx context.Context, n int, do func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	indexes := make(chan int)
+	errs := make(chan error, b.settings.workers)
+	var wg sync.WaitGroup
+	for w := 0; w < b.settings.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := do(ctx, i); err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+FEED:
+	for i := 0; i < n; i++ {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break FEED
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// seed creates the zones, each with its clusters, the shared rules that
+// split traffic over them, domains and routes.
+func (b *bench) seed(ctx context.Context) error {
+	started := time.Now()
+	defer func() { b.seedTime = time.Since(started) }()
+
+	var mu sync.Mutex
+	for z := 0; z < b.settings.zones; z++ {
+		zone, err := createZone(ctx, b.client, b.names.name(fmt.Sprintf("zone-%d", z)))
+		if err != nil {
+			return errors.Wrap(err, "createZone")
+		}
+		b.zones = append(b.zones, zone)
+
+		var zoneClusters []api.Cluster
+		err = b.parallel(ctx, b.settings.clusters, func(ctx context.Context, i int) error {
+			cluster, err := createCluster(ctx, b.client, zone, b.names.name(fmt.Sprintf("cluster-%d-%d", z, i)))
+			if err != nil {
+				return errors.Wrap(err, "createCluster")
+			}
+			mu.Lock()
+			zoneClusters = append(zoneClusters, cluster)
+			mu.Unlock()
+			return nil
+		})
+		b.clusters = append(b.clusters, zoneClusters...)
+		if err != nil {
+			return err
+		}
+		sort.Slice(zoneClusters, func(i, j int) bool { return zoneClusters[i].Name < zoneClusters[j].Name })
+
+		sharedRules, err := createSplitSharedRules(ctx, b.client, zone,
+			b.names.name(fmt.Sprintf("shared-rules-%d", z)), zoneClusters)
+		if err != nil {
+			return errors.Wrap(err, "createSplitSharedRules")
+		}
+		b.sharedRules = append(b.sharedRules, sharedRules)
+
+		var zoneDomains []benchDomain
+		err = b.parallel(ctx, b.settings.domains, func(ctx context.Context, i int) error {
+			domain, err := createDomain(ctx, b.client, zone, b.names.name(fmt.Sprintf("domain-%d-%d", z, i)))
+			if err != nil {
+				return errors.Wrap(err, "createDomain")
+			}
+			mu.Lock()
+			zoneDomains = append(zoneDomains, benchDomain{zone: zone, domain: domain, sharedRules: sharedRules})
+			mu.Unlock()
+			return nil
+		})
+		b.domains = append(b.domains, zoneDomains...)
+		if err != nil {
+			return err
+		}
+		sort.Slice(zoneDomains, func(i, j int) bool { return zoneDomains[i].domain.Name < zoneDomains[j].domain.Name })
+
+		err = b.parallel(ctx, b.settings.routes, func(ctx context.Context, i int) error {
+			target := zoneDomains[i%len(zoneDomains)]
+			route, err := createRoute(ctx, b.client, zone, target.domain, target.sharedRules,
+				b.names.path(fmt.Sprintf("/bench/%d/%d", z, i)))
+			if err != nil {
+				return errors.Wrap(err, "createRoute")
+			}
+			b.routes.checkin(route)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		b.logger.Info().Str("zone", zone.Name).Int("clusters", b.settings.clusters).
+			Int("domains", b.settings.domains).Int("routes", b.settings.routes).Msg("seeded zone")
+	}
+
+	return nil
+}
+
+type benchJob struct {
+	operation benchOperation
+	pick      int
+}
+
+// run drives the operation mix from the workers until the duration ends
+// or the operation count is reached. A seeded generator picks the sequence
+// of operations and their targets, so runs with the same seed make the
+// same requests in the same order, give or take worker scheduling.
+func (b *bench) run(ctx context.Context) {
+	rng := rand.New(rand.NewSource(b.settings.seed))
+	var weighted []benchOperation
+	for _, operation := range benchOperations {
+		for i := 0; i < b.settings.mix[operation]; i++ {
+			weighted = append(weighted, operation)
+		}
+	}
+
+	dispatchCtx := ctx
+	if b.settings.duration > 0 {
+		var cancel context.CancelFunc
+		dispatchCtx, cancel = context.WithTimeout(ctx, b.settings.duration)
+		defer cancel()
+	}
+
+	jobs := make(chan benchJob)
+	go func() {
+		defer close(jobs)
+		var tick <-chan time.Time
+		if b.settings.rate > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / b.settings.rate))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for n := 0; b.settings.operations == 0 || n < b.settings.operations; n++ {
+			if tick != nil {
+				select {
+				case <-tick:
+				case <-dispatchCtx.Done():
+					return
+				}
+			}
+			job := benchJob{operation: weighted[rng.Intn(len(weighted))], pick: rng.Int()}
+			select {
+			case jobs <- job:
+			case <-dispatchCtx.Done():
+				return
+			}
+		}
+	}()
+
+	b.runStarted = time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < b.settings.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				b.do(ctx, job)
+			}
+		}()
+	}
+	wg.Wait()
+	b.runTime = time.Since(b.runStarted)
+}
+
+// do makes one request, timing only the request itself.
+func (b *bench) do(ctx context.Context, job benchJob) {
+	recorder := b.recorders[job.operation]
+
+	switch job.operation {
+	case benchCreate:
+		target := b.domains[job.pick%len(b.domains)]
+		path := b.names.path(fmt.Sprintf("/bench/created/%d", atomic.AddInt64(&b.created, 1)))
+		started := time.Now()
+		route, err := createRoute(ctx, b.client, target.zone, target.domain, target.sharedRules, path)
+		recorder.record(time.Since(started), err)
+		if err == nil {
+			b.routes.checkin(route)
+		}
+
+	case benchGet:
+		route, ok := b.routes.peek(job.pick)
+		if !ok {
+			recorder.skip()
+			return
+		}
+		started := time.Now()
+		_, err := getRouteByKey(ctx, b.client, route.RouteKey)
+		recorder.record(time.Since(started), err)
+
+	case benchQuery:
+		target := b.domains[job.pick%len(b.domains)]
+		started := time.Now()
+		_, err := b.client.routes().List(ctx, service.RouteFilter{DomainKey: target.domain.DomainKey})
+		recorder.record(time.Since(started), err)
+
+	case benchEdit:
+		route, ok := b.routes.checkout(job.pick)
+		if !ok {
+			recorder.skip()
+			return
+		}
+		edited := route
+		edited.PrefixRewrite = fmt.Sprintf("/rewrite/%d", job.pick%1000)
+		started := time.Now()
+		result, err := editRoute(ctx, b.client, edited)
+		recorder.record(time.Since(started), err)
+		if err == nil {
+			route = result
+		}
+		b.routes.checkin(route)
+
+	case benchDelete:
+		route, ok := b.routes.checkout(job.pick)
+		if !ok {
+			recorder.skip()
+			return
+		}
+		started := time.Now()
+		err := deleteRoute(ctx, b.client, route)
+		recorder.record(time.Since(started), err)
+		if err != nil {
+			b.routes.checkin(route)
+		}
+	}
+}
+
+// cleanup deletes everything the bench created, dependents first, and
+// returns the number of failures. Objects left unvisited because ctx ended
+// count as one failure for each batch they belong to.
+func (b *bench) cleanup(ctx context.Context) int {
+	var mu sync.Mutex
+	remove := func(description string, err error) {
+		if err != nil && !IsNotFound(err) {
+			b.logger.Error().Err(err).Str("object", description).Msg("cleanup failed")
+			mu.Lock()
+			b.cleanupFailures++
+			mu.Unlock()
+		}
+	}
+	abandoned := func(objects string, err error) {
+		if err != nil {
+			b.logger.Error().Err(err).Str("objects", objects).Msg("cleanup abandoned")
+			b.cleanupFailures++
+		}
+	}
+
+	routes := b.routes.idle
+	abandoned("routes", b.parallel(ctx, len(routes), func(ctx context.Context, i int) error {
+		remove("route "+string(routes[i].RouteKey), deleteRoute(ctx, b.client, routes[i]))
+		return nil
+	}))
+	abandoned("domains", b.parallel(ctx, len(b.domains), func(ctx context.Context, i int) error {
+		remove("domain "+string(b.domains[i].domain.DomainKey), deleteDomain(ctx, b.client, b.domains[i].domain))
+		return nil
+	}))
+
+	for _, sharedRules := range b.sharedRules {
+		remove("shared_rules "+string(sharedRules.SharedRulesKey), deleteSharedRules(ctx, b.client, sharedRules))
+	}
+	abandoned("clusters", b.parallel(ctx, len(b.clusters), func(ctx context.Context, i int) error {
+		remove("cluster "+string(b.clusters[i].ClusterKey), deleteCluster(ctx, b.client, b.clusters[i]))
+		return nil
+	}))
+	for _, zone := range b.zones {
+		remove("zone "+string(zone.ZoneKey), deleteZone(ctx, b.client, zone))
+	}
+
+	b.logger.Info().Int("failures", b.cleanupFailures).Msg("cleaned up")
+	return b.cleanupFailures
+}
+
+type benchReport struct {
+	RunID          string                          `json:"run_id"`
+	Started        time.Time                       `json:"started"`
+	Settings       benchReportSettings             `json:"settings"`
+	SeedDurationMS int64                           `json:"seed_duration_ms"`
+	DurationMS     int64                           `json:"duration_ms"`
+	Operations     map[string]benchOperationReport `json:"operations"`
+	Total          benchOperationReport            `json:"total"`
+}
+
+type benchReportSettings struct {
+	Zones      int            `json:"zones"`
+	Clusters   int            `json:"clusters_per_zone"`
+	Domains    int            `json:"domains_per_zone"`
+	Routes     int            `json:"routes_per_zone"`
+	Mix        map[string]int `json:"mix"`
+	Rate       float64        `json:"rate"`
+	Workers    int            `json:"workers"`
+	DurationMS int64          `json:"duration_ms"`
+	Operations int            `json:"operations"`
+	Seed       int64          `json:"seed"`
+}
+
+// benchOperationReport summarizes one operation. Latencies are in
+// milliseconds and include failed requests.
+type benchOperationReport struct {
+	Count      int     `json:"count"`
+	Errors     int     `json:"errors"`
+	Skipped    int     `json:"skipped"`
+	Throughput float64 `json:"throughput_per_second"`
+	MeanMS     float64 `json:"mean_ms"`
+	P50MS      float64 `json:"p50_ms"`
+	P95MS      float64 `json:"p95_ms"`
+	P99MS      float64 `json:"p99_ms"`
+	MaxMS      float64 `json:"max_ms"`
+	FirstError string  `json:"first_error,omitempty"`
+}
+
+func (b *bench) report(runID string) benchReport {
+	report := benchReport{
+		RunID:          runID,
+		Started:        b.runStarted.UTC(),
+		SeedDurationMS: milliseconds(b.seedTime),
+		DurationMS:     milliseconds(b.runTime),
+		Operations:     make(map[string]benchOperationReport),
+		Settings: benchReportSettings{
+			Zones:      b.settings.zones,
+			Clusters:   b.settings.clusters,
+			Domains:    b.settings.domains,
+			Routes:     b.settings.routes,
+			Mix:        make(map[string]int),
+			Rate:       b.settings.rate,
+			Workers:    b.settings.workers,
+			DurationMS: milliseconds(b.settings.duration),
+			Operations: b.settings.operations,
+			Seed:       b.settings.seed,
+		},
+	}
+
+	var all benchRecorder
+	for _, operation := range benchOperations {
+		recorder := b.recorders[operation]
+		report.Settings.Mix[string(operation)] = b.settings.mix[operation]
+		report.Operations[string(operation)] = summarizeLatencies(recorder, b.runTime)
+
+		all.latencies = append(all.latencies, recorder.latencies...)
+		all.errors += recorder.errors
+		all.skipped += recorder.skipped
+		if all.firstError == "" {
+			all.firstError = recorder.firstError
+		}
+	}
+	report.Total = summarizeLatencies(&all, b.runTime)
+
+	return report
+}
+
+func summarizeLatencies(recorder *benchRecorder, elapsed time.Duration) benchOperationReport {
+	summary := benchOperationReport{
+		Count:      len(recorder.latencies),
+		Errors:     recorder.errors,
+		Skipped:    recorder.skipped,
+		FirstError: recorder.firstError,
+	}
+	if summary.Count == 0 {
+		return summary
+	}
+
+	sorted := append([]time.Duration(nil), recorder.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, latency := range sorted {
+		total += latency
+	}
+	if elapsed > 0 {
+		summary.Throughput = float64(summary.Count) / elapsed.Seconds()
+	}
+	summary.MeanMS = durationMS(total / time.Duration(len(sorted)))
+	summary.P50MS = durationMS(percentile(sorted, 50))
+	summary.P95MS = durationMS(percentile(sorted, 95))
+	summary.P99MS = durationMS(percentile(sorted, 99))
+	summary.MaxMS = durationMS(sorted[len(sorted)-1])
+	return summary
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func durationMS(duration time.Duration) float64 {
+	return float64(duration) / float64(time.Millisecond)
+}
+
+func writeBenchReport(w io.Writer, report benchReport) error {
+	contents, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "MarshalIndent")
+	}
+	_, err = w.Write(append(contents, '\n'))
+	return err
+}
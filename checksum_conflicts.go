@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	api "github.com/deciphernow/gm-control-api/api"
+)
+
+// The stale checksum steps each fetch an object twice, edit one copy and
+// then verify that gm-control-api refuses to edit or delete through the
+// other copy, whose checksum is now out of date. The model is left holding
+// the current version so the delete steps can still remove it. Deletes of
+// the zone, domains, listeners and shared rules are tried on scratch
+// objects instead, since the model's are referenced by others and a server
+// may report that before the checksum.
+
+func expectConflict(operation string, err error) error {
+	if err == nil {
+		return errors.Errorf("%s with stale checksum succeeded", operation)
+	}
+	if !IsConflict(err) {
+		return errors.Wrapf(err, "%s with stale checksum: expected a checksum conflict", operation)
+	}
+	return nil
+}
+
+// staleDelete creates a scratch object that nothing refers to, edits it
+// through one copy, checks that deleting through a stale copy is refused as
+// a conflict, and deletes it.
+func (model *Model) staleDelete(
+	ctx context.Context,
+	logger zerolog.Logger,
+	client *clientStruct,
+	r resource,
+	create func() (interface{}, error),
+	edit func(object interface{}),
+) error {
+	logger.Debug().Str("type", r.segment).Msg("creating a scratch object to delete through a stale copy")
+	object, err := create()
+	if err != nil {
+		return errors.Wrapf(err, "create scratch %s", r.segment)
+	}
+	rc := resourceClient{client: client, resource: r}
+	key, _ := r.identify(object)
+	model.cleanup.registerObject(rc, key)
+
+	stale := r.newObject()
+	if err = rc.get(ctx, key, stale); err != nil {
+		return errors.Wrapf(err, "get scratch %s", r.segment)
+	}
+	edit(object)
+	fresh := r.newObject()
+	if err = rc.update(ctx, object, fresh); err != nil {
+		return errors.Wrapf(err, "edit scratch %s", r.segment)
+	}
+
+	if err = expectConflict("delete "+r.segment, rc.remove(ctx, stale)); err != nil {
+		return err
+	}
+
+	if err = rc.remove(ctx, fresh); err != nil {
+		return errors.Wrapf(err, "delete scratch %s", r.segment)
+	}
+	model.cleanup.forgetObject(r, key)
+	return nil
+}
+
+func (model *Model) staleZoneChecksum(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	logger.Debug().Msg("fetching the zone twice")
+	stale, err := getZoneByKey(ctx, client, model.Zone.ZoneKey)
+	if err != nil {
+		return errors.Wrap(err, "getZoneByKey")
+	}
+	fresh, err := getZoneByKey(ctx, client, model.Zone.ZoneKey)
+	if err != nil {
+		return errors.Wrap(err, "getZoneByKey")
+	}
+
+	logger.Debug().Msg("renaming the zone through one copy")
+	fresh.Name = model.names.zone + "-renamed"
+	fresh, err = client.zones().Update(ctx, fresh)
+	if err != nil {
+		return errors.Wrap(err, "Update zone")
+	}
+	model.Zone = fresh
+
+	logger.Debug().Msg("writing through the stale copy")
+	stale.Name = model.names.zone + "-stale"
+	_, err = client.zones().Update(ctx, stale)
+	if err = expectConflict("zone Update", err); err != nil {
+		return err
+	}
+
+	logger.Debug().Msg("restoring the zone name")
+	fresh.Name = model.names.zone
+	model.Zone, err = client.zones().Update(ctx, fresh)
+	if err != nil {
+		return errors.Wrap(err, "Update zone")
+	}
+
+	return model.staleDelete(ctx, logger, client, zoneResource,
+		func() (interface{}, error) {
+			zone, err := createZone(ctx, client, model.names.zone+"-stale-delete")
+			return &zone, err
+		},
+		func(object interface{}) { object.(*api.Zone).Name += "-renamed" },
+	)
+}
+
+func (model *Model) staleClusterChecksum(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	logger.Debug().Msg("fetching the cluster twice")
+	stale, err := getClusterByKey(ctx, client, model.Cluster1.ClusterKey)
+	if err != nil {
+		return errors.Wrap(err, "getClusterByKey")
+	}
+	fresh, err := getClusterByKey(ctx, client, model.Cluster1.ClusterKey)
+	if err != nil {
+		return errors.Wrap(err, "getClusterByKey")
+	}
+
+	logger.Debug().Msg("editing the cluster through one copy")
+	maxConnections := 7
+	fresh.CircuitBreakers = &api.CircuitBreakers{MaxConnections: &maxConnections}
+	model.Cluster1, err = editCluster(ctx, client, fresh)
+	if err != nil {
+		return errors.Wrap(err, "editCluster")
+	}
+
+	logger.Debug().Msg("writing through the stale copy")
+	_, err = editCluster(ctx, client, stale)
+	if err = expectConflict("editCluster", err); err != nil {
+		return err
+	}
+	if err = expectConflict("deleteCluster", deleteCluster(ctx, client, stale)); err != nil {
+		return err
+	}
+
+	instance := api.Instance{Host: "localhost", Port: 4242}
+	_, err = putClusterInstance(ctx, client, stale, instance)
+	if err = expectConflict("putClusterInstance", err); err != nil {
+		return err
+	}
+
+	logger.Debug().Msg("adding an instance through the current copy")
+	model.Cluster1, err = putClusterInstance(ctx, client, model.Cluster1, instance)
+	if err != nil {
+		return errors.Wrap(err, "putClusterInstance")
+	}
+	_, err = deleteClusterInstance(ctx, client, stale, instance)
+	if err = expectConflict("deleteClusterInstance", err); err != nil {
+		return err
+	}
+	model.Cluster1, err = deleteClusterInstance(ctx, client, model.Cluster1, instance)
+	if err != nil {
+		return errors.Wrap(err, "deleteClusterInstance")
+	}
+
+	return nil
+}
+
+func (model *Model) staleDomainChecksum(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	logger.Debug().Msg("fetching the domain twice")
+	stale, err := getDomainByKey(ctx, client, model.Domain.DomainKey)
+	if err != nil {
+		return errors.Wrap(err, "getDomainByKey")
+	}
+	fresh, err := getDomainByKey(ctx, client, model.Domain.DomainKey)
+	if err != nil {
+		return errors.Wrap(err, "getDomainByKey")
+	}
+
+	logger.Debug().Msg("editing the domain through one copy")
+	fresh.Port = 444
+	model.Domain, err = editDomain(ctx, client, fresh)
+	if err != nil {
+		return errors.Wrap(err, "editDomain")
+	}
+
+	logger.Debug().Msg("writing through the stale copy")
+	stale.Port = 555
+	_, err = editDomain(ctx, client, stale)
+	if err = expectConflict("editDomain", err); err != nil {
+		return err
+	}
+
+	return model.staleDelete(ctx, logger, client, domainResource,
+		func() (interface{}, error) {
+			domain, err := createDomain(ctx, client, model.Zone, model.names.domain+"-stale-delete")
+			return &domain, err
+		},
+		func(object interface{}) { object.(*api.Domain).Port = 444 },
+	)
+}
+
+func (model *Model) staleListenerChecksum(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	logger.Debug().Msg("fetching the listener twice")
+	stale, err := getListenerByKey(ctx, client, model.Listener.ListenerKey)
+	if err != nil {
+		return errors.Wrap(err, "getListenerByKey")
+	}
+	fresh, err := getListenerByKey(ctx, client, model.Listener.ListenerKey)
+	if err != nil {
+		return errors.Wrap(err, "getListenerByKey")
+	}
+
+	logger.Debug().Msg("editing the listener through one copy")
+	fresh.Port = 999
+	model.Listener, err = editListener(ctx, client, fresh)
+	if err != nil {
+		return errors.Wrap(err, "editListener")
+	}
+
+	logger.Debug().Msg("writing through the stale copy")
+	stale.Port = 1999
+	_, err = editListener(ctx, client, stale)
+	if err = expectConflict("editListener", err); err != nil {
+		return err
+	}
+
+	return model.staleDelete(ctx, logger, client, listenerResource,
+		func() (interface{}, error) {
+			listener, err := createListener(ctx, client, model.Zone, model.Domain, model.names.listener+"-stale-delete")
+			return &listener, err
+		},
+		func(object interface{}) { object.(*api.Listener).Port = 999 },
+	)
+}
+
+func (model *Model) staleSharedRulesChecksum(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	logger.Debug().Msg("fetching the shared rules twice")
+	stale, err := getSharedRulesByKey(ctx, client, model.SharedRules.SharedRulesKey)
+	if err != nil {
+		return errors.Wrap(err, "getSharedRulesByKey")
+	}
+	fresh, err := getSharedRulesByKey(ctx, client, model.SharedRules.SharedRulesKey)
+	if err != nil {
+		return errors.Wrap(err, "getSharedRulesByKey")
+	}
+
+	logger.Debug().Msg("editing the shared rules through one copy")
+	fresh.Properties = api.Metadata{api.Metadatum{Key: "stale-test", Value: "fresh"}}
+	model.SharedRules, err = editSharedRules(ctx, client, fresh)
+	if err != nil {
+		return errors.Wrap(err, "editSharedRules")
+	}
+
+	logger.Debug().Msg("writing through the stale copy")
+	stale.Properties = api.Metadata{api.Metadatum{Key: "stale-test", Value: "stale"}}
+	_, err = editSharedRules(ctx, client, stale)
+	if err = expectConflict("editSharedRules", err); err != nil {
+		return err
+	}
+
+	return model.staleDelete(ctx, logger, client, sharedRulesResource,
+		func() (interface{}, error) {
+			sharedRules, err := createSharedRules(ctx, client, model.Zone, model.names.sharedRules+"-stale-delete")
+			return &sharedRules, err
+		},
+		func(object interface{}) {
+			object.(*api.SharedRules).Properties = api.Metadata{api.Metadatum{Key: "stale-test", Value: "fresh"}}
+		},
+	)
+}
+
+func (model *Model) staleRouteChecksum(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	logger.Debug().Msg("fetching the route twice")
+	stale, err := getRouteByKey(ctx, client, model.Route.RouteKey)
+	if err != nil {
+		return errors.Wrap(err, "getRouteByKey")
+	}
+	fresh, err := getRouteByKey(ctx, client, model.Route.RouteKey)
+	if err != nil {
+		return errors.Wrap(err, "getRouteByKey")
+	}
+
+	logger.Debug().Msg("editing the route through one copy")
+	fresh.PrefixRewrite = "/fresh"
+	model.Route, err = editRoute(ctx, client, fresh)
+	if err != nil {
+		return errors.Wrap(err, "editRoute")
+	}
+
+	logger.Debug().Msg("writing through the stale copy")
+	stale.PrefixRewrite = "/stale"
+	_, err = editRoute(ctx, client, stale)
+	if err = expectConflict("editRoute", err); err != nil {
+		return err
+	}
+
+	return expectConflict("deleteRoute", deleteRoute(ctx, client, stale))
+}
+
+func (model *Model) staleProxyChecksum(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	logger.Debug().Msg("fetching the proxy twice")
+	stale, err := getProxyByKey(ctx, client, model.Proxy.ProxyKey)
+	if err != nil {
+		return errors.Wrap(err, "getProxyByKey")
+	}
+	fresh, err := getProxyByKey(ctx, client, model.Proxy.ProxyKey)
+	if err != nil {
+		return errors.Wrap(err, "getProxyByKey")
+	}
+
+	logger.Debug().Msg("editing the proxy through one copy")
+	fresh.ActiveFilters = []api.GMProxyFilter{api.GMProxyFilter("fresh-filter")}
+	model.Proxy, err = editProxy(ctx, client, fresh)
+	if err != nil {
+		return errors.Wrap(err, "editProxy")
+	}
+
+	logger.Debug().Msg("writing through the stale copy")
+	stale.ActiveFilters = []api.GMProxyFilter{api.GMProxyFilter("stale-filter")}
+	_, err = editProxy(ctx, client, stale)
+	if err = expectConflict("editProxy", err); err != nil {
+		return err
+	}
+
+	return expectConflict("deleteProxy", deleteProxy(ctx, client, stale))
+}
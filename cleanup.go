@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// cleanupRegistry records how to undo each object a run creates, so that a
+// failed or interrupted run does not leave objects behind to break the
+// next one. Entries are undone in reverse order of registration, which is
+// reverse dependency order because objects are created after the objects
+// they refer to.
+type cleanupRegistry struct {
+	mu      sync.Mutex
+	entries []cleanupEntry
+}
+
+type cleanupEntry struct {
+	name string
+	undo func(context.Context) error
+}
+
+type cleanupResult struct {
+	name string
+	err  error
+}
+
+func (registry *cleanupRegistry) register(name string, undo func(context.Context) error) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.entries = append(registry.entries, cleanupEntry{name: name, undo: undo})
+}
+
+// forget drops the entry for name, once the run has undone it itself.
+func (registry *cleanupRegistry) forget(name string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for i := len(registry.entries) - 1; i >= 0; i-- {
+		if registry.entries[i].name == name {
+			registry.entries = append(registry.entries[:i], registry.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// registerObject registers deletion of the object stored under key.
+func (registry *cleanupRegistry) registerObject(rc resourceClient, key string) {
+	registry.register(cleanupName(rc.resource, key), func(ctx context.Context) error {
+		return rc.removeByKey(ctx, key)
+	})
+}
+
+func (registry *cleanupRegistry) forgetObject(r resource, key string) {
+	registry.forget(cleanupName(r, key))
+}
+
+func cleanupName(r resource, key string) string {
+	return fmt.Sprintf("%s %s", r.segment, key)
+}
+
+// run undoes every remaining entry, newest first, and empties the
+// registry. An object that is already gone counts as cleaned up.
+func (registry *cleanupRegistry) run(ctx context.Context, logger zerolog.Logger) []cleanupResult {
+	registry.mu.Lock()
+	entries := registry.entries
+	registry.entries = nil
+	registry.mu.Unlock()
+
+	var results []cleanupResult
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		logger.Info().Str("object", entry.name).Msg("cleaning up")
+
+		err := entry.undo(ctx)
+		if err != nil && IsNotFound(err) {
+			err = nil
+		}
+		if err != nil {
+			logger.Error().Err(err).Str("object", entry.name).Msg("cleanup failed")
+		}
+		results = append(results, cleanupResult{name: entry.name, err: err})
+	}
+
+	return results
+}
+
+// writeCleanupSummary reports the cleanup results apart from the step
+// results; it writes nothing when there was nothing to clean up.
+func writeCleanupSummary(w io.Writer, results []cleanupResult) (failed int, err error) {
+	if len(results) == 0 {
+		return 0, nil
+	}
+
+	if _, err = fmt.Fprintln(w, "\nCLEANUP"); err != nil {
+		return 0, err
+	}
+	for _, result := range results {
+		status := "ok"
+		if result.err != nil {
+			status = "FAILED: " + result.err.Error()
+			failed++
+		}
+		if _, err = fmt.Fprintf(w, "  %s: %s\n", result.name, status); err != nil {
+			return failed, err
+		}
+	}
+
+	return failed, nil
+}
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
@@ -10,31 +17,171 @@ import (
 
 type clientStruct struct {
 	logger         zerolog.Logger
-	serverAddress string
+	scheme         string
+	serverAddress  string
 	httpClient     http.Client
+	requestTimeout time.Duration
+	retryPolicy    retryPolicy
+	credentials    credentials
 }
 
+// newRequest builds a request for path on the control plane, bound to ctx.
+// values, when non-nil, become the query string and body, when non-nil, is
+// sent as JSON.
+func (client *clientStruct) newRequest(
+	ctx context.Context,
+	method string,
+	path string,
+	values url.Values,
+	body interface{},
+) (*http.Request, error) {
+	var request http.Request
+
+	// path comes escaped, keys by url.PathEscape; set as Path alone it
+	// would be escaped again, sending a key holding / or % as the wrong one
+	unescaped, err := url.PathUnescape(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "PathUnescape")
+	}
+
+	request.Method = method
+	request.Header = make(http.Header)
+	client.credentials.apply(&request)
+	request.URL = &url.URL{
+		Scheme:  client.scheme,
+		Host:    client.serverAddress,
+		Path:    unescaped,
+		RawPath: path,
+	}
+	if values != nil {
+		request.URL.RawQuery = values.Encode()
+	}
+
+	if body != nil {
+		var buffer bytes.Buffer
+		if err := json.NewEncoder(&buffer).Encode(body); err != nil {
+			return nil, errors.Wrap(err, "Encode")
+		}
+		payload := buffer.Bytes()
+		request.Body = ioutil.NopCloser(bytes.NewReader(payload))
+		request.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(payload)), nil
+		}
+	}
+
+	return request.WithContext(ctx), nil
+}
+
+// doJSON runs request and unmarshals the result member of the response
+// into result. A nil result discards the response.
+func (client *clientStruct) doJSON(request *http.Request, result interface{}) error {
+	rawMessage, err := client.doHTTP(request)
+	if err != nil {
+		return errors.Wrap(err, "doHTTP")
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err = json.Unmarshal(rawMessage, result); err != nil {
+		return errors.Wrap(err, "Unmarshal")
+	}
+
+	return nil
+}
+
+// doHTTP runs request and returns the result member of the response
+// envelope.
 func (client *clientStruct) doHTTP(request *http.Request) (json.RawMessage, error) {
+	envelope, _, err := client.doEnvelope(request)
+	if err != nil {
+		return nil, err
+	}
+	return envelope["result"], nil
+}
+
+// doEnvelope runs request, retrying according to the client's retry
+// policy, and returns the members of the response envelope and the body
+// they were decoded from. Each attempt is bounded by the client's
+// per-request timeout in addition to any deadline already carried by the
+// request context.
+func (client *clientStruct) doEnvelope(request *http.Request) (map[string]json.RawMessage, []byte, error) {
+	for attempt := 1; ; attempt++ {
+		envelope, body, statusCode, err := client.doAttempt(request)
+		if err == nil {
+			return envelope, body, nil
+		}
+
+		ctx := request.Context()
+		if ctx.Err() != nil ||
+			!client.retryPolicy.shouldRetry(request.Method, attempt, statusCode, err) {
+			return nil, nil, err
+		}
+
+		delay := client.retryPolicy.backoff(attempt)
+		client.logger.Debug().Err(err).Int("attempt", attempt).
+			Str("method", request.Method).Str("path", request.URL.Path).
+			Dur("delay", delay).Msg("retrying request")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil, errors.Wrapf(err, "retry abandoned: %s", ctx.Err())
+		case <-timer.C:
+		}
+
+		if request.GetBody != nil {
+			body, bodyErr := request.GetBody()
+			if bodyErr != nil {
+				return nil, nil, errors.Wrap(bodyErr, "GetBody")
+			}
+			request.Body = body
+		}
+	}
+}
+
+// doAttempt makes a single attempt at request. statusCode is zero when no
+// response was received.
+func (client *clientStruct) doAttempt(
+	request *http.Request,
+) (envelope map[string]json.RawMessage, body []byte, statusCode int, err error) {
+	if client.requestTimeout > 0 {
+		ctx, cancel := context.WithTimeout(request.Context(), client.requestTimeout)
+		defer cancel()
+		request = request.WithContext(ctx)
+	}
+
 	response, err := client.httpClient.Do(request)
 	if err != nil {
-		return nil, errors.Wrap(err, "client.Do")
+		return nil, nil, 0, errors.Wrap(err, "client.Do")
 	}
 	defer response.Body.Close()
 
-	var bodyMap map[string]json.RawMessage
-	if err = json.NewDecoder(response.Body).Decode(&bodyMap); err != nil {
-		return nil, errors.Wrap(err, "Decode")
+	body, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, response.StatusCode, errors.Wrap(err, "ReadAll")
+	}
+
+	apiErr := &APIError{
+		StatusCode: response.StatusCode,
+		Method:     request.Method,
+		URL:        request.URL.String(),
+		Body:       body,
+		RequestID:  response.Header.Get("X-Request-Id"),
+	}
+
+	if err = json.Unmarshal(body, &envelope); err != nil {
+		apiErr.Message = fmt.Sprintf("response is not a JSON envelope: %s", err)
+		apiErr.NotEnvelope = true
+		return nil, nil, response.StatusCode, apiErr
 	}
 
 	if response.StatusCode != http.StatusOK {
-		rawMessage := bodyMap["error"]
-		var errorMap map[string]string
-		if err = json.Unmarshal(rawMessage, &errorMap); err != nil {
-			client.logger.Error().AnErr("Unmarshal", err).Msg("error in error handling")
-		}
-		return nil, errors.Errorf("HTTP request failed: (%d) %s: %+v",
-			response.StatusCode, response.Status, errorMap)
+		apiErr.setErrorMap(envelope["error"])
+		return nil, nil, response.StatusCode, apiErr
 	}
 
-	return bodyMap["result"], nil
+	return envelope, body, response.StatusCode, nil
 }
@@ -1,14 +1,7 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
-
-	"github.com/pkg/errors"
+	"context"
 
 	api "github.com/deciphernow/gm-control-api/api"
 	service "github.com/deciphernow/gm-control-api/api/service"
@@ -16,149 +9,67 @@ import (
 
 const clusterName = "cluster1"
 
-func createCluster(client *clientStruct, zone api.Zone) (api.Cluster, error) {
-	var reqCluster api.Cluster
-	var respCluster api.Cluster
-	var buffer bytes.Buffer
-	var request http.Request
-
-	reqCluster.ZoneKey = zone.ZoneKey
-	reqCluster.Name = clusterName
-
-	if err := json.NewEncoder(&buffer).Encode(&reqCluster); err != nil {
-		return api.Cluster{}, errors.Wrap(err, "Encode")
-	}
-
-	request.Method = "POST"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   "/v1.0/cluster",
-	}
-	request.Body = ioutil.NopCloser(&buffer)
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Cluster{}, errors.Wrap(err, "doHTTP")
-	}
-
-	if err = json.Unmarshal(rawMessage, &respCluster); err != nil {
-		return api.Cluster{}, errors.Wrap(err, "Unmarshal")
-	}
-
-	return respCluster, nil
+var clusterResource = resource{
+	segment:   "cluster",
+	newObject: func() interface{} { return &api.Cluster{} },
+	identify: func(object interface{}) (string, string) {
+		cluster := object.(*api.Cluster)
+		return string(cluster.ClusterKey), cluster.Checksum.Checksum
+	},
+	equal: func(a interface{}, b interface{}) bool {
+		return a.(*api.Cluster).Equals(*b.(*api.Cluster))
+	},
 }
 
-func queryClusterByName(client *clientStruct) (api.Clusters, error) {
-	var buffer bytes.Buffer
-	var request http.Request
-
-	nameFilter := service.ClusterFilter{Name: clusterName}
-	clusterFilters := []service.ClusterFilter{nameFilter}
-
-	if err := json.NewEncoder(&buffer).Encode(clusterFilters); err != nil {
-		return nil, errors.Wrap(err, "Encode filters")
-	}
-
-	request.Method = "GET"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   "/v1.0/cluster",
-	}
-
-	values := url.Values{}
-	values.Add("filters", buffer.String())
-	request.URL.RawQuery = values.Encode()
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return nil, errors.Wrap(err, "doHTTP")
-	}
-
-	var clusters []api.Cluster
-
-	if err = json.Unmarshal(rawMessage, &clusters); err != nil {
-		return nil, errors.Wrap(err, "Unmarshal")
-	}
+type clusterClient struct{ resourceClient }
 
-	return clusters, nil
+func (client *clientStruct) clusters() clusterClient {
+	return clusterClient{resourceClient{client: client, resource: clusterResource}}
 }
 
-func getClusterByKey(client *clientStruct, clusterKey api.ClusterKey) (api.Cluster, error) {
-	var respCluster api.Cluster
-	var request http.Request
-
-	request.Method = "GET"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   fmt.Sprintf("/v1.0/cluster/%s", url.PathEscape(string(clusterKey))),
-	}
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Cluster{}, errors.Wrap(err, "doHTTP")
-	}
+func (c clusterClient) Create(ctx context.Context, cluster api.Cluster) (result api.Cluster, err error) {
+	err = c.create(ctx, &cluster, &result)
+	return result, err
+}
 
-	if err = json.Unmarshal(rawMessage, &respCluster); err != nil {
-		return api.Cluster{}, errors.Wrap(err, "Unmarshal")
-	}
+func (c clusterClient) List(ctx context.Context, filters ...service.ClusterFilter) (result api.Clusters, err error) {
+	err = c.list(ctx, filters, &result)
+	return result, err
+}
 
-	return respCluster, nil
+func (c clusterClient) Get(ctx context.Context, clusterKey api.ClusterKey) (result api.Cluster, err error) {
+	err = c.get(ctx, string(clusterKey), &result)
+	return result, err
 }
 
-func editCluster(client *clientStruct, cluster api.Cluster) (api.Cluster, error) {
-	var respCluster api.Cluster
-	var buffer bytes.Buffer
-	var request http.Request
-
-	if err := json.NewEncoder(&buffer).Encode(&cluster); err != nil {
-		return api.Cluster{}, errors.Wrap(err, "Encode")
-	}
-
-	request.Method = "PUT"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   clusterKeyPath(cluster),
-	}
-	request.Body = ioutil.NopCloser(&buffer)
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Cluster{}, errors.Wrap(err, "doHTTP")
-	}
-
-	if err = json.Unmarshal(rawMessage, &respCluster); err != nil {
-		return api.Cluster{}, errors.Wrap(err, "Unmarshal")
-	}
-
-	return respCluster, nil
+func (c clusterClient) Update(ctx context.Context, cluster api.Cluster) (result api.Cluster, err error) {
+	err = c.update(ctx, &cluster, &result)
+	return result, err
 }
 
-func deleteCluster(client *clientStruct, cluster api.Cluster) error {
-	var request http.Request
+func (c clusterClient) Delete(ctx context.Context, cluster api.Cluster) error {
+	return c.remove(ctx, &cluster)
+}
 
-	request.Method = "DELETE"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   clusterKeyPath(cluster),
-	}
+func createCluster(ctx context.Context, client *clientStruct, zone api.Zone, name string) (api.Cluster, error) {
+	return client.clusters().Create(ctx, api.Cluster{
+		ZoneKey: zone.ZoneKey,
+		Name:    name,
+	})
+}
 
-	values := url.Values{}
-	values.Add("checksum", cluster.Checksum.Checksum)
-	request.URL.RawQuery = values.Encode()
+func queryClusterByName(ctx context.Context, client *clientStruct, name string) (api.Clusters, error) {
+	return client.clusters().List(ctx, service.ClusterFilter{Name: name})
+}
 
-	_, err := client.doHTTP(&request)
-	if err != nil {
-		return errors.Wrap(err, "doHTTP")
-	}
+func getClusterByKey(ctx context.Context, client *clientStruct, clusterKey api.ClusterKey) (api.Cluster, error) {
+	return client.clusters().Get(ctx, clusterKey)
+}
 
-	return nil
+func editCluster(ctx context.Context, client *clientStruct, cluster api.Cluster) (api.Cluster, error) {
+	return client.clusters().Update(ctx, cluster)
 }
 
-func clusterKeyPath(cluster api.Cluster) string {
-	return fmt.Sprintf("/v1.0/cluster/%s", url.PathEscape(string(cluster.ClusterKey)))
+func deleteCluster(ctx context.Context, client *clientStruct, cluster api.Cluster) error {
+	return client.clusters().Delete(ctx, cluster)
 }
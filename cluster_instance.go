@@ -1,11 +1,8 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"net/url"
 
 	"github.com/pkg/errors"
@@ -13,72 +10,71 @@ import (
 	api "github.com/deciphernow/gm-control-api/api"
 )
 
-func putClusterInstance(
-	client *clientStruct,
+// PutInstance adds instance to the cluster, guarded by the cluster checksum.
+func (c clusterClient) PutInstance(
+	ctx context.Context,
 	cluster api.Cluster,
 	instance api.Instance,
-) (api.Cluster, error) {
-	var respCluster api.Cluster
-	var buffer bytes.Buffer
-	var request http.Request
+) (result api.Cluster, err error) {
+	values := url.Values{}
+	values.Add("checksum", cluster.Checksum.Checksum)
 
-	if err := json.NewEncoder(&buffer).Encode(&instance); err != nil {
-		return api.Cluster{}, errors.Wrap(err, "Encode")
+	request, err := c.client.newRequest(
+		ctx,
+		"PUT",
+		clusterInstancesPath(cluster.ClusterKey),
+		values,
+		&instance,
+	)
+	if err != nil {
+		return api.Cluster{}, errors.Wrap(err, "newRequest")
 	}
 
-	request.Method = "PUT"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   clusterInstancesPath(cluster.ClusterKey),
-	}
-	request.Body = ioutil.NopCloser(&buffer)
+	err = c.client.doJSON(request, &result)
+	return result, err
+}
 
+// DeleteInstance removes instance from the cluster, guarded by the cluster
+// checksum.
+func (c clusterClient) DeleteInstance(
+	ctx context.Context,
+	cluster api.Cluster,
+	instance api.Instance,
+) (result api.Cluster, err error) {
 	values := url.Values{}
 	values.Add("checksum", cluster.Checksum.Checksum)
-	request.URL.RawQuery = values.Encode()
 
-	rawMessage, err := client.doHTTP(&request)
+	request, err := c.client.newRequest(
+		ctx,
+		"DELETE",
+		clusterInstancePath(cluster.ClusterKey, instance.Key()),
+		values,
+		nil,
+	)
 	if err != nil {
-		return api.Cluster{}, errors.Wrap(err, "doHTTP")
+		return api.Cluster{}, errors.Wrap(err, "newRequest")
 	}
 
-	if err = json.Unmarshal(rawMessage, &respCluster); err != nil {
-		return api.Cluster{}, errors.Wrap(err, "Unmarshal")
-	}
+	err = c.client.doJSON(request, &result)
+	return result, err
+}
 
-	return respCluster, nil
+func putClusterInstance(
+	ctx context.Context,
+	client *clientStruct,
+	cluster api.Cluster,
+	instance api.Instance,
+) (api.Cluster, error) {
+	return client.clusters().PutInstance(ctx, cluster, instance)
 }
 
 func deleteClusterInstance(
+	ctx context.Context,
 	client *clientStruct,
 	cluster api.Cluster,
 	instance api.Instance,
 ) (api.Cluster, error) {
-	var respCluster api.Cluster
-	var request http.Request
-
-	request.Method = "DELETE"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   clusterInstancePath(cluster.ClusterKey, instance.Key()),
-	}
-
-	values := url.Values{}
-	values.Add("checksum", cluster.Checksum.Checksum)
-	request.URL.RawQuery = values.Encode()
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Cluster{}, errors.Wrap(err, "doHTTP")
-	}
-
-	if err = json.Unmarshal(rawMessage, &respCluster); err != nil {
-		return api.Cluster{}, errors.Wrap(err, "Unmarshal")
-	}
-
-	return respCluster, nil
+	return client.clusters().DeleteInstance(ctx, cluster, instance)
 }
 
 func clusterInstancesPath(clusterKey api.ClusterKey) string {
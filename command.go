@@ -0,0 +1,612 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/spf13/viper"
+
+	"github.com/dougfort/gm-control-api-integration/persister"
+)
+
+// command is a tool run in place of the suite when its name is the first
+// argument. run returns the exit status; the error, if any, is logged.
+type command struct {
+	name        string
+	usage       string
+	description string
+	run         func(ctx context.Context, logger zerolog.Logger, args []string) (int, error)
+}
+
+func commands() []command {
+	return []command{
+		{
+			name:        "apply",
+			usage:       "apply [--dry-run] FILE",
+			description: "converge the zones in a YAML or JSON file to their declared objects",
+			run:         runApply,
+		},
+		{
+			name:        "export",
+			usage:       "export [--strip-checksums] [--yaml] [FILE]",
+			description: "write every zone and its objects to a snapshot file, or stdout",
+			run:         runExport,
+		},
+		{
+			name:        "import",
+			usage:       "import [--verify=false] FILE",
+			description: "create the zones and objects of a snapshot in a gm-control-api that has none of its zones",
+			run:         runImport,
+		},
+		{
+			name:        "diff",
+			usage:       "diff SOURCE_A SOURCE_B",
+			description: "compare two snapshot files or gm-control-api addresses; exits 0 if they match, 1 if they differ, 2 on error",
+			run:         runDiff,
+		},
+		{
+			name:        "check-backend",
+			usage:       "check-backend FILE",
+			description: "validate a file persister backend.json offline; exits 1 if it has problems",
+			run:         runCheckBackend,
+		},
+		{
+			name:        "bench",
+			usage:       "bench [flags] [REPORT]",
+			description: "seed objects, drive a mix of route requests and write latency percentiles as JSON",
+			run:         runBench,
+		},
+		{
+			name:        "statemachine",
+			usage:       "statemachine [flags]",
+			description: "run random operation sequences against a reference model and shrink the first that fails",
+			run:         runStateMachine,
+		},
+		{
+			name:        "fuzz",
+			usage:       "fuzz [flags]",
+			description: "send mutated request bodies and report server errors, hangs and responses that are not the envelope",
+			run:         runFuzz,
+		},
+	}
+}
+
+// runCommand runs the named command.
+func runCommand(logger zerolog.Logger, name string, args []string) int {
+	var selected *command
+	for _, c := range commands() {
+		if c.name == name {
+			c := c
+			selected = &c
+		}
+	}
+	if selected == nil {
+		writeCommandUsage(os.Stderr)
+		if name == "help" || name == "-h" || name == "--help" {
+			return 0
+		}
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		viper.GetDuration("run_timeout"),
+	)
+	defer cancel()
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupts)
+	go func() {
+		select {
+		case sig := <-interrupts:
+			logger.Warn().Str("signal", sig.String()).Msg("interrupted")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	exitCode, err := selected.run(ctx, logger, args)
+	if err != nil {
+		logger.Error().Err(err).Str("command", name).Msg("command failed")
+	}
+	return exitCode
+}
+
+func writeCommandUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: integration [COMMAND]")
+	fmt.Fprintln(w, "\nWith no command, runs the integration suite. Commands:")
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %s\n        %s\n", c.usage, c.description)
+	}
+}
+
+// newFlagSet returns a flag set for a command that reports usage errors
+// instead of exiting.
+func newFlagSet(c string, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(c, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: integration %s\n", usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// connect builds a client for address and waits for its gm-control-api to
+// answer.
+func connect(ctx context.Context, logger zerolog.Logger, address string) (*clientStruct, func(), error) {
+	client, closeClient, err := newClient(logger, address)
+	if err != nil {
+		return nil, nil, err
+	}
+	return awaitClient(ctx, logger, client, closeClient)
+}
+
+// awaitClient waits for the gm-control-api client talks to to answer,
+// closing the client if it does not.
+func awaitClient(
+	ctx context.Context,
+	logger zerolog.Logger,
+	client *clientStruct,
+	closeClient func(),
+) (*clientStruct, func(), error) {
+	waitCtx, waitCancel := context.WithTimeout(ctx, viper.GetDuration("wait_for_api"))
+	err := waitForAPI(waitCtx, logger, client)
+	waitCancel()
+	if err != nil {
+		closeClient()
+		return nil, nil, errors.Wrap(err, "waitForAPI")
+	}
+
+	return client, closeClient, nil
+}
+
+func runApply(ctx context.Context, logger zerolog.Logger, args []string) (int, error) {
+	flags := newFlagSet("apply", "apply [--dry-run] FILE")
+	dryRun := flags.Bool("dry-run", false, "print the plan without changing anything")
+	if err := flags.Parse(args); err != nil {
+		return 2, nil
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2, nil
+	}
+
+	desired, err := readSnapshot(flags.Arg(0))
+	if err != nil {
+		return 1, err
+	}
+
+	client, closeClient, err := connect(ctx, logger, viper.GetString("gm_control_api_address"))
+	if err != nil {
+		return 1, err
+	}
+	defer closeClient()
+
+	plan, err := planApply(ctx, client, desired)
+	if err != nil {
+		return 1, errors.Wrap(err, "planApply")
+	}
+	if err = plan.write(os.Stdout); err != nil {
+		return 1, err
+	}
+	if *dryRun || len(plan.steps) == 0 {
+		return 0, nil
+	}
+
+	if err = plan.execute(ctx, logger, client); err != nil {
+		return 1, errors.Wrap(err, "execute")
+	}
+	return 0, nil
+}
+
+func runExport(ctx context.Context, logger zerolog.Logger, args []string) (int, error) {
+	flags := newFlagSet("export", "export [--strip-checksums] [--yaml] [FILE]")
+	stripChecksums := flags.Bool("strip-checksums", false, "leave out the checksums gm-control-api generates")
+	asYAML := flags.Bool("yaml", false, "write YAML instead of JSON; implied by a .yaml or .yml FILE")
+	if err := flags.Parse(args); err != nil {
+		return 2, nil
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 2, nil
+	}
+	path := flags.Arg(0)
+	if strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml") {
+		*asYAML = true
+	}
+
+	client, closeClient, err := connect(ctx, logger, viper.GetString("gm_control_api_address"))
+	if err != nil {
+		return 1, err
+	}
+	defer closeClient()
+
+	exported, err := exportSnapshot(ctx, client, !*stripChecksums)
+	if err != nil {
+		return 1, errors.Wrap(err, "exportSnapshot")
+	}
+
+	if path == "" {
+		return 0, writeSnapshot(os.Stdout, exported, *asYAML)
+	}
+
+	var buffer bytes.Buffer
+	if err = writeSnapshot(&buffer, exported, *asYAML); err != nil {
+		return 1, err
+	}
+	if err = ioutil.WriteFile(path, buffer.Bytes(), 0644); err != nil {
+		return 1, errors.Wrap(err, "WriteFile")
+	}
+	logger.Info().Str("path", path).Int("zones", len(exported.Zones)).Msg("exported")
+	return 0, nil
+}
+
+func runImport(ctx context.Context, logger zerolog.Logger, args []string) (int, error) {
+	flags := newFlagSet("import", "import [--verify=false] FILE")
+	verify := flags.Bool("verify", true, "read the imported objects back and compare them with the snapshot")
+	if err := flags.Parse(args); err != nil {
+		return 2, nil
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2, nil
+	}
+
+	source, err := readSnapshot(flags.Arg(0))
+	if err != nil {
+		return 1, err
+	}
+
+	client, closeClient, err := connect(ctx, logger, viper.GetString("gm_control_api_address"))
+	if err != nil {
+		return 1, err
+	}
+	defer closeClient()
+
+	// a failed import deletes what it created, so that it can be run again
+	var created cleanupRegistry
+	imported, mappings, err := importSnapshot(ctx, logger, client, source, &created)
+	if err != nil {
+		logger.Warn().Err(err).Msg("import failed; deleting the objects it created")
+		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), viper.GetDuration("cleanup_timeout"))
+		defer cleanupCancel()
+		if _, writeErr := writeCleanupSummary(os.Stdout, created.run(cleanupCtx, logger)); writeErr != nil {
+			logger.Error().AnErr("writeCleanupSummary", writeErr).Msg("import")
+		}
+		return 1, errors.Wrap(err, "importSnapshot")
+	}
+	if err = writeKeyMappings(os.Stdout, mappings); err != nil {
+		return 1, err
+	}
+	if !*verify {
+		return 0, nil
+	}
+
+	problems, err := verifyImport(ctx, client, source, imported)
+	if err != nil {
+		return 1, errors.Wrap(err, "verifyImport")
+	}
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stdout, "MISMATCH", problem)
+	}
+	if len(problems) > 0 {
+		return 1, errors.Errorf("%d imported objects differ from the snapshot", len(problems))
+	}
+	logger.Info().Int("objects", len(mappings)).Msg("import verified")
+	return 0, nil
+}
+
+// runDiff exits 1 when the sources differ and 2 when either cannot be
+// read, so that a drift check can tell the two apart.
+func runDiff(ctx context.Context, logger zerolog.Logger, args []string) (int, error) {
+	flags := newFlagSet("diff", "diff SOURCE_A SOURCE_B")
+	if err := flags.Parse(args); err != nil {
+		return 2, nil
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		fmt.Fprintln(flags.Output(), "each SOURCE is a snapshot file or, if no file has that name, a gm-control-api host:port or http(s) URL")
+		return 2, nil
+	}
+
+	var sources [2]snapshot
+	for i := range sources {
+		var err error
+		if sources[i], err = loadDiffSource(ctx, logger, flags.Arg(i)); err != nil {
+			return 2, errors.Wrap(err, flags.Arg(i))
+		}
+	}
+
+	differences, err := diffSnapshots(sources[0], sources[1])
+	if err != nil {
+		return 2, errors.Wrap(err, "diffSnapshots")
+	}
+	if err = writeDifferences(os.Stdout, flags.Arg(0), flags.Arg(1), differences); err != nil {
+		return 2, err
+	}
+	if len(differences) > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func runCheckBackend(ctx context.Context, logger zerolog.Logger, args []string) (int, error) {
+	flags := newFlagSet("check-backend", "check-backend FILE")
+	if err := flags.Parse(args); err != nil {
+		return 2, nil
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2, nil
+	}
+
+	backend, err := persister.Read(flags.Arg(0))
+	if err != nil {
+		return 2, err
+	}
+	for _, section := range backend.Ignored {
+		logger.Warn().Str("section", section).Msg("ignored section")
+	}
+
+	problems := backend.Validate()
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stdout, problem)
+	}
+	fmt.Fprintf(os.Stdout, "%d objects, %d problems\n", backend.Count(), len(problems))
+	if len(problems) > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func runBench(ctx context.Context, logger zerolog.Logger, args []string) (exitCode int, err error) {
+	flags := newFlagSet("bench", "bench [flags] [REPORT]")
+	var settings benchSettings
+	flags.IntVar(&settings.zones, "zones", 1, "zones to seed")
+	flags.IntVar(&settings.clusters, "clusters", 10, "clusters to seed in each zone, sharing its routes' traffic")
+	flags.IntVar(&settings.domains, "domains", 10, "domains to seed in each zone")
+	flags.IntVar(&settings.routes, "routes", 1000, "routes to seed in each zone, spread over its domains")
+	mix := flags.String("mix", "create=1,get=10,query=4,edit=3,delete=1", "relative weight of each operation")
+	flags.Float64Var(&settings.rate, "rate", 0, "operations per second across all workers; 0 for as fast as they go")
+	flags.IntVar(&settings.workers, "workers", 8, "concurrent workers")
+	flags.DurationVar(&settings.duration, "duration", 30*time.Second, "how long to drive operations; 0 for no limit")
+	flags.IntVar(&settings.operations, "operations", 0, "stop after this many operations; 0 for no limit")
+	flags.Int64Var(&settings.seed, "seed", 1, "seed for choosing operations and their targets")
+	flags.BoolVar(&settings.keep, "keep", false, "leave the seeded and created objects in place")
+	if err := flags.Parse(args); err != nil {
+		return 2, nil
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 2, nil
+	}
+
+	if settings.mix, err = parseBenchMix(*mix); err != nil {
+		return 2, err
+	}
+	switch {
+	case settings.zones < 1 || settings.domains < 1 || settings.workers < 1:
+		return 2, errors.New("zones, domains and workers must each be at least 1")
+	case settings.clusters < 0 || settings.routes < 0 || settings.rate < 0:
+		return 2, errors.New("clusters, routes and rate must not be negative")
+	case settings.duration == 0 && settings.operations == 0:
+		return 2, errors.New("set a duration or a number of operations")
+	}
+
+	runID := viper.GetString("run_id")
+	if runID == "" {
+		if runID, err = newRunID(); err != nil {
+			return 1, errors.Wrap(err, "newRunID")
+		}
+	}
+	names := namer{prefix: viper.GetString("name_prefix") + "bench-", runID: runID, suffix: viper.GetString("name_suffix")}
+	logger = logger.With().Str("run_id", runID).Logger()
+
+	client, closeClient, err := connect(ctx, logger, viper.GetString("gm_control_api_address"))
+	if err != nil {
+		return 1, err
+	}
+	defer closeClient()
+
+	b := newBench(logger, client, settings, names)
+	if !settings.keep {
+		defer func() {
+			cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), viper.GetDuration("cleanup_timeout"))
+			defer cleanupCancel()
+			if failures := b.cleanup(cleanupCtx); failures > 0 && err == nil {
+				exitCode, err = 1, errors.Errorf("cleanup: %d failures", failures)
+			}
+		}()
+	}
+
+	logger.Info().Msg("seeding")
+	if err = b.seed(ctx); err != nil {
+		return 1, errors.Wrap(err, "seed")
+	}
+	logger.Info().Dur("took", b.seedTime).Msg("seeded; driving operations")
+	b.run(ctx)
+
+	report := b.report(runID)
+	logger.Info().Int("operations", report.Total.Count).Int("errors", report.Total.Errors).
+		Float64("throughput", report.Total.Throughput).Float64("p99_ms", report.Total.P99MS).
+		Msg("bench finished")
+
+	path := flags.Arg(0)
+	if path == "" {
+		return 0, writeBenchReport(os.Stdout, report)
+	}
+	var buffer bytes.Buffer
+	if err = writeBenchReport(&buffer, report); err != nil {
+		return 1, err
+	}
+	if err = ioutil.WriteFile(path, buffer.Bytes(), 0644); err != nil {
+		return 1, errors.Wrap(err, "WriteFile")
+	}
+	return 0, nil
+}
+
+func runStateMachine(ctx context.Context, logger zerolog.Logger, args []string) (exitCode int, err error) {
+	flags := newFlagSet("statemachine", "statemachine [flags]")
+	seed := flags.Int64("seed", 0, "seed of the first sequence, each later one using the next; 0 for one from the clock")
+	sequences := flags.Int("sequences", 10, "sequences to run")
+	steps := flags.Int("steps", 40, "operations in each sequence")
+	shrink := flags.Bool("shrink", true, "shrink a failing sequence to a minimal reproducer")
+	shrinkAttempts := flags.Int("shrink-attempts", 200, "replays to spend shrinking")
+	if err = flags.Parse(args); err != nil {
+		return 2, nil
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2, nil
+	}
+	if *sequences < 1 || *steps < 1 {
+		return 2, errors.New("sequences and steps must each be at least 1")
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	runID := viper.GetString("run_id")
+	if runID == "" {
+		if runID, err = newRunID(); err != nil {
+			return 1, errors.Wrap(err, "newRunID")
+		}
+	}
+	names := namer{prefix: viper.GetString("name_prefix") + "machine-", runID: runID, suffix: viper.GetString("name_suffix")}
+	logger = logger.With().Str("run_id", runID).Logger()
+
+	client, closeClient, err := connect(ctx, logger, viper.GetString("gm_control_api_address"))
+	if err != nil {
+		return 1, err
+	}
+	defer closeClient()
+
+	m := &machine{logger: logger, client: writerClient(client), names: names}
+	defer func() {
+		if m.cleanupFailures > 0 && err == nil {
+			exitCode, err = 1, errors.Errorf("cleanup: %d failures", m.cleanupFailures)
+		}
+	}()
+	for i := 0; i < *sequences; i++ {
+		sequenceSeed := *seed + int64(i)
+		ops := generateMachineOps(rand.New(rand.NewSource(sequenceSeed)), *steps)
+		logger.Info().Int64("seed", sequenceSeed).Int("steps", len(ops)).Msg("running sequence")
+
+		failure, err := m.runSequence(ctx, ops)
+		if err != nil {
+			return 2, errors.Wrapf(err, "sequence with seed %d", sequenceSeed)
+		}
+		if failure == nil {
+			continue
+		}
+
+		logger.Error().Err(failure.err).Int64("seed", sequenceSeed).Int("step", failure.step+1).Msg("sequence failed")
+		generated := len(ops)
+		if *shrink {
+			ops, failure = m.shrink(ctx, ops, failure, *shrinkAttempts)
+			logger.Info().Int("from", generated).Int("to", len(ops)).Msg("shrunk")
+		}
+		if err = writeMachineFailure(os.Stdout, sequenceSeed, *steps, generated, ops, failure); err != nil {
+			return 2, err
+		}
+		return 1, nil
+	}
+
+	logger.Info().Int64("seed", *seed).Int("sequences", *sequences).Msg("every sequence matched the model")
+	return 0, nil
+}
+
+func runFuzz(ctx context.Context, logger zerolog.Logger, args []string) (exitCode int, err error) {
+	flags := newFlagSet("fuzz", "fuzz [flags]")
+	seed := flags.Int64("seed", 0, "seed for choosing mutations; 0 for one from the clock")
+	inputs := flags.Int("inputs", 100, "mutated bodies to send to each target")
+	targets := flags.String("targets", "", "comma separated targets to fuzz; empty for all")
+	corpus := flags.String("corpus", "fuzz-corpus", "directory of saved inputs, replayed first and added to on a finding")
+	hangTimeout := flags.Duration("hang-timeout", 10*time.Second, "how long to wait for a response before reporting a hang")
+	maxString := flags.Int("max-string", 1<<20, "longest string a mutation inserts, in bytes")
+	if err = flags.Parse(args); err != nil {
+		return 2, nil
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2, nil
+	}
+	if *inputs < 0 || *maxString < 1 || *hangTimeout <= 0 {
+		return 2, errors.New("inputs must not be negative and max-string and hang-timeout must be positive")
+	}
+	selected, err := selectFuzzTargets(*targets)
+	if err != nil {
+		return 2, err
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	runID := viper.GetString("run_id")
+	if runID == "" {
+		if runID, err = newRunID(); err != nil {
+			return 1, errors.Wrap(err, "newRunID")
+		}
+	}
+	names := namer{prefix: viper.GetString("name_prefix") + "fuzz-", runID: runID, suffix: viper.GetString("name_suffix")}
+	logger = logger.With().Str("run_id", runID).Int64("seed", *seed).Logger()
+
+	client, closeClient, err := connect(ctx, logger, viper.GetString("gm_control_api_address"))
+	if err != nil {
+		return 1, err
+	}
+	defer closeClient()
+
+	f := &fuzzer{
+		logger:      logger,
+		client:      writerClient(client),
+		names:       names,
+		rng:         rand.New(rand.NewSource(*seed)),
+		hangTimeout: *hangTimeout,
+		maxString:   *maxString,
+		corpus:      *corpus,
+		created:     make(map[string][]string),
+	}
+	defer func() {
+		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), viper.GetDuration("cleanup_timeout"))
+		defer cleanupCancel()
+		if failures := f.cleanup(cleanupCtx); failures > 0 && err == nil {
+			exitCode, err = 1, errors.Errorf("cleanup: %d failures", failures)
+		}
+	}()
+
+	if err = f.seed(ctx); err != nil {
+		return 2, errors.Wrap(err, "seed")
+	}
+	for _, target := range selected {
+		logger.Info().Str("target", target.name).Msg("fuzzing")
+		if err = f.replayCorpus(ctx, target); err != nil {
+			return 2, errors.Wrapf(err, "replay %s corpus", target.name)
+		}
+		if err = f.fuzz(ctx, target, *inputs); err != nil {
+			return 2, errors.Wrapf(err, "fuzz %s", target.name)
+		}
+	}
+
+	logger.Info().Int("findings", len(f.findings)).Msg("fuzz finished")
+	if len(f.findings) == 0 {
+		return 0, nil
+	}
+	if err = writeFuzzFindings(os.Stdout, f.findings); err != nil {
+		return 2, err
+	}
+	return 1, nil
+}
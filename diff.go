@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/spf13/viper"
+)
+
+// objectDifference is one object that is not the same in two snapshots.
+// segment is "zone" for a zone found in only one of them. Exactly one of
+// onlyInA and onlyInB is set for an object missing from one side;
+// otherwise fields lists what differs.
+type objectDifference struct {
+	zone     string
+	segment  string
+	identity string
+	onlyInA  bool
+	onlyInB  bool
+	fields   []fieldDifference
+}
+
+// fieldDifference is one value that differs between two objects, at a
+// dotted path such as circuit_breakers.max_connections or instances[1].port.
+// A value missing from one side is reported as absent.
+type fieldDifference struct {
+	path    string
+	a       interface{}
+	b       interface{}
+	absentA bool
+	absentB bool
+}
+
+// ignoredDiffFields are generated by the control plane that holds an
+// object, so they differ between control planes holding the same mesh.
+var ignoredDiffFields = []string{"checksum", "zone_key", "org_key"}
+
+// unorderedDiffFields hold lists whose order carries no meaning: cluster
+// instances and the names of referenced domains and listeners. They are
+// compared as sets.
+var unorderedDiffFields = []string{"instances", "domains", "listeners"}
+
+// loadDiffSource reads the snapshot file at source or, when source is an
+// address, exports one from the gm-control-api there. An address is
+// host:port, reached over https when GM_CONTROL_API_USE_TLS is set, or an
+// http or https URL naming one, reached with its scheme; anything else is
+// a file, and a missing file is reported as such. An address is refused
+// with GM_CONTROL_API_FAKE set, since the fake would stand in for it.
+func loadDiffSource(ctx context.Context, logger zerolog.Logger, source string) (snapshot, error) {
+	info, err := os.Stat(source)
+	if err == nil {
+		if info.IsDir() {
+			return snapshot{}, errors.New("is a directory")
+		}
+		return readSnapshot(source)
+	}
+	scheme, address, isAddress := diffSourceAddress(source)
+	switch {
+	case !isAddress && os.IsNotExist(err):
+		return snapshot{}, errors.New("no such file, nor a host:port or http URL")
+	case !isAddress:
+		return snapshot{}, errors.Wrap(err, "Stat")
+	case viper.GetBool("gm_control_api_fake"):
+		return snapshot{}, errors.New("GM_CONTROL_API_FAKE would stand an empty fake in for this address; unset it")
+	}
+
+	client, closeClient, err := newClient(logger, address)
+	if err != nil {
+		return snapshot{}, err
+	}
+	switch {
+	case scheme == "https" && client.scheme != "https":
+		if err = client.useTLS(environmentTLSSettings()); err != nil {
+			closeClient()
+			return snapshot{}, errors.Wrap(err, "useTLS")
+		}
+	case scheme == "http":
+		client.scheme = "http"
+	}
+	client, closeClient, err = awaitClient(ctx, logger, client, closeClient)
+	if err != nil {
+		return snapshot{}, err
+	}
+	defer closeClient()
+
+	return exportSnapshot(ctx, client, false)
+}
+
+// diffSourceAddress returns the scheme and host:port source names, if it
+// is host:port, when the scheme is empty, or an http or https URL with no
+// path beyond "/".
+func diffSourceAddress(source string) (scheme string, address string, ok bool) {
+	if strings.Contains(source, "://") {
+		u, err := url.Parse(source)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || (u.Path != "" && u.Path != "/") {
+			return "", "", false
+		}
+		scheme, source = u.Scheme, u.Host
+	}
+	host, port, err := net.SplitHostPort(source)
+	if err != nil || host == "" {
+		return "", "", false
+	}
+	if _, err = strconv.ParseUint(port, 10, 16); err != nil {
+		return "", "", false
+	}
+	return scheme, source, true
+}
+
+// diffSnapshots compares two snapshots, matching zones by name and the
+// objects in a zone by identity. Keys and checksums are ignored, since
+// each control plane generates its own; references compare by name.
+func diffSnapshots(a snapshot, b snapshot) ([]objectDifference, error) {
+	var differences []objectDifference
+
+	zonesA := make(map[string]*snapshotZone, len(a.Zones))
+	zonesB := make(map[string]*snapshotZone, len(b.Zones))
+	for i := range a.Zones {
+		zonesA[a.Zones[i].Name] = &a.Zones[i]
+	}
+	for i := range b.Zones {
+		zonesB[b.Zones[i].Name] = &b.Zones[i]
+	}
+
+	for _, name := range unionKeys(zonesA, zonesB) {
+		zoneA, inA := zonesA[name]
+		zoneB, inB := zonesB[name]
+		if !inA || !inB {
+			differences = append(differences, objectDifference{
+				zone:     name,
+				segment:  "zone",
+				identity: name,
+				onlyInA:  !inB,
+				onlyInB:  !inA,
+			})
+			continue
+		}
+
+		for _, kind := range snapshotKinds {
+			objectsA := objectsByIdentity(kind, *kind.section(zoneA))
+			objectsB := objectsByIdentity(kind, *kind.section(zoneB))
+
+			for _, identity := range unionKeys(objectsA, objectsB) {
+				objectA, inA := objectsA[identity]
+				objectB, inB := objectsB[identity]
+				difference := objectDifference{
+					zone:     name,
+					segment:  kind.resource.segment,
+					identity: identity,
+					onlyInA:  !inB,
+					onlyInB:  !inA,
+				}
+				if inA && inB {
+					var err error
+					difference.fields, err = diffFields(kind, objectA, objectB)
+					if err != nil {
+						return nil, errors.Wrapf(err, "zone %s: %s %s", name, kind.resource.segment, identity)
+					}
+					if len(difference.fields) == 0 {
+						continue
+					}
+				}
+				differences = append(differences, difference)
+			}
+		}
+	}
+
+	return differences, nil
+}
+
+func objectsByIdentity(kind snapshotKind, objects []snapshotObject) map[string]snapshotObject {
+	result := make(map[string]snapshotObject, len(objects))
+	for _, object := range objects {
+		result[kind.identity(object)] = object
+	}
+	return result
+}
+
+// unionKeys returns the keys of two maps with string keys, sorted.
+func unionKeys(a interface{}, b interface{}) []string {
+	seen := make(map[string]bool)
+	for _, m := range []interface{}{a, b} {
+		for _, key := range reflect.ValueOf(m).MapKeys() {
+			seen[key.String()] = true
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// diffFields returns the leaf values that differ between two snapshot
+// forms of the same object. Both are normalized through JSON first, so
+// that a number read from YAML and one read from gm-control-api compare
+// equal.
+func diffFields(kind snapshotKind, a snapshotObject, b snapshotObject) ([]fieldDifference, error) {
+	var flattened [2]map[string]interface{}
+	for i, object := range []snapshotObject{a, b} {
+		trimmed := make(snapshotObject, len(object))
+		for field, value := range object {
+			trimmed[field] = value
+		}
+		delete(trimmed, kind.keyField)
+		for _, field := range ignoredDiffFields {
+			delete(trimmed, field)
+		}
+
+		contents, err := json.Marshal(trimmed)
+		if err != nil {
+			return nil, errors.Wrap(err, "Marshal")
+		}
+		var normalized map[string]interface{}
+		if err = json.Unmarshal(contents, &normalized); err != nil {
+			return nil, errors.Wrap(err, "Unmarshal")
+		}
+		for _, field := range unorderedDiffFields {
+			if list, ok := normalized[field].([]interface{}); ok {
+				sortByEncoding(list)
+			}
+		}
+		flattened[i] = make(map[string]interface{})
+		flattenFields("", normalized, flattened[i])
+	}
+
+	var differences []fieldDifference
+	for _, path := range unionKeys(flattened[0], flattened[1]) {
+		valueA, inA := flattened[0][path]
+		valueB, inB := flattened[1][path]
+		if inA && inB && reflect.DeepEqual(valueA, valueB) {
+			continue
+		}
+		differences = append(differences, fieldDifference{
+			path:    path,
+			a:       valueA,
+			b:       valueB,
+			absentA: !inA,
+			absentB: !inB,
+		})
+	}
+	return differences, nil
+}
+
+// sortByEncoding sorts values by their JSON encoding, so that two lists
+// holding the same values in any order end up the same.
+func sortByEncoding(values []interface{}) {
+	encoded := make([]string, len(values))
+	for i, value := range values {
+		contents, _ := json.Marshal(value)
+		encoded[i] = string(contents)
+	}
+	sort.Sort(byEncoding{values: values, encoded: encoded})
+}
+
+type byEncoding struct {
+	values  []interface{}
+	encoded []string
+}
+
+func (b byEncoding) Len() int           { return len(b.values) }
+func (b byEncoding) Less(i, j int) bool { return b.encoded[i] < b.encoded[j] }
+func (b byEncoding) Swap(i, j int) {
+	b.values[i], b.values[j] = b.values[j], b.values[i]
+	b.encoded[i], b.encoded[j] = b.encoded[j], b.encoded[i]
+}
+
+// flattenFields records every leaf of a decoded JSON value under its
+// dotted path. Empty objects and arrays are leaves, so that they differ
+// from absent ones.
+func flattenFields(path string, value interface{}, leaves map[string]interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if len(value) == 0 {
+			break
+		}
+		for field, member := range value {
+			memberPath := field
+			if path != "" {
+				memberPath = path + "." + field
+			}
+			flattenFields(memberPath, member, leaves)
+		}
+		return
+	case []interface{}:
+		if len(value) == 0 {
+			break
+		}
+		for i, member := range value {
+			flattenFields(fmt.Sprintf("%s[%d]", path, i), member, leaves)
+		}
+		return
+	}
+	leaves[path] = value
+}
+
+// writeDifferences prints the differences in the style of a unified diff:
+// - for what only A holds, + for what only B holds, ~ for an object both
+// hold with its differing fields indented beneath it.
+func writeDifferences(w io.Writer, sourceA string, sourceB string, differences []objectDifference) error {
+	if len(differences) == 0 {
+		_, err := fmt.Fprintln(w, "no differences")
+		return err
+	}
+
+	lines := []string{"--- " + sourceA, "+++ " + sourceB}
+	for _, difference := range differences {
+		symbol := "~"
+		switch {
+		case difference.onlyInA:
+			symbol = "-"
+		case difference.onlyInB:
+			symbol = "+"
+		}
+		line := fmt.Sprintf("%s zone %s", symbol, difference.zone)
+		if difference.segment != "zone" {
+			line += fmt.Sprintf(": %s %s", difference.segment, difference.identity)
+		}
+		lines = append(lines, line)
+
+		for _, field := range difference.fields {
+			lines = append(lines, fmt.Sprintf("    %s: %s -> %s",
+				field.path, diffValue(field.a, field.absentA), diffValue(field.b, field.absentB)))
+		}
+	}
+	summary := fmt.Sprintf("%d objects differ", len(differences))
+	if len(differences) == 1 {
+		summary = "1 object differs"
+	}
+	lines = append(lines, "", summary)
+
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+func diffValue(value interface{}, absent bool) string {
+	if absent {
+		return "(absent)"
+	}
+	contents, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(contents)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDiffSourceAddress(t *testing.T) {
+	tests := []struct {
+		source  string
+		scheme  string
+		address string
+		ok      bool
+	}{
+		{source: "localhost:5555", address: "localhost:5555", ok: true},
+		{source: "10.0.0.1:80", address: "10.0.0.1:80", ok: true},
+		{source: "[::1]:5555", address: "[::1]:5555", ok: true},
+		{source: "http://control:5555", scheme: "http", address: "control:5555", ok: true},
+		{source: "https://control:443/", scheme: "https", address: "control:443", ok: true},
+		{source: "https://control:443/v1.0"},
+		{source: "ftp://control:21"},
+		{source: "http://control"},
+		{source: "snapshot.json"},
+		{source: "dir/snapshot.json"},
+		{source: ":5555"},
+		{source: "localhost:http"},
+		{source: "localhost:70000"},
+		{source: "c:snapshot"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			scheme, address, ok := diffSourceAddress(test.source)
+			if scheme != test.scheme || address != test.address || ok != test.ok {
+				t.Errorf("diffSourceAddress(%q) = %q, %q, %v, want %q, %q, %v",
+					test.source, scheme, address, ok, test.scheme, test.address, test.ok)
+			}
+		})
+	}
+}
+
+func TestDiffFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		segment string
+		a, b    snapshotObject
+		want    []string
+	}{
+		{
+			name:    "keys, checksums and zone keys ignored",
+			segment: "cluster",
+			a:       snapshotObject{"cluster_key": "C1", "checksum": "x", "zone_key": "Z1", "name": "cluster1"},
+			b:       snapshotObject{"cluster_key": "C2", "checksum": "y", "zone_key": "Z2", "name": "cluster1"},
+		},
+		{
+			name:    "numbers from YAML and JSON",
+			segment: "cluster",
+			a:       snapshotObject{"name": "cluster1", "weight": json.Number("5")},
+			b:       snapshotObject{"name": "cluster1", "weight": 5},
+		},
+		{
+			name:    "changed field",
+			segment: "cluster",
+			a:       snapshotObject{"name": "cluster1", "require_tls": false},
+			b:       snapshotObject{"name": "cluster1", "require_tls": true},
+			want:    []string{"require_tls"},
+		},
+		{
+			name:    "instances in another order",
+			segment: "cluster",
+			a: snapshotObject{"name": "cluster1", "instances": []interface{}{
+				map[string]interface{}{"host": "a", "port": 1},
+				map[string]interface{}{"host": "b", "port": 2},
+			}},
+			b: snapshotObject{"name": "cluster1", "instances": []interface{}{
+				map[string]interface{}{"host": "b", "port": 2},
+				map[string]interface{}{"host": "a", "port": 1},
+			}},
+		},
+		{
+			name:    "domains in another order",
+			segment: "proxy",
+			a:       snapshotObject{"name": "proxy1", "domains": []interface{}{"domain1", "domain2"}, "listeners": []interface{}{"l1", "l2"}},
+			b:       snapshotObject{"name": "proxy1", "domains": []interface{}{"domain2", "domain1"}, "listeners": []interface{}{"l2", "l1"}},
+		},
+		{
+			name:    "instance missing",
+			segment: "cluster",
+			a:       snapshotObject{"name": "cluster1", "instances": []interface{}{"a", "b"}},
+			b:       snapshotObject{"name": "cluster1", "instances": []interface{}{"b"}},
+			want:    []string{"instances[0]", "instances[1]"},
+		},
+		{
+			name:    "ordered lists keep their order",
+			segment: "cluster",
+			a:       snapshotObject{"name": "cluster1", "health_checks": []interface{}{"a", "b"}},
+			b:       snapshotObject{"name": "cluster1", "health_checks": []interface{}{"b", "a"}},
+			want:    []string{"health_checks[0]", "health_checks[1]"},
+		},
+		{
+			name:    "empty list and absent list",
+			segment: "cluster",
+			a:       snapshotObject{"name": "cluster1", "instances": []interface{}{}},
+			b:       snapshotObject{"name": "cluster1"},
+			want:    []string{"instances"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			differences, err := diffFields(snapshotKindFor(t, test.segment), test.a, test.b)
+			if err != nil {
+				t.Fatalf("diffFields: %v", err)
+			}
+			var paths []string
+			for _, difference := range differences {
+				paths = append(paths, difference.path)
+			}
+			if !reflect.DeepEqual(paths, test.want) {
+				t.Errorf("differing fields %v, want %v", paths, test.want)
+			}
+		})
+	}
+}
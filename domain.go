@@ -1,14 +1,7 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
-
-	"github.com/pkg/errors"
+	"context"
 
 	api "github.com/deciphernow/gm-control-api/api"
 	service "github.com/deciphernow/gm-control-api/api/service"
@@ -16,149 +9,67 @@ import (
 
 const domainName = "domain1"
 
-func createDomain(client *clientStruct, zone api.Zone) (api.Domain, error) {
-	var reqDomain api.Domain
-	var respDomain api.Domain
-	var buffer bytes.Buffer
-	var request http.Request
-
-	reqDomain.ZoneKey = zone.ZoneKey
-	reqDomain.Name = domainName
-
-	if err := json.NewEncoder(&buffer).Encode(&reqDomain); err != nil {
-		return api.Domain{}, errors.Wrap(err, "Encode")
-	}
-
-	request.Method = "POST"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   "/v1.0/domain",
-	}
-	request.Body = ioutil.NopCloser(&buffer)
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Domain{}, errors.Wrap(err, "doHTTP")
-	}
-
-	if err = json.Unmarshal(rawMessage, &respDomain); err != nil {
-		return api.Domain{}, errors.Wrap(err, "Unmarshal")
-	}
-
-	return respDomain, nil
+var domainResource = resource{
+	segment:   "domain",
+	newObject: func() interface{} { return &api.Domain{} },
+	identify: func(object interface{}) (string, string) {
+		domain := object.(*api.Domain)
+		return string(domain.DomainKey), domain.Checksum.Checksum
+	},
+	equal: func(a interface{}, b interface{}) bool {
+		return a.(*api.Domain).Equals(*b.(*api.Domain))
+	},
 }
 
-func queryDomainByName(client *clientStruct) (api.Domains, error) {
-	var buffer bytes.Buffer
-	var request http.Request
-
-	nameFilter := service.DomainFilter{Name: domainName}
-	domainFilters := []service.DomainFilter{nameFilter}
-
-	if err := json.NewEncoder(&buffer).Encode(domainFilters); err != nil {
-		return nil, errors.Wrap(err, "Encode filters")
-	}
-
-	request.Method = "GET"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   "/v1.0/domain",
-	}
-
-	values := url.Values{}
-	values.Add("filters", buffer.String())
-	request.URL.RawQuery = values.Encode()
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return nil, errors.Wrap(err, "doHTTP")
-	}
-
-	var domains []api.Domain
-
-	if err = json.Unmarshal(rawMessage, &domains); err != nil {
-		return nil, errors.Wrap(err, "Unmarshal")
-	}
+type domainClient struct{ resourceClient }
 
-	return domains, nil
+func (client *clientStruct) domains() domainClient {
+	return domainClient{resourceClient{client: client, resource: domainResource}}
 }
 
-func getDomainByKey(client *clientStruct, domainKey api.DomainKey) (api.Domain, error) {
-	var respDomain api.Domain
-	var request http.Request
-
-	request.Method = "GET"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   fmt.Sprintf("/v1.0/domain/%s", url.PathEscape(string(domainKey))),
-	}
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Domain{}, errors.Wrap(err, "doHTTP")
-	}
+func (c domainClient) Create(ctx context.Context, domain api.Domain) (result api.Domain, err error) {
+	err = c.create(ctx, &domain, &result)
+	return result, err
+}
 
-	if err = json.Unmarshal(rawMessage, &respDomain); err != nil {
-		return api.Domain{}, errors.Wrap(err, "Unmarshal")
-	}
+func (c domainClient) List(ctx context.Context, filters ...service.DomainFilter) (result api.Domains, err error) {
+	err = c.list(ctx, filters, &result)
+	return result, err
+}
 
-	return respDomain, nil
+func (c domainClient) Get(ctx context.Context, domainKey api.DomainKey) (result api.Domain, err error) {
+	err = c.get(ctx, string(domainKey), &result)
+	return result, err
 }
 
-func editDomain(client *clientStruct, domain api.Domain) (api.Domain, error) {
-	var respDomain api.Domain
-	var buffer bytes.Buffer
-	var request http.Request
-
-	if err := json.NewEncoder(&buffer).Encode(&domain); err != nil {
-		return api.Domain{}, errors.Wrap(err, "Encode")
-	}
-
-	request.Method = "PUT"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   domainKeyPath(domain),
-	}
-	request.Body = ioutil.NopCloser(&buffer)
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Domain{}, errors.Wrap(err, "doHTTP")
-	}
-
-	if err = json.Unmarshal(rawMessage, &respDomain); err != nil {
-		return api.Domain{}, errors.Wrap(err, "Unmarshal")
-	}
-
-	return respDomain, nil
+func (c domainClient) Update(ctx context.Context, domain api.Domain) (result api.Domain, err error) {
+	err = c.update(ctx, &domain, &result)
+	return result, err
 }
 
-func deleteDomain(client *clientStruct, domain api.Domain) error {
-	var request http.Request
+func (c domainClient) Delete(ctx context.Context, domain api.Domain) error {
+	return c.remove(ctx, &domain)
+}
 
-	request.Method = "DELETE"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   domainKeyPath(domain),
-	}
+func createDomain(ctx context.Context, client *clientStruct, zone api.Zone, name string) (api.Domain, error) {
+	return client.domains().Create(ctx, api.Domain{
+		ZoneKey: zone.ZoneKey,
+		Name:    name,
+	})
+}
 
-	values := url.Values{}
-	values.Add("checksum", domain.Checksum.Checksum)
-	request.URL.RawQuery = values.Encode()
+func queryDomainByName(ctx context.Context, client *clientStruct, name string) (api.Domains, error) {
+	return client.domains().List(ctx, service.DomainFilter{Name: name})
+}
 
-	_, err := client.doHTTP(&request)
-	if err != nil {
-		return errors.Wrap(err, "doHTTP")
-	}
+func getDomainByKey(ctx context.Context, client *clientStruct, domainKey api.DomainKey) (api.Domain, error) {
+	return client.domains().Get(ctx, domainKey)
+}
 
-	return nil
+func editDomain(ctx context.Context, client *clientStruct, domain api.Domain) (api.Domain, error) {
+	return client.domains().Update(ctx, domain)
 }
 
-func domainKeyPath(domain api.Domain) string {
-	return fmt.Sprintf("/v1.0/domain/%s", url.PathEscape(string(domain.DomainKey)))
+func deleteDomain(ctx context.Context, client *clientStruct, domain api.Domain) error {
+	return client.domains().Delete(ctx, domain)
 }
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+
+	api "github.com/deciphernow/gm-control-api/api"
+
+	"github.com/dougfort/gm-control-api-integration/persister"
+)
+
+// fakeKind describes how the fake server stores one object type. The
+// fields holding keys of other objects are those the persister package
+// lists; an object cannot be created naming a missing object, nor deleted
+// while another object names it.
+type fakeKind struct {
+	keyField string
+	required []string
+}
+
+var fakeKinds = map[string]fakeKind{
+	"zone":         {keyField: "zone_key", required: []string{"name"}},
+	"cluster":      {keyField: "cluster_key", required: []string{"zone_key", "name"}},
+	"domain":       {keyField: "domain_key", required: []string{"zone_key", "name"}},
+	"listener":     {keyField: "listener_key", required: []string{"zone_key", "name"}},
+	"shared_rules": {keyField: "shared_rules_key", required: []string{"zone_key", "name"}},
+	"route":        {keyField: "route_key", required: []string{"zone_key", "path", "domain_key", "shared_rules_key"}},
+	"proxy":        {keyField: "proxy_key", required: []string{"zone_key", "name"}},
+}
+
+// fakeObject is a stored object in its JSON form, as decoded with
+// json.Number for numeric values.
+type fakeObject map[string]interface{}
+
+// fakeError is a failure the fake server reports in the error envelope.
+type fakeError struct {
+	status  int
+	code    string
+	message string
+}
+
+func fakeErrorf(status int, code string, format string, args ...interface{}) *fakeError {
+	return &fakeError{status: status, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// fakeServer is an in-process stand-in for gm-control-api. It serves the
+// /v1.0 object endpoints from memory with the same result and error
+// envelopes, checksums, filters and cluster instance sub-resources, and
+// the admin backup and restore endpoints, so the suite can run without a
+// docker container. When backendPath is set it also writes its objects
+// there after every change, as the file persister does.
+type fakeServer struct {
+	logger      zerolog.Logger
+	orgKey      string
+	credentials credentials
+	backendPath string
+
+	mu        sync.Mutex
+	sequence  int
+	requestID int
+	store     map[string]map[string]fakeObject
+}
+
+func newFakeServer(logger zerolog.Logger, orgKey string, creds credentials) *fakeServer {
+	fake := fakeServer{
+		logger:      logger,
+		orgKey:      orgKey,
+		credentials: creds,
+		store:       make(map[string]map[string]fakeObject),
+	}
+	for segment := range fakeKinds {
+		fake.store[segment] = make(map[string]fakeObject)
+	}
+	return &fake
+}
+
+// startFakeServer runs a fake gm-control-api on a local port, persisting
+// to backendPath unless it is empty. The caller closes the returned server.
+func startFakeServer(logger zerolog.Logger, orgKey string, creds credentials, backendPath string) *httptest.Server {
+	fake := newFakeServer(logger, orgKey, creds)
+	fake.backendPath = backendPath
+	if fakeErr := fake.persist(); fakeErr != nil {
+		logger.Error().Str("path", backendPath).Msg(fakeErr.message)
+	}
+	server := httptest.NewServer(fake)
+	logger.Info().Str("address", server.Listener.Addr().String()).
+		Msg("started fake gm-control-api")
+	return server
+}
+
+func (fake *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fake.mu.Lock()
+	fake.requestID++
+	requestID := fmt.Sprintf("fake-%d", fake.requestID)
+	result, fakeErr := fake.handle(r)
+	if fakeErr == nil && r.Method != "GET" {
+		fakeErr = fake.persist()
+	}
+	fake.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Request-Id", requestID)
+
+	if fakeErr != nil {
+		fake.logger.Debug().Str("method", r.Method).Str("path", r.URL.Path).
+			Int("status", fakeErr.status).Str("code", fakeErr.code).
+			Msg(fakeErr.message)
+		w.WriteHeader(fakeErr.status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": map[string]string{
+				"code":    fakeErr.code,
+				"message": fakeErr.message,
+			},
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+}
+
+// handle dispatches a request. The caller holds fake.mu.
+func (fake *fakeServer) handle(r *http.Request) (interface{}, *fakeError) {
+	if fake.credentials.required {
+		if missing := fake.credentials.missing(r); missing != "" {
+			return nil, fakeErrorf(http.StatusUnauthorized, "Unauthorized", "%s", missing)
+		}
+	}
+
+	// split before unescaping, so that a key may hold a slash
+	parts := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, fakeErrorf(http.StatusBadRequest, "BadRoute", "path %s: %s", r.URL.Path, err)
+		}
+		parts[i] = unescaped
+	}
+	if len(parts) == 2 && parts[0] == "admin" && r.Method == "POST" {
+		switch parts[1] {
+		case "backup":
+			return fake.sections(), nil
+		case "restore":
+			return fake.restore(r)
+		}
+	}
+	if len(parts) < 2 || parts[0] != "v1.0" {
+		return nil, fakeErrorf(http.StatusNotFound, "BadRoute", "no route for %s", r.URL.Path)
+	}
+	segment := parts[1]
+	if _, ok := fakeKinds[segment]; !ok {
+		return nil, fakeErrorf(http.StatusNotFound, "BadRoute", "no object type %q", segment)
+	}
+
+	switch {
+	case len(parts) == 2 && r.Method == "GET":
+		return fake.list(segment, r)
+	case len(parts) == 2 && r.Method == "POST":
+		return fake.create(segment, r)
+	case len(parts) == 3 && r.Method == "GET":
+		return fake.get(segment, parts[2])
+	case len(parts) == 3 && r.Method == "PUT":
+		return fake.update(segment, parts[2], r)
+	case len(parts) == 3 && r.Method == "DELETE":
+		return fake.remove(segment, parts[2], r)
+	case segment == "cluster" && len(parts) == 4 && parts[3] == "instances" && r.Method == "PUT":
+		return fake.putInstance(parts[2], r)
+	case segment == "cluster" && len(parts) == 5 && parts[3] == "instances" && r.Method == "DELETE":
+		return fake.deleteInstance(parts[2], parts[4], r)
+	}
+
+	return nil, fakeErrorf(http.StatusMethodNotAllowed, "BadRoute",
+		"%s not supported on %s", r.Method, r.URL.Path)
+}
+
+func (fake *fakeServer) list(segment string, r *http.Request) (interface{}, *fakeError) {
+	var filters []fakeObject
+	if text := r.URL.Query().Get("filters"); text != "" {
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.UseNumber()
+		if err := decoder.Decode(&filters); err != nil {
+			return nil, fakeErrorf(http.StatusBadRequest, "BadRequest", "filters: %s", err)
+		}
+	}
+
+	result := []fakeObject{}
+	for _, key := range fake.sortedKeys(segment) {
+		object := fake.store[segment][key]
+		if matchesAnyFilter(object, filters) {
+			result = append(result, object)
+		}
+	}
+
+	return result, nil
+}
+
+func (fake *fakeServer) create(segment string, r *http.Request) (interface{}, *fakeError) {
+	kind := fakeKinds[segment]
+
+	object, fakeErr := decodeFakeObject(r)
+	if fakeErr != nil {
+		return nil, fakeErr
+	}
+	if fakeErr = fake.validate(segment, object); fakeErr != nil {
+		return nil, fakeErr
+	}
+
+	key, _ := object[kind.keyField].(string)
+	if key == "" {
+		fake.sequence++
+		key = fmt.Sprintf("%s-%06d", strings.Replace(segment, "_", "-", -1), fake.sequence)
+	} else if _, exists := fake.store[segment][key]; exists {
+		return nil, fakeErrorf(http.StatusConflict, "ObjectKeyConflict",
+			"%s %s already exists", segment, key)
+	}
+
+	object[kind.keyField] = key
+	fake.store[segment][key] = fake.seal(object)
+
+	return fake.store[segment][key], nil
+}
+
+func (fake *fakeServer) get(segment string, key string) (interface{}, *fakeError) {
+	object, ok := fake.store[segment][key]
+	if !ok {
+		return nil, fakeErrorf(http.StatusNotFound, "NotFound", "%s %s not found", segment, key)
+	}
+	return object, nil
+}
+
+func (fake *fakeServer) update(segment string, key string, r *http.Request) (interface{}, *fakeError) {
+	kind := fakeKinds[segment]
+
+	stored, ok := fake.store[segment][key]
+	if !ok {
+		return nil, fakeErrorf(http.StatusNotFound, "NotFound", "%s %s not found", segment, key)
+	}
+
+	object, fakeErr := decodeFakeObject(r)
+	if fakeErr != nil {
+		return nil, fakeErr
+	}
+	if bodyKey, _ := object[kind.keyField].(string); bodyKey != key {
+		return nil, fakeErrorf(http.StatusBadRequest, "InvalidObject",
+			"%s %q in body does not match %q", kind.keyField, bodyKey, key)
+	}
+	if fakeErr = checkChecksum(stored, object["checksum"]); fakeErr != nil {
+		return nil, fakeErr
+	}
+	if fakeErr = fake.validate(segment, object); fakeErr != nil {
+		return nil, fakeErr
+	}
+
+	fake.store[segment][key] = fake.seal(object)
+
+	return fake.store[segment][key], nil
+}
+
+func (fake *fakeServer) remove(segment string, key string, r *http.Request) (interface{}, *fakeError) {
+	stored, ok := fake.store[segment][key]
+	if !ok {
+		return nil, fakeErrorf(http.StatusNotFound, "NotFound", "%s %s not found", segment, key)
+	}
+	if fakeErr := checkChecksum(stored, r.URL.Query().Get("checksum")); fakeErr != nil {
+		return nil, fakeErr
+	}
+	if referrer, referrerKey := fake.findReferrer(segment, key); referrer != "" {
+		return nil, fakeErrorf(http.StatusConflict, "ObjectInUse",
+			"%s %s is referenced by %s %s", segment, key, referrer, referrerKey)
+	}
+
+	delete(fake.store[segment], key)
+
+	return nil, nil
+}
+
+func (fake *fakeServer) putInstance(clusterKey string, r *http.Request) (interface{}, *fakeError) {
+	cluster, ok := fake.store["cluster"][clusterKey]
+	if !ok {
+		return nil, fakeErrorf(http.StatusNotFound, "NotFound", "cluster %s not found", clusterKey)
+	}
+	if fakeErr := checkChecksum(cluster, r.URL.Query().Get("checksum")); fakeErr != nil {
+		return nil, fakeErr
+	}
+
+	instance, fakeErr := decodeFakeObject(r)
+	if fakeErr != nil {
+		return nil, fakeErr
+	}
+	if fakeErr = decodesAs(instance, &api.Instance{}); fakeErr != nil {
+		return nil, fakeErr
+	}
+	if host, _ := instance["host"].(string); host == "" {
+		return nil, fakeErrorf(http.StatusBadRequest, "InvalidObject", "instance host is required")
+	}
+
+	instanceKey := fakeInstanceKey(instance)
+	var instances []interface{}
+	for _, existing := range fakeInstances(cluster) {
+		if fakeInstanceKey(existing) != instanceKey {
+			instances = append(instances, existing)
+		}
+	}
+	instances = append(instances, map[string]interface{}(instance))
+
+	updated := copyFakeObject(cluster)
+	updated["instances"] = instances
+	fake.store["cluster"][clusterKey] = fake.seal(updated)
+
+	return fake.store["cluster"][clusterKey], nil
+}
+
+func (fake *fakeServer) deleteInstance(
+	clusterKey string,
+	instanceKey string,
+	r *http.Request,
+) (interface{}, *fakeError) {
+	cluster, ok := fake.store["cluster"][clusterKey]
+	if !ok {
+		return nil, fakeErrorf(http.StatusNotFound, "NotFound", "cluster %s not found", clusterKey)
+	}
+	if fakeErr := checkChecksum(cluster, r.URL.Query().Get("checksum")); fakeErr != nil {
+		return nil, fakeErr
+	}
+
+	found := false
+	instances := []interface{}{}
+	for _, existing := range fakeInstances(cluster) {
+		if fakeInstanceKey(existing) == instanceKey {
+			found = true
+			continue
+		}
+		instances = append(instances, existing)
+	}
+	if !found {
+		return nil, fakeErrorf(http.StatusNotFound, "NotFound",
+			"instance %s not found in cluster %s", instanceKey, clusterKey)
+	}
+
+	updated := copyFakeObject(cluster)
+	updated["instances"] = instances
+	fake.store["cluster"][clusterKey] = fake.seal(updated)
+
+	return fake.store["cluster"][clusterKey], nil
+}
+
+// validate checks that the object decodes into its api type, as
+// gm-control-api decodes request bodies, that required fields are set and
+// that every object it names exists.
+func (fake *fakeServer) validate(segment string, object fakeObject) *fakeError {
+	if fakeErr := decodesAs(object, backendResources[segment].newObject()); fakeErr != nil {
+		return fakeErr
+	}
+
+	kind := fakeKinds[segment]
+	for _, field := range kind.required {
+		if value, _ := object[field].(string); value == "" {
+			return fakeErrorf(http.StatusBadRequest, "InvalidObject", "%s: %s is required", segment, field)
+		}
+	}
+
+	for _, reference := range persister.References(segment) {
+		for _, key := range persister.ReferencedKeys(object, reference.Field) {
+			if _, ok := fake.store[reference.Segment][key]; !ok {
+				return fakeErrorf(http.StatusBadRequest, "MissingReference",
+					"%s: %s %s does not exist", segment, reference.Segment, key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// findReferrer returns the type and key of an object that names the
+// object of type segment stored under key, or empty strings if none does.
+func (fake *fakeServer) findReferrer(segment string, key string) (string, string) {
+	var referrers []string
+	for referrer := range fakeKinds {
+		referrers = append(referrers, referrer)
+	}
+	sort.Strings(referrers)
+
+	for _, referrer := range referrers {
+		for _, reference := range persister.References(referrer) {
+			if reference.Segment != segment {
+				continue
+			}
+			for _, referrerKey := range fake.sortedKeys(referrer) {
+				for _, referenced := range persister.ReferencedKeys(fake.store[referrer][referrerKey], reference.Field) {
+					if referenced == key {
+						return referrer, referrerKey
+					}
+				}
+			}
+		}
+	}
+
+	return "", ""
+}
+
+// sections returns every object, by persister file section. The backup
+// endpoint answers with the same form.
+func (fake *fakeServer) sections() map[string][]fakeObject {
+	sections := make(map[string][]fakeObject)
+	for segment := range fakeKinds {
+		section := persister.Section(segment)
+		sections[section] = []fakeObject{}
+		for _, key := range fake.sortedKeys(segment) {
+			sections[section] = append(sections[section], fake.store[segment][key])
+		}
+	}
+	return sections
+}
+
+// restore replaces every object with those in a backup, keeping their
+// keys and checksums. A backup the persister package finds problems in is
+// refused whole.
+func (fake *fakeServer) restore(r *http.Request) (interface{}, *fakeError) {
+	if r.Body == nil {
+		return nil, fakeErrorf(http.StatusBadRequest, "NoBody", "request body is required")
+	}
+	contents, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fakeErrorf(http.StatusBadRequest, "Decoding", "request body: %s", err)
+	}
+	backend, err := persister.Parse(contents)
+	if err != nil {
+		return nil, fakeErrorf(http.StatusBadRequest, "Decoding", "backup: %s", err)
+	}
+	if problems := backend.Validate(); len(problems) > 0 {
+		return nil, fakeErrorf(http.StatusBadRequest, "InvalidBackup",
+			"backup has %d problems, first: %s", len(problems), problems[0])
+	}
+
+	for segment := range fakeKinds {
+		fake.store[segment] = make(map[string]fakeObject)
+		for key, object := range backend.Objects[segment] {
+			fake.store[segment][key] = fakeObject(object)
+		}
+	}
+
+	return nil, nil
+}
+
+// persist writes every object to backendPath, if set, replacing the file
+// in one rename so that a reader never sees it half written. The caller
+// holds fake.mu unless the server has not started.
+func (fake *fakeServer) persist() *fakeError {
+	if fake.backendPath == "" {
+		return nil
+	}
+
+	contents, err := json.MarshalIndent(fake.sections(), "", "  ")
+	if err != nil {
+		return fakeErrorf(http.StatusInternalServerError, "Persist", "encode backend: %s", err)
+	}
+
+	temporary := fake.backendPath + ".tmp"
+	if err = ioutil.WriteFile(temporary, contents, 0644); err != nil {
+		return fakeErrorf(http.StatusInternalServerError, "Persist", "write backend: %s", err)
+	}
+	if err = os.Rename(temporary, fake.backendPath); err != nil {
+		return fakeErrorf(http.StatusInternalServerError, "Persist", "replace backend: %s", err)
+	}
+	return nil
+}
+
+// seal stamps the org key and a fresh checksum onto object.
+func (fake *fakeServer) seal(object fakeObject) fakeObject {
+	object["org_key"] = fake.orgKey
+	delete(object, "checksum")
+	contents, _ := json.Marshal(object)
+	sum := sha1.Sum(contents)
+	object["checksum"] = hex.EncodeToString(sum[:])
+	return object
+}
+
+func (fake *fakeServer) sortedKeys(segment string) []string {
+	var keys []string
+	for key := range fake.store[segment] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func checkChecksum(stored fakeObject, checksum interface{}) *fakeError {
+	if checksum != stored["checksum"] {
+		return fakeErrorf(http.StatusConflict, "ChecksumMismatch",
+			"checksum %v does not match %v", checksum, stored["checksum"])
+	}
+	return nil
+}
+
+func decodeFakeObject(r *http.Request) (fakeObject, *fakeError) {
+	if r.Body == nil {
+		return nil, fakeErrorf(http.StatusBadRequest, "NoBody", "request body is required")
+	}
+
+	var object fakeObject
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil, fakeErrorf(http.StatusBadRequest, "Decoding", "request body: %s", err)
+	}
+	if object == nil {
+		return nil, fakeErrorf(http.StatusBadRequest, "Decoding", "request body is not an object")
+	}
+
+	return object, nil
+}
+
+// decodesAs reports a decoding error when object has a field of the wrong
+// JSON type for value, a pointer to an api type.
+func decodesAs(object fakeObject, value interface{}) *fakeError {
+	contents, err := json.Marshal(object)
+	if err == nil {
+		err = json.Unmarshal(contents, value)
+	}
+	if err != nil {
+		return fakeErrorf(http.StatusBadRequest, "Decoding", "request body: %s", err)
+	}
+	return nil
+}
+
+// matchesAnyFilter reports whether object matches one of filters, or
+// filters is empty. A filter matches when every non-zero field in it
+// equals the object's field; fields ending in _prefix match as prefixes.
+func matchesAnyFilter(object fakeObject, filters []fakeObject) bool {
+	if len(filters) == 0 {
+		return true
+	}
+
+FILTERS:
+	for _, filter := range filters {
+		for field, want := range filter {
+			if isZeroJSON(want) {
+				continue
+			}
+			if strings.HasSuffix(field, "_prefix") {
+				have, _ := object[strings.TrimSuffix(field, "_prefix")].(string)
+				prefix, _ := want.(string)
+				if !strings.HasPrefix(have, prefix) {
+					continue FILTERS
+				}
+				continue
+			}
+			if fmt.Sprint(object[field]) != fmt.Sprint(want) {
+				continue FILTERS
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+func isZeroJSON(value interface{}) bool {
+	switch value := value.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case bool:
+		return !value
+	case json.Number:
+		return value.String() == "0"
+	case []interface{}:
+		return len(value) == 0
+	case map[string]interface{}:
+		return len(value) == 0
+	}
+	return false
+}
+
+func fakeInstances(cluster fakeObject) []interface{} {
+	instances, _ := cluster["instances"].([]interface{})
+	return instances
+}
+
+// fakeInstanceKey matches api.Instance.Key: host:port.
+func fakeInstanceKey(instance interface{}) string {
+	var fields map[string]interface{}
+	switch instance := instance.(type) {
+	case fakeObject:
+		fields = instance
+	case map[string]interface{}:
+		fields = instance
+	}
+	return fmt.Sprintf("%v:%v", fields["host"], fields["port"])
+}
+
+func copyFakeObject(object fakeObject) fakeObject {
+	result := make(fakeObject, len(object))
+	for field, value := range object {
+		result[field] = value
+	}
+	return result
+}
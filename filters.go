@@ -0,0 +1,613 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	api "github.com/deciphernow/gm-control-api/api"
+	service "github.com/deciphernow/gm-control-api/api/service"
+)
+
+// filterSeed is the known data the filter steps query: two zones, each
+// holding two objects of every other type. The objects in the second zone
+// have the same names and paths as those in the first, so that a name
+// filter spans zones and only a zone filter tells them apart.
+type filterSeed struct {
+	names   objectNames
+	cleanup *cleanupRegistry
+
+	zones       [2]api.Zone
+	clusters    [2][2]api.Cluster
+	domains     [2][2]api.Domain
+	listeners   [2][2]api.Listener
+	sharedRules [2][2]api.SharedRules
+	routes      [2][2]api.Route
+	proxies     [2][2]api.Proxy
+
+	created []seededObject
+}
+
+type seededObject struct {
+	rc  resourceClient
+	key string
+}
+
+func (seed *filterSeed) track(rc resourceClient, key string) {
+	seed.cleanup.registerObject(rc, key)
+	seed.created = append(seed.created, seededObject{rc: rc, key: key})
+}
+
+// seedFilterData creates the zones and their objects.
+func (seed *filterSeed) seedFilterData(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	for z, zoneSuffix := range []string{"-a", "-b"} {
+		logger.Debug().Str("zone", seed.names.zone+zoneSuffix).Msg("seeding zone")
+		zone, err := createZone(ctx, client, seed.names.zone+zoneSuffix)
+		if err != nil {
+			return errors.Wrap(err, "createZone")
+		}
+		seed.zones[z] = zone
+		seed.track(client.zones().resourceClient, string(zone.ZoneKey))
+
+		for i := 0; i < 2; i++ {
+			suffix := fmt.Sprintf("-%d", i+1)
+
+			cluster, err := createCluster(ctx, client, zone, seed.names.cluster+suffix)
+			if err != nil {
+				return errors.Wrap(err, "createCluster")
+			}
+			seed.clusters[z][i] = cluster
+			seed.track(client.clusters().resourceClient, string(cluster.ClusterKey))
+
+			domain, err := createDomain(ctx, client, zone, seed.names.domain+suffix)
+			if err != nil {
+				return errors.Wrap(err, "createDomain")
+			}
+			seed.domains[z][i] = domain
+			seed.track(client.domains().resourceClient, string(domain.DomainKey))
+
+			listener, err := createListener(ctx, client, zone, domain, seed.names.listener+suffix)
+			if err != nil {
+				return errors.Wrap(err, "createListener")
+			}
+			seed.listeners[z][i] = listener
+			seed.track(client.listeners().resourceClient, string(listener.ListenerKey))
+
+			sharedRules, err := createSharedRules(ctx, client, zone, seed.names.sharedRules+suffix)
+			if err != nil {
+				return errors.Wrap(err, "createSharedRules")
+			}
+			seed.sharedRules[z][i] = sharedRules
+			seed.track(client.sharedRules().resourceClient, string(sharedRules.SharedRulesKey))
+
+			route, err := createRoute(ctx, client, zone, domain, sharedRules, seed.names.routePath+suffix)
+			if err != nil {
+				return errors.Wrap(err, "createRoute")
+			}
+			seed.routes[z][i] = route
+			seed.track(client.routes().resourceClient, string(route.RouteKey))
+
+			proxy, err := createProxy(ctx, client, zone, domain, listener, seed.names.proxy+suffix)
+			if err != nil {
+				return errors.Wrap(err, "createProxy")
+			}
+			seed.proxies[z][i] = proxy
+			seed.track(client.proxies().resourceClient, string(proxy.ProxyKey))
+		}
+	}
+
+	return nil
+}
+
+// removeFilterData deletes the seeded objects, newest first.
+func (seed *filterSeed) removeFilterData(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	for i := len(seed.created) - 1; i >= 0; i-- {
+		object := seed.created[i]
+		logger.Debug().Str("object", cleanupName(object.rc.resource, object.key)).Msg("deleting")
+		if err := object.rc.removeByKey(ctx, object.key); err != nil {
+			return errors.Wrapf(err, "delete %s %s", object.rc.resource.segment, object.key)
+		}
+		seed.cleanup.forgetObject(object.rc.resource, object.key)
+		seed.created = seed.created[:i]
+	}
+
+	return nil
+}
+
+// filterCheck is one list request and the keys it should return. When
+// subset is set the result may hold other objects too, as it does when
+// listing a shared control plane without filters.
+type filterCheck struct {
+	description string
+	list        func() (interface{}, error)
+	want        []string
+	subset      bool
+}
+
+func runFilterChecks(logger zerolog.Logger, r resource, checks []filterCheck) error {
+	for _, check := range checks {
+		logger.Debug().Str("check", check.description).Msg("listing")
+		result, err := check.list()
+		if err != nil {
+			return errors.Wrapf(err, "%s: list %s", check.description, r.segment)
+		}
+		got := listedKeys(r, result)
+		if err = compareKeys(got, check.want, check.subset); err != nil {
+			return errors.Wrapf(err, "%s: list %s", check.description, r.segment)
+		}
+	}
+	return nil
+}
+
+// listedKeys returns the keys of the objects in a list result.
+func listedKeys(r resource, list interface{}) []string {
+	value := reflect.ValueOf(list)
+	keys := make([]string, 0, value.Len())
+	for i := 0; i < value.Len(); i++ {
+		key, _ := r.identify(value.Index(i).Addr().Interface())
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func compareKeys(got []string, want []string, subset bool) error {
+	have := make(map[string]bool, len(got))
+	for _, key := range got {
+		have[key] = true
+	}
+
+	var missing []string
+	for _, key := range want {
+		if !have[key] {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return errors.Errorf("missing %s from %s", strings.Join(missing, ", "), strings.Join(got, ", "))
+	}
+	if !subset && len(got) != len(want) {
+		sort.Strings(got)
+		return errors.Errorf("got %s, want only %s", strings.Join(got, ", "), strings.Join(want, ", "))
+	}
+
+	return nil
+}
+
+func (seed *filterSeed) filterZones(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	a, b := seed.zones[0], seed.zones[1]
+	zones := client.zones()
+
+	return runFilterChecks(logger, zoneResource, []filterCheck{
+		{
+			description: "no filters",
+			list:        func() (interface{}, error) { return zones.List(ctx) },
+			want:        []string{string(a.ZoneKey), string(b.ZoneKey)},
+			subset:      true,
+		},
+		{
+			description: "by key",
+			list:        func() (interface{}, error) { return zones.List(ctx, service.ZoneFilter{ZoneKey: a.ZoneKey}) },
+			want:        []string{string(a.ZoneKey)},
+		},
+		{
+			description: "by name",
+			list:        func() (interface{}, error) { return zones.List(ctx, service.ZoneFilter{Name: b.Name}) },
+			want:        []string{string(b.ZoneKey)},
+		},
+		{
+			description: "name or key",
+			list: func() (interface{}, error) {
+				return zones.List(ctx, service.ZoneFilter{Name: a.Name}, service.ZoneFilter{ZoneKey: b.ZoneKey})
+			},
+			want: []string{string(a.ZoneKey), string(b.ZoneKey)},
+		},
+		{
+			description: "name and mismatched key",
+			list: func() (interface{}, error) {
+				return zones.List(ctx, service.ZoneFilter{Name: a.Name, ZoneKey: b.ZoneKey})
+			},
+			want: nil,
+		},
+		{
+			description: "org key and name",
+			list: func() (interface{}, error) {
+				return zones.List(ctx, service.ZoneFilter{OrgKey: a.OrgKey, Name: a.Name})
+			},
+			want: []string{string(a.ZoneKey)},
+		},
+	})
+}
+
+func (seed *filterSeed) filterClusters(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	a, b := seed.clusters[0], seed.clusters[1]
+	zoneA, zoneB := seed.zones[0].ZoneKey, seed.zones[1].ZoneKey
+	clusters := client.clusters()
+
+	return runFilterChecks(logger, clusterResource, []filterCheck{
+		{
+			description: "no filters",
+			list:        func() (interface{}, error) { return clusters.List(ctx) },
+			want: []string{
+				string(a[0].ClusterKey), string(a[1].ClusterKey),
+				string(b[0].ClusterKey), string(b[1].ClusterKey),
+			},
+			subset: true,
+		},
+		{
+			description: "by key",
+			list: func() (interface{}, error) {
+				return clusters.List(ctx, service.ClusterFilter{ClusterKey: a[1].ClusterKey})
+			},
+			want: []string{string(a[1].ClusterKey)},
+		},
+		{
+			description: "key or key",
+			list: func() (interface{}, error) {
+				return clusters.List(ctx,
+					service.ClusterFilter{ClusterKey: a[0].ClusterKey},
+					service.ClusterFilter{ClusterKey: b[1].ClusterKey},
+				)
+			},
+			want: []string{string(a[0].ClusterKey), string(b[1].ClusterKey)},
+		},
+		{
+			description: "name across zones",
+			list:        func() (interface{}, error) { return clusters.List(ctx, service.ClusterFilter{Name: a[0].Name}) },
+			want:        []string{string(a[0].ClusterKey), string(b[0].ClusterKey)},
+		},
+		{
+			description: "zone",
+			list:        func() (interface{}, error) { return clusters.List(ctx, service.ClusterFilter{ZoneKey: zoneB}) },
+			want:        []string{string(b[0].ClusterKey), string(b[1].ClusterKey)},
+		},
+		{
+			description: "name and zone",
+			list: func() (interface{}, error) {
+				return clusters.List(ctx, service.ClusterFilter{Name: a[0].Name, ZoneKey: zoneB})
+			},
+			want: []string{string(b[0].ClusterKey)},
+		},
+		{
+			description: "name and zone or name and zone",
+			list: func() (interface{}, error) {
+				return clusters.List(ctx,
+					service.ClusterFilter{Name: a[0].Name, ZoneKey: zoneA},
+					service.ClusterFilter{Name: a[1].Name, ZoneKey: zoneB},
+				)
+			},
+			want: []string{string(a[0].ClusterKey), string(b[1].ClusterKey)},
+		},
+		{
+			description: "org key and zone",
+			list: func() (interface{}, error) {
+				return clusters.List(ctx, service.ClusterFilter{OrgKey: a[0].OrgKey, ZoneKey: zoneA})
+			},
+			want: []string{string(a[0].ClusterKey), string(a[1].ClusterKey)},
+		},
+	})
+}
+
+func (seed *filterSeed) filterDomains(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	a, b := seed.domains[0], seed.domains[1]
+	zoneA, zoneB := seed.zones[0].ZoneKey, seed.zones[1].ZoneKey
+	domains := client.domains()
+
+	return runFilterChecks(logger, domainResource, []filterCheck{
+		{
+			description: "no filters",
+			list:        func() (interface{}, error) { return domains.List(ctx) },
+			want: []string{
+				string(a[0].DomainKey), string(a[1].DomainKey),
+				string(b[0].DomainKey), string(b[1].DomainKey),
+			},
+			subset: true,
+		},
+		{
+			description: "by key",
+			list:        func() (interface{}, error) { return domains.List(ctx, service.DomainFilter{DomainKey: b[0].DomainKey}) },
+			want:        []string{string(b[0].DomainKey)},
+		},
+		{
+			description: "key or key",
+			list: func() (interface{}, error) {
+				return domains.List(ctx,
+					service.DomainFilter{DomainKey: a[1].DomainKey},
+					service.DomainFilter{DomainKey: b[0].DomainKey},
+				)
+			},
+			want: []string{string(a[1].DomainKey), string(b[0].DomainKey)},
+		},
+		{
+			description: "name across zones",
+			list:        func() (interface{}, error) { return domains.List(ctx, service.DomainFilter{Name: a[1].Name}) },
+			want:        []string{string(a[1].DomainKey), string(b[1].DomainKey)},
+		},
+		{
+			description: "zone",
+			list:        func() (interface{}, error) { return domains.List(ctx, service.DomainFilter{ZoneKey: zoneA}) },
+			want:        []string{string(a[0].DomainKey), string(a[1].DomainKey)},
+		},
+		{
+			description: "name and zone or name and zone",
+			list: func() (interface{}, error) {
+				return domains.List(ctx,
+					service.DomainFilter{Name: a[1].Name, ZoneKey: zoneA},
+					service.DomainFilter{Name: a[0].Name, ZoneKey: zoneB},
+				)
+			},
+			want: []string{string(a[1].DomainKey), string(b[0].DomainKey)},
+		},
+		{
+			description: "org key and name",
+			list: func() (interface{}, error) {
+				return domains.List(ctx, service.DomainFilter{OrgKey: a[0].OrgKey, Name: a[0].Name})
+			},
+			want: []string{string(a[0].DomainKey), string(b[0].DomainKey)},
+		},
+	})
+}
+
+func (seed *filterSeed) filterListeners(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	a, b := seed.listeners[0], seed.listeners[1]
+	zoneA, zoneB := seed.zones[0].ZoneKey, seed.zones[1].ZoneKey
+	listeners := client.listeners()
+
+	return runFilterChecks(logger, listenerResource, []filterCheck{
+		{
+			description: "no filters",
+			list:        func() (interface{}, error) { return listeners.List(ctx) },
+			want: []string{
+				string(a[0].ListenerKey), string(a[1].ListenerKey),
+				string(b[0].ListenerKey), string(b[1].ListenerKey),
+			},
+			subset: true,
+		},
+		{
+			description: "by key",
+			list: func() (interface{}, error) {
+				return listeners.List(ctx, service.ListenerFilter{ListenerKey: a[0].ListenerKey})
+			},
+			want: []string{string(a[0].ListenerKey)},
+		},
+		{
+			description: "key or key",
+			list: func() (interface{}, error) {
+				return listeners.List(ctx,
+					service.ListenerFilter{ListenerKey: a[0].ListenerKey},
+					service.ListenerFilter{ListenerKey: a[1].ListenerKey},
+				)
+			},
+			want: []string{string(a[0].ListenerKey), string(a[1].ListenerKey)},
+		},
+		{
+			description: "name across zones",
+			list:        func() (interface{}, error) { return listeners.List(ctx, service.ListenerFilter{Name: a[0].Name}) },
+			want:        []string{string(a[0].ListenerKey), string(b[0].ListenerKey)},
+		},
+		{
+			description: "zone",
+			list:        func() (interface{}, error) { return listeners.List(ctx, service.ListenerFilter{ZoneKey: zoneB}) },
+			want:        []string{string(b[0].ListenerKey), string(b[1].ListenerKey)},
+		},
+		{
+			description: "name and zone",
+			list: func() (interface{}, error) {
+				return listeners.List(ctx, service.ListenerFilter{Name: a[1].Name, ZoneKey: zoneA})
+			},
+			want: []string{string(a[1].ListenerKey)},
+		},
+		{
+			description: "org key and zone",
+			list: func() (interface{}, error) {
+				return listeners.List(ctx, service.ListenerFilter{OrgKey: b[0].OrgKey, ZoneKey: zoneB})
+			},
+			want: []string{string(b[0].ListenerKey), string(b[1].ListenerKey)},
+		},
+	})
+}
+
+func (seed *filterSeed) filterSharedRules(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	a, b := seed.sharedRules[0], seed.sharedRules[1]
+	zoneA, zoneB := seed.zones[0].ZoneKey, seed.zones[1].ZoneKey
+	sharedRules := client.sharedRules()
+
+	return runFilterChecks(logger, sharedRulesResource, []filterCheck{
+		{
+			description: "no filters",
+			list:        func() (interface{}, error) { return sharedRules.List(ctx) },
+			want: []string{
+				string(a[0].SharedRulesKey), string(a[1].SharedRulesKey),
+				string(b[0].SharedRulesKey), string(b[1].SharedRulesKey),
+			},
+			subset: true,
+		},
+		{
+			description: "by key",
+			list: func() (interface{}, error) {
+				return sharedRules.List(ctx, service.SharedRulesFilter{SharedRulesKey: b[1].SharedRulesKey})
+			},
+			want: []string{string(b[1].SharedRulesKey)},
+		},
+		{
+			description: "key or key",
+			list: func() (interface{}, error) {
+				return sharedRules.List(ctx,
+					service.SharedRulesFilter{SharedRulesKey: a[0].SharedRulesKey},
+					service.SharedRulesFilter{SharedRulesKey: b[1].SharedRulesKey},
+				)
+			},
+			want: []string{string(a[0].SharedRulesKey), string(b[1].SharedRulesKey)},
+		},
+		{
+			description: "name across zones",
+			list:        func() (interface{}, error) { return sharedRules.List(ctx, service.SharedRulesFilter{Name: a[1].Name}) },
+			want:        []string{string(a[1].SharedRulesKey), string(b[1].SharedRulesKey)},
+		},
+		{
+			description: "zone",
+			list:        func() (interface{}, error) { return sharedRules.List(ctx, service.SharedRulesFilter{ZoneKey: zoneA}) },
+			want:        []string{string(a[0].SharedRulesKey), string(a[1].SharedRulesKey)},
+		},
+		{
+			description: "name and zone",
+			list: func() (interface{}, error) {
+				return sharedRules.List(ctx, service.SharedRulesFilter{Name: a[0].Name, ZoneKey: zoneB})
+			},
+			want: []string{string(b[0].SharedRulesKey)},
+		},
+		{
+			description: "org key and name",
+			list: func() (interface{}, error) {
+				return sharedRules.List(ctx, service.SharedRulesFilter{OrgKey: a[0].OrgKey, Name: a[1].Name})
+			},
+			want: []string{string(a[1].SharedRulesKey), string(b[1].SharedRulesKey)},
+		},
+	})
+}
+
+func (seed *filterSeed) filterRoutes(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	a, b := seed.routes[0], seed.routes[1]
+	zoneA, zoneB := seed.zones[0].ZoneKey, seed.zones[1].ZoneKey
+	routes := client.routes()
+
+	return runFilterChecks(logger, routeResource, []filterCheck{
+		{
+			description: "no filters",
+			list:        func() (interface{}, error) { return routes.List(ctx) },
+			want: []string{
+				string(a[0].RouteKey), string(a[1].RouteKey),
+				string(b[0].RouteKey), string(b[1].RouteKey),
+			},
+			subset: true,
+		},
+		{
+			description: "by key",
+			list:        func() (interface{}, error) { return routes.List(ctx, service.RouteFilter{RouteKey: a[1].RouteKey}) },
+			want:        []string{string(a[1].RouteKey)},
+		},
+		{
+			description: "key or key",
+			list: func() (interface{}, error) {
+				return routes.List(ctx,
+					service.RouteFilter{RouteKey: a[1].RouteKey},
+					service.RouteFilter{RouteKey: b[0].RouteKey},
+				)
+			},
+			want: []string{string(a[1].RouteKey), string(b[0].RouteKey)},
+		},
+		{
+			description: "path across zones",
+			list:        func() (interface{}, error) { return routes.List(ctx, service.RouteFilter{Path: a[0].Path}) },
+			want:        []string{string(a[0].RouteKey), string(b[0].RouteKey)},
+		},
+		{
+			description: "path prefix",
+			list: func() (interface{}, error) {
+				return routes.List(ctx, service.RouteFilter{PathPrefix: seed.names.routePath})
+			},
+			want: []string{
+				string(a[0].RouteKey), string(a[1].RouteKey),
+				string(b[0].RouteKey), string(b[1].RouteKey),
+			},
+		},
+		{
+			description: "zone",
+			list:        func() (interface{}, error) { return routes.List(ctx, service.RouteFilter{ZoneKey: zoneB}) },
+			want:        []string{string(b[0].RouteKey), string(b[1].RouteKey)},
+		},
+		{
+			description: "domain",
+			list:        func() (interface{}, error) { return routes.List(ctx, service.RouteFilter{DomainKey: a[1].DomainKey}) },
+			want:        []string{string(a[1].RouteKey)},
+		},
+		{
+			description: "shared rules or domain",
+			list: func() (interface{}, error) {
+				return routes.List(ctx,
+					service.RouteFilter{SharedRulesKey: a[0].SharedRulesKey},
+					service.RouteFilter{DomainKey: b[1].DomainKey},
+				)
+			},
+			want: []string{string(a[0].RouteKey), string(b[1].RouteKey)},
+		},
+		{
+			description: "path prefix and zone",
+			list: func() (interface{}, error) {
+				return routes.List(ctx, service.RouteFilter{PathPrefix: seed.names.routePath, ZoneKey: zoneA})
+			},
+			want: []string{string(a[0].RouteKey), string(a[1].RouteKey)},
+		},
+		{
+			description: "org key and path",
+			list: func() (interface{}, error) {
+				return routes.List(ctx, service.RouteFilter{OrgKey: a[0].OrgKey, Path: a[1].Path})
+			},
+			want: []string{string(a[1].RouteKey), string(b[1].RouteKey)},
+		},
+	})
+}
+
+func (seed *filterSeed) filterProxies(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	a, b := seed.proxies[0], seed.proxies[1]
+	zoneA, zoneB := seed.zones[0].ZoneKey, seed.zones[1].ZoneKey
+	proxies := client.proxies()
+
+	return runFilterChecks(logger, proxyResource, []filterCheck{
+		{
+			description: "no filters",
+			list:        func() (interface{}, error) { return proxies.List(ctx) },
+			want: []string{
+				string(a[0].ProxyKey), string(a[1].ProxyKey),
+				string(b[0].ProxyKey), string(b[1].ProxyKey),
+			},
+			subset: true,
+		},
+		{
+			description: "by key",
+			list:        func() (interface{}, error) { return proxies.List(ctx, service.ProxyFilter{ProxyKey: b[1].ProxyKey}) },
+			want:        []string{string(b[1].ProxyKey)},
+		},
+		{
+			description: "key or key",
+			list: func() (interface{}, error) {
+				return proxies.List(ctx,
+					service.ProxyFilter{ProxyKey: a[0].ProxyKey},
+					service.ProxyFilter{ProxyKey: a[1].ProxyKey},
+				)
+			},
+			want: []string{string(a[0].ProxyKey), string(a[1].ProxyKey)},
+		},
+		{
+			description: "name across zones",
+			list:        func() (interface{}, error) { return proxies.List(ctx, service.ProxyFilter{Name: a[1].Name}) },
+			want:        []string{string(a[1].ProxyKey), string(b[1].ProxyKey)},
+		},
+		{
+			description: "zone",
+			list:        func() (interface{}, error) { return proxies.List(ctx, service.ProxyFilter{ZoneKey: zoneA}) },
+			want:        []string{string(a[0].ProxyKey), string(a[1].ProxyKey)},
+		},
+		{
+			description: "name and zone or name and zone",
+			list: func() (interface{}, error) {
+				return proxies.List(ctx,
+					service.ProxyFilter{Name: a[0].Name, ZoneKey: zoneB},
+					service.ProxyFilter{Name: a[1].Name, ZoneKey: zoneA},
+				)
+			},
+			want: []string{string(b[0].ProxyKey), string(a[1].ProxyKey)},
+		},
+		{
+			description: "org key and zone",
+			list: func() (interface{}, error) {
+				return proxies.List(ctx, service.ProxyFilter{OrgKey: b[0].OrgKey, ZoneKey: zoneB})
+			},
+			want: []string{string(b[0].ProxyKey), string(b[1].ProxyKey)},
+		},
+	})
+}
@@ -0,0 +1,708 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	api "github.com/deciphernow/gm-control-api/api"
+)
+
+// fuzzRequest is a request as the client would make it, before its body
+// is mutated.
+type fuzzRequest struct {
+	method string
+	path   string
+	values url.Values
+	body   interface{}
+}
+
+// fuzzTarget is one request whose body the fuzzer mutates. Edits fetch
+// their object first so that the body carries its current checksum.
+// created is the resource a successful request creates, if any.
+type fuzzTarget struct {
+	name    string
+	created *resource
+	request func(ctx context.Context, f *fuzzer) (fuzzRequest, error)
+}
+
+func fuzzCreate(r resource, body func(f *fuzzer) interface{}) func(context.Context, *fuzzer) (fuzzRequest, error) {
+	return func(ctx context.Context, f *fuzzer) (fuzzRequest, error) {
+		return fuzzRequest{method: "POST", path: r.collectionPath(), body: body(f)}, nil
+	}
+}
+
+func fuzzEdit(r resource, key func(f *fuzzer) string) func(context.Context, *fuzzer) (fuzzRequest, error) {
+	return func(ctx context.Context, f *fuzzer) (fuzzRequest, error) {
+		object := r.newObject()
+		if err := (resourceClient{client: f.client, resource: r}).get(ctx, key(f), object); err != nil {
+			return fuzzRequest{}, errors.Wrapf(err, "get %s", r.segment)
+		}
+		return fuzzRequest{method: "PUT", path: r.objectPath(key(f)), body: object}, nil
+	}
+}
+
+var fuzzTargets = []fuzzTarget{
+	{
+		name:    "createCluster",
+		created: &clusterResource,
+		request: fuzzCreate(clusterResource, func(f *fuzzer) interface{} {
+			return api.Cluster{ZoneKey: f.zone.ZoneKey, Name: f.names.name("fuzz-cluster")}
+		}),
+	},
+	{
+		name:    "createDomain",
+		created: &domainResource,
+		request: fuzzCreate(domainResource, func(f *fuzzer) interface{} {
+			return api.Domain{ZoneKey: f.zone.ZoneKey, Name: f.names.name("fuzz-domain")}
+		}),
+	},
+	{
+		name:    "createSharedRules",
+		created: &sharedRulesResource,
+		request: fuzzCreate(sharedRulesResource, func(f *fuzzer) interface{} {
+			return api.SharedRules{ZoneKey: f.zone.ZoneKey, Name: f.names.name("fuzz-shared-rules")}
+		}),
+	},
+	{
+		name:    "createListener",
+		created: &listenerResource,
+		request: fuzzCreate(listenerResource, func(f *fuzzer) interface{} {
+			return api.Listener{
+				ZoneKey:    f.zone.ZoneKey,
+				Name:       f.names.name("fuzz-listener"),
+				IP:         listenerIP,
+				Port:       listenerPort,
+				Protocol:   listenerProtocol,
+				DomainKeys: []api.DomainKey{f.domain.DomainKey},
+			}
+		}),
+	},
+	{
+		name:    "createRoute",
+		created: &routeResource,
+		request: fuzzCreate(routeResource, func(f *fuzzer) interface{} {
+			return api.Route{
+				Path:           f.names.path("/fuzz"),
+				ZoneKey:        f.zone.ZoneKey,
+				DomainKey:      f.domain.DomainKey,
+				SharedRulesKey: f.sharedRules.SharedRulesKey,
+			}
+		}),
+	},
+	{
+		name:    "createProxy",
+		created: &proxyResource,
+		request: fuzzCreate(proxyResource, func(f *fuzzer) interface{} {
+			return api.Proxy{
+				Name:         f.names.name("fuzz-proxy"),
+				ZoneKey:      f.zone.ZoneKey,
+				DomainKeys:   []api.DomainKey{f.domain.DomainKey},
+				ListenerKeys: []api.ListenerKey{f.listener.ListenerKey},
+			}
+		}),
+	},
+	{
+		name: "editCluster",
+		request: fuzzEdit(clusterResource, func(f *fuzzer) string {
+			return string(f.cluster.ClusterKey)
+		}),
+	},
+	{
+		name: "editDomain",
+		request: fuzzEdit(domainResource, func(f *fuzzer) string {
+			return string(f.domain.DomainKey)
+		}),
+	},
+	{
+		name: "editListener",
+		request: fuzzEdit(listenerResource, func(f *fuzzer) string {
+			return string(f.listener.ListenerKey)
+		}),
+	},
+	{
+		name: "editRoute",
+		request: fuzzEdit(routeResource, func(f *fuzzer) string {
+			return string(f.route.RouteKey)
+		}),
+	},
+	{
+		name: "editProxy",
+		request: fuzzEdit(proxyResource, func(f *fuzzer) string {
+			return string(f.proxy.ProxyKey)
+		}),
+	},
+	{
+		name: "putClusterInstance",
+		request: func(ctx context.Context, f *fuzzer) (fuzzRequest, error) {
+			cluster, err := getClusterByKey(ctx, f.client, f.cluster.ClusterKey)
+			if err != nil {
+				return fuzzRequest{}, errors.Wrap(err, "getClusterByKey")
+			}
+			values := url.Values{}
+			values.Add("checksum", cluster.Checksum.Checksum)
+			return fuzzRequest{
+				method: "PUT",
+				path:   clusterInstancesPath(cluster.ClusterKey),
+				values: values,
+				body:   api.Instance{Host: "10.2.0.1", Port: 9000},
+			}, nil
+		},
+	},
+}
+
+// fuzzUnicode are strings chosen to trip up encoding, collation and
+// display: non-Latin scripts, astral plane characters, NUL, direction
+// overrides, stacked combining marks and invisible characters.
+var fuzzUnicode = []string{
+	"\u00fcn\u00efc\u00f6d\u00e9 \u65e5\u672c\u8a9e \u0627\u0644\u0639\u0631\u0628\u064a\u0629",
+	"\U0001f680\U0001f525 \U0001d518\U0001d52b\U0001d526",
+	"nul\u0000byte",
+	"\u202eright to left",
+	"e\u0301\u0301\u0301\u0301\u0301",
+	"\u200b\u200d\ufeff",
+	"\ufffd\uffff",
+	"../../etc/passwd",
+	"' OR '1'='1",
+	"%s%n%x",
+}
+
+// fuzzNumbers are JSON numbers at and beyond the edges of the integer and
+// float types a server may decode them into.
+var fuzzNumbers = []json.Number{
+	"-1", "0.5", "1e400", "-1e400", "2147483648", "-2147483649",
+	"9223372036854775808", "18446744073709551616", "1e-400",
+}
+
+// fuzzFinding is a response that shows the server mishandled an input:
+// a server error, no response, or a body that is not the envelope.
+type fuzzFinding struct {
+	target    string
+	reason    string
+	detail    string
+	mutations []string
+	saved     string
+}
+
+func (finding fuzzFinding) String() string {
+	text := fmt.Sprintf("%s: %s: %s", finding.target, finding.reason, finding.detail)
+	if len(finding.mutations) > 0 {
+		text += "; mutations: " + strings.Join(finding.mutations, ", ")
+	}
+	if finding.saved != "" {
+		text += "; saved as " + finding.saved
+	}
+	return text
+}
+
+// fuzzer sends mutated request bodies to gm-control-api from a zone of its
+// own, holding the objects the targets create against and edit.
+type fuzzer struct {
+	logger      zerolog.Logger
+	client      *clientStruct
+	names       namer
+	rng         *rand.Rand
+	hangTimeout time.Duration
+	maxString   int
+	corpus      string
+
+	zone        api.Zone
+	cluster     api.Cluster
+	domain      api.Domain
+	sharedRules api.SharedRules
+	listener    api.Listener
+	route       api.Route
+	proxy       api.Proxy
+
+	// created holds the keys of every object made, by type
+	created  map[string][]string
+	findings []fuzzFinding
+}
+
+// seed creates the zone and the objects the targets refer to and edit.
+func (f *fuzzer) seed(ctx context.Context) error {
+	var err error
+	if f.zone, err = createZone(ctx, f.client, f.names.name("fuzz")); err != nil {
+		return errors.Wrap(err, "createZone")
+	}
+	if f.cluster, err = createCluster(ctx, f.client, f.zone, f.names.name("cluster")); err != nil {
+		return errors.Wrap(err, "createCluster")
+	}
+	f.remember(clusterResource, string(f.cluster.ClusterKey))
+	if f.domain, err = createDomain(ctx, f.client, f.zone, f.names.name("domain")); err != nil {
+		return errors.Wrap(err, "createDomain")
+	}
+	f.remember(domainResource, string(f.domain.DomainKey))
+	if f.sharedRules, err = createSharedRules(ctx, f.client, f.zone, f.names.name("shared-rules")); err != nil {
+		return errors.Wrap(err, "createSharedRules")
+	}
+	f.remember(sharedRulesResource, string(f.sharedRules.SharedRulesKey))
+	if f.listener, err = createListener(ctx, f.client, f.zone, f.domain, f.names.name("listener")); err != nil {
+		return errors.Wrap(err, "createListener")
+	}
+	f.remember(listenerResource, string(f.listener.ListenerKey))
+	if f.route, err = createRoute(ctx, f.client, f.zone, f.domain, f.sharedRules, f.names.path("/route")); err != nil {
+		return errors.Wrap(err, "createRoute")
+	}
+	f.remember(routeResource, string(f.route.RouteKey))
+	if f.proxy, err = createProxy(ctx, f.client, f.zone, f.domain, f.listener, f.names.name("proxy")); err != nil {
+		return errors.Wrap(err, "createProxy")
+	}
+	f.remember(proxyResource, string(f.proxy.ProxyKey))
+
+	return nil
+}
+
+func (f *fuzzer) remember(r resource, key string) {
+	if key != "" {
+		f.created[r.segment] = append(f.created[r.segment], key)
+	}
+}
+
+// cleanup deletes every object the fuzzer made, dependents first, and its
+// zone, and returns the number of failures. Objects listed in the zone are
+// deleted too, since a request that hung may have created one after all.
+func (f *fuzzer) cleanup(ctx context.Context) int {
+	if f.zone.ZoneKey == "" {
+		return 0
+	}
+
+	failures := 0
+	for i := len(snapshotKinds) - 1; i >= 0; i-- {
+		r := snapshotKinds[i].resource
+		rc := resourceClient{client: f.client, resource: r}
+		keys := f.created[r.segment]
+		listed, err := listZoneObjects(ctx, f.client, r, f.zone.ZoneKey)
+		if err != nil {
+			f.logger.Error().Err(err).Str("type", r.segment).Msg("cleanup list failed")
+			failures++
+		}
+		for _, value := range listed {
+			key, _ := r.identify(value)
+			keys = append(keys, key)
+		}
+		for _, key := range keys {
+			if err := rc.removeByKey(ctx, key); err != nil && !IsNotFound(err) {
+				f.logger.Error().Err(err).Str("type", r.segment).Str("key", key).Msg("cleanup failed")
+				failures++
+			}
+		}
+	}
+
+	zone, err := getZoneByKey(ctx, f.client, f.zone.ZoneKey)
+	if err == nil {
+		err = deleteZone(ctx, f.client, zone)
+	}
+	if err != nil && !IsNotFound(err) {
+		f.logger.Error().Err(err).Str("zone", f.zone.Name).Msg("cleanup failed")
+		failures++
+	}
+	return failures
+}
+
+// replayCorpus sends every saved input of target as it was saved.
+func (f *fuzzer) replayCorpus(ctx context.Context, target fuzzTarget) error {
+	dir := filepath.Join(f.corpus, target.name)
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "ReadDir")
+	}
+
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, info.Name())
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrap(err, "ReadFile")
+		}
+		request, err := target.request(ctx, f)
+		if err != nil {
+			return err
+		}
+		body = []byte(f.corpusKeys(request, true).Replace(string(body)))
+		finding, err := f.send(ctx, target, request, body)
+		if err != nil {
+			return err
+		}
+		if finding != nil {
+			finding.mutations = []string{"corpus " + path}
+			f.findings = append(f.findings, *finding)
+		}
+	}
+	return nil
+}
+
+// fuzz sends inputs mutated bodies to target, saving each input that
+// produces a finding to the corpus.
+func (f *fuzzer) fuzz(ctx context.Context, target fuzzTarget, inputs int) error {
+	for i := 0; i < inputs && ctx.Err() == nil; i++ {
+		request, err := target.request(ctx, f)
+		if err != nil {
+			return err
+		}
+		body, mutations, err := f.mutatedBody(request)
+		if err != nil {
+			return err
+		}
+		finding, err := f.send(ctx, target, request, body)
+		if err != nil {
+			return err
+		}
+		if finding == nil {
+			continue
+		}
+
+		finding.mutations = mutations
+		if finding.saved, err = f.save(target, request, body); err != nil {
+			return err
+		}
+		f.logger.Error().Str("target", target.name).Str("reason", finding.reason).
+			Str("detail", finding.detail).Strs("mutations", mutations).Msg("finding")
+		f.findings = append(f.findings, *finding)
+	}
+	return nil
+}
+
+// mutatedBody returns the request's body with one to three mutations
+// applied and a description of each.
+func (f *fuzzer) mutatedBody(request fuzzRequest) ([]byte, []string, error) {
+	contents, err := json.Marshal(request.body)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Marshal")
+	}
+
+	// one input in ten is damaged as bytes rather than as JSON
+	if f.rng.Intn(10) == 0 {
+		contents, mutation := f.damage(contents)
+		return contents, []string{mutation}, nil
+	}
+
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.UseNumber()
+	if err = decoder.Decode(&value); err != nil {
+		return nil, nil, errors.Wrap(err, "Decode")
+	}
+
+	var mutations []string
+	for n := 1 + f.rng.Intn(3); n > 0; n-- {
+		var mutation string
+		value, mutation = f.mutate(value, "")
+		mutations = append(mutations, mutation)
+	}
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err = encoder.Encode(value); err != nil {
+		return nil, nil, errors.Wrap(err, "Encode")
+	}
+	return buffer.Bytes(), mutations, nil
+}
+
+// mutate changes value at a randomly chosen depth: it drops a member,
+// adds an unknown one, or replaces a value with one of the wrong type, a
+// huge string, awkward unicode, an extreme number or deep nesting.
+func (f *fuzzer) mutate(value interface{}, path string) (interface{}, string) {
+	switch container := value.(type) {
+	case map[string]interface{}:
+		var fields []string
+		for field := range container {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		if len(fields) > 0 && f.rng.Intn(4) != 0 {
+			field := fields[f.rng.Intn(len(fields))]
+			switch f.rng.Intn(4) {
+			case 0:
+				delete(container, field)
+				return container, "drop " + fuzzPath(path, field)
+			case 1:
+				unknown := fmt.Sprintf("unknown_%d", f.rng.Intn(1000))
+				container[unknown], _ = f.replace(nil)
+				return container, "add unknown " + fuzzPath(path, unknown)
+			default:
+				var mutation string
+				container[field], mutation = f.mutate(container[field], fuzzPath(path, field))
+				return container, mutation
+			}
+		}
+	case []interface{}:
+		if len(container) > 0 && f.rng.Intn(4) != 0 {
+			i := f.rng.Intn(len(container))
+			var mutation string
+			container[i], mutation = f.mutate(container[i], fmt.Sprintf("%s[%d]", path, i))
+			return container, mutation
+		}
+	}
+
+	replacement, description := f.replace(value)
+	if path == "" {
+		path = "body"
+	}
+	return replacement, path + " to " + description
+}
+
+// replace returns a value to put in place of value and its description.
+func (f *fuzzer) replace(value interface{}) (interface{}, string) {
+	switch f.rng.Intn(6) {
+	case 0:
+		wrong := []interface{}{json.Number("12345"), "string", true, nil, []interface{}{"a", json.Number("1")}, map[string]interface{}{"a": "b"}}
+		for {
+			replacement := wrong[f.rng.Intn(len(wrong))]
+			if fmt.Sprintf("%T", replacement) != fmt.Sprintf("%T", value) {
+				return replacement, fmt.Sprintf("wrong type %T", replacement)
+			}
+		}
+	case 1:
+		size := 1 + f.rng.Intn(f.maxString)
+		return strings.Repeat("x", size), fmt.Sprintf("huge string (%d bytes)", size)
+	case 2:
+		text := fuzzUnicode[f.rng.Intn(len(fuzzUnicode))]
+		return text, fmt.Sprintf("string %q", text)
+	case 3:
+		number := fuzzNumbers[f.rng.Intn(len(fuzzNumbers))]
+		return number, "number " + number.String()
+	case 4:
+		depth := 100 + f.rng.Intn(2000)
+		var nested interface{} = "bottom"
+		for d := 0; d < depth; d++ {
+			nested = []interface{}{nested}
+		}
+		return nested, fmt.Sprintf("nesting %d deep", depth)
+	}
+	if _, ok := value.([]interface{}); ok {
+		return map[string]interface{}{}, "empty object"
+	}
+	return []interface{}{}, "empty array"
+}
+
+// damage breaks the encoded body: truncating it, inserting bytes that are
+// not UTF-8, or replacing it with JSON that is not an object.
+func (f *fuzzer) damage(contents []byte) ([]byte, string) {
+	switch f.rng.Intn(3) {
+	case 0:
+		at := f.rng.Intn(len(contents))
+		return contents[:at], fmt.Sprintf("truncate body at byte %d", at)
+	case 1:
+		at := f.rng.Intn(len(contents))
+		damaged := append(append(append([]byte(nil), contents[:at]...), 0xff, 0xfe, 0xc0), contents[at:]...)
+		return damaged, fmt.Sprintf("insert invalid UTF-8 at byte %d", at)
+	}
+	bodies := []string{"null", "[]", `"body"`, "12345", "", "{}{}"}
+	body := bodies[f.rng.Intn(len(bodies))]
+	return []byte(body), fmt.Sprintf("body %q", body)
+}
+
+func fuzzPath(path string, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// send makes the request with body in place of its own through the
+// client, so with its credentials and retry policy, bounded as a whole by
+// the hang timeout. It returns a finding when the response shows the
+// server mishandled the body; the error is for failing to make the
+// request at all.
+func (f *fuzzer) send(ctx context.Context, target fuzzTarget, fuzzed fuzzRequest, body []byte) (*fuzzFinding, error) {
+	hangCtx, cancel := context.WithTimeout(ctx, f.hangTimeout)
+	defer cancel()
+
+	request, err := f.client.newRequest(hangCtx, fuzzed.method, fuzzed.path, fuzzed.values, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "newRequest")
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	request.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	request.ContentLength = int64(len(body))
+
+	finding := func(reason string, format string, args ...interface{}) (*fuzzFinding, error) {
+		return &fuzzFinding{target: target.name, reason: reason, detail: fmt.Sprintf(format, args...)}, nil
+	}
+
+	envelope, contents, err := f.client.doEnvelope(request)
+	if err == nil {
+		return f.check(target, envelope, contents, finding)
+	}
+	if apiErr, ok := asAPIError(err); ok {
+		switch {
+		case apiErr.StatusCode >= 500:
+			return finding("server error", "(%d) %s [request %s]",
+				apiErr.StatusCode, fuzzExcerpt(apiErr.Body), apiErr.RequestID)
+		case apiErr.NotEnvelope:
+			return finding("not an envelope", "(%d) %s: %s [request %s]",
+				apiErr.StatusCode, apiErr.Message, fuzzExcerpt(apiErr.Body), apiErr.RequestID)
+		}
+		return nil, nil
+	}
+	switch {
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	case hangCtx.Err() != nil:
+		return finding("hung", "no response within %s", f.hangTimeout)
+	}
+	return finding("no response", "%s", err)
+}
+
+// check inspects the envelope of a successful response for a result, and
+// remembers what a successful create made. A create whose result does not
+// decode, or holds no key, is a finding.
+func (f *fuzzer) check(
+	target fuzzTarget,
+	envelope map[string]json.RawMessage,
+	contents []byte,
+	finding func(reason string, format string, args ...interface{}) (*fuzzFinding, error),
+) (*fuzzFinding, error) {
+	result, ok := envelope["result"]
+	if !ok {
+		return finding("not an envelope", "(200) no result member: %s", fuzzExcerpt(contents))
+	}
+	if target.created != nil {
+		object := target.created.newObject()
+		if err := json.Unmarshal(result, object); err != nil {
+			// remember the key regardless, so that cleanup deletes it
+			var fields map[string]interface{}
+			if fieldsErr := json.Unmarshal(result, &fields); fieldsErr != nil {
+				return finding("not an envelope", "(200) result is not a JSON object: %s: %s",
+					fieldsErr, fuzzExcerpt(contents))
+			}
+			key, _ := fields[target.created.segment+"_key"].(string)
+			f.remember(*target.created, key)
+			return finding("not an envelope", "(200) result is not a %s: %s",
+				target.created.segment, err)
+		}
+		key, _ := target.created.identify(object)
+		if key == "" {
+			return finding("not an envelope", "(200) result has no %s_key: %s",
+				target.created.segment, fuzzExcerpt(contents))
+		}
+		f.remember(*target.created, key)
+	}
+	return nil, nil
+}
+
+// save writes body to the target's corpus directory under a name derived
+// from its contents.
+func (f *fuzzer) save(target fuzzTarget, request fuzzRequest, body []byte) (string, error) {
+	body = []byte(f.corpusKeys(request, false).Replace(string(body)))
+	dir := filepath.Join(f.corpus, target.name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrap(err, "MkdirAll")
+	}
+	sum := sha1.Sum(body)
+	path := filepath.Join(dir, hex.EncodeToString(sum[:])[:16]+".json")
+	if err := ioutil.WriteFile(path, body, 0644); err != nil {
+		return "", errors.Wrap(err, "WriteFile")
+	}
+	return path, nil
+}
+
+// corpusKeys replaces the keys of the fuzzer's objects and the checksum
+// the request carries with placeholders, or with restore, the reverse.
+// Saved inputs hold placeholders so that they replay against the objects
+// of a later run.
+func (f *fuzzer) corpusKeys(request fuzzRequest, restore bool) *strings.Replacer {
+	keys := map[string]string{
+		"$zone_key":         string(f.zone.ZoneKey),
+		"$cluster_key":      string(f.cluster.ClusterKey),
+		"$domain_key":       string(f.domain.DomainKey),
+		"$shared_rules_key": string(f.sharedRules.SharedRulesKey),
+		"$listener_key":     string(f.listener.ListenerKey),
+		"$route_key":        string(f.route.RouteKey),
+		"$proxy_key":        string(f.proxy.ProxyKey),
+	}
+	if fields, err := toFields(request.body); err == nil {
+		keys["$checksum"], _ = fields["checksum"].(string)
+	}
+
+	var placeholders []string
+	for placeholder := range keys {
+		placeholders = append(placeholders, placeholder)
+	}
+	sort.Strings(placeholders)
+
+	var pairs []string
+	for _, placeholder := range placeholders {
+		if keys[placeholder] == "" {
+			continue
+		}
+		// match whole JSON strings only
+		quotedKey := fmt.Sprintf("%q", keys[placeholder])
+		quotedPlaceholder := fmt.Sprintf("%q", placeholder)
+		if restore {
+			pairs = append(pairs, quotedPlaceholder, quotedKey)
+		} else {
+			pairs = append(pairs, quotedKey, quotedPlaceholder)
+		}
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// fuzzExcerpt shortens a response body for a finding.
+func fuzzExcerpt(contents []byte) string {
+	const limit = 200
+	text := strings.TrimSpace(string(contents))
+	if len(text) > limit {
+		text = text[:limit] + "..."
+	}
+	return fmt.Sprintf("%q", text)
+}
+
+// selectFuzzTargets returns the targets named in a comma separated list,
+// or all of them for an empty list.
+func selectFuzzTargets(names string) ([]fuzzTarget, error) {
+	if names == "" {
+		return fuzzTargets, nil
+	}
+	byName := make(map[string]fuzzTarget, len(fuzzTargets))
+	var known []string
+	for _, target := range fuzzTargets {
+		byName[target.name] = target
+		known = append(known, target.name)
+	}
+
+	var selected []fuzzTarget
+	for _, name := range strings.Split(names, ",") {
+		target, ok := byName[strings.TrimSpace(name)]
+		if !ok {
+			return nil, errors.Errorf("unknown target %q; known are %s", name, strings.Join(known, ", "))
+		}
+		selected = append(selected, target)
+	}
+	return selected, nil
+}
+
+// writeFuzzFindings prints one line per finding.
+func writeFuzzFindings(w io.Writer, findings []fuzzFinding) error {
+	for _, finding := range findings {
+		if _, err := fmt.Fprintln(w, finding); err != nil {
+			return err
+		}
+	}
+	return nil
+}
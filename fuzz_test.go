@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFuzzMutatedBody(t *testing.T) {
+	bodies := []struct {
+		name string
+		body interface{}
+	}{
+		{name: "object", body: map[string]interface{}{"name": "zone1", "zone_key": "Z1", "port": 8080}},
+		{
+			name: "nested",
+			body: map[string]interface{}{
+				"name":      "cluster1",
+				"instances": []interface{}{map[string]interface{}{"host": "10.0.0.1", "port": 9000}},
+				"metadata":  map[string]interface{}{"a": "b"},
+			},
+		},
+		{name: "empty object", body: map[string]interface{}{}},
+		{name: "array", body: []interface{}{"a", "b"}},
+	}
+	const maxString = 64
+
+	for _, body := range bodies {
+		t.Run(body.name, func(t *testing.T) {
+			for seed := int64(1); seed <= 200; seed++ {
+				f := &fuzzer{rng: rand.New(rand.NewSource(seed)), maxString: maxString}
+				contents, mutations, err := f.mutatedBody(fuzzRequest{body: body.body})
+				if err != nil {
+					t.Fatalf("seed %d: mutatedBody: %v", seed, err)
+				}
+				if len(mutations) < 1 || len(mutations) > 3 {
+					t.Errorf("seed %d: %d mutations, want 1 to 3", seed, len(mutations))
+				}
+				for _, mutation := range mutations {
+					if mutation == "" {
+						t.Errorf("seed %d: undescribed mutation", seed)
+					}
+				}
+
+				again := &fuzzer{rng: rand.New(rand.NewSource(seed)), maxString: maxString}
+				againContents, againMutations, _ := again.mutatedBody(fuzzRequest{body: body.body})
+				if !bytes.Equal(contents, againContents) || !reflect.DeepEqual(mutations, againMutations) {
+					t.Errorf("seed %d: the same seed mutated differently", seed)
+				}
+
+				// a body mutated as JSON must still be JSON; one damaged as
+				// bytes must not be a single JSON object, or not UTF-8
+				invalidUTF8 := len(mutations) == 1 && strings.HasPrefix(mutations[0], "insert invalid UTF-8")
+				if invalidUTF8 {
+					if utf8.Valid(contents) {
+						t.Errorf("seed %d: %s left valid UTF-8", seed, mutations[0])
+					}
+					continue
+				}
+				damaged := len(mutations) == 1 &&
+					(strings.HasPrefix(mutations[0], "truncate") || strings.HasPrefix(mutations[0], `body "`))
+				var decoded interface{}
+				decoder := json.NewDecoder(bytes.NewReader(contents))
+				decoder.UseNumber()
+				decodeErr := decoder.Decode(&decoded)
+				_, isObject := decoded.(map[string]interface{})
+				if decodeErr == nil && decoder.More() {
+					isObject = false
+				}
+				switch {
+				case damaged && decodeErr == nil && isObject:
+					t.Errorf("seed %d: %s left a JSON object: %s", seed, mutations[0], contents)
+				case !damaged && decodeErr != nil:
+					t.Errorf("seed %d: %v made invalid JSON: %v", seed, mutations, decodeErr)
+				}
+			}
+		})
+	}
+}
+
+func TestFuzzMutateLeavesBodyUntouched(t *testing.T) {
+	body := map[string]interface{}{"name": "zone1", "tags": []interface{}{"a"}}
+	for seed := int64(1); seed <= 50; seed++ {
+		f := &fuzzer{rng: rand.New(rand.NewSource(seed)), maxString: 16}
+		if _, _, err := f.mutatedBody(fuzzRequest{body: body}); err != nil {
+			t.Fatalf("seed %d: mutatedBody: %v", seed, err)
+		}
+	}
+	want := map[string]interface{}{"name": "zone1", "tags": []interface{}{"a"}}
+	if !reflect.DeepEqual(body, want) {
+		t.Errorf("request body changed to %v", body)
+	}
+}
+
+func TestFuzzReplace(t *testing.T) {
+	values := []interface{}{
+		"string",
+		json.Number("1"),
+		true,
+		nil,
+		[]interface{}{"a"},
+		map[string]interface{}{"a": "b"},
+	}
+	const maxString = 32
+
+	for _, value := range values {
+		for seed := int64(1); seed <= 100; seed++ {
+			f := &fuzzer{rng: rand.New(rand.NewSource(seed)), maxString: maxString}
+			replacement, description := f.replace(value)
+			switch {
+			case strings.HasPrefix(description, "wrong type"):
+				if reflect.TypeOf(replacement) == reflect.TypeOf(value) {
+					t.Errorf("replace(%v) gave %v of the same type", value, replacement)
+				}
+			case strings.HasPrefix(description, "huge string"):
+				text, _ := replacement.(string)
+				if len(text) < 1 || len(text) > maxString {
+					t.Errorf("replace(%v) gave a %d byte string, want 1 to %d", value, len(text), maxString)
+				}
+			case description == "empty object" || description == "empty array":
+				if reflect.TypeOf(replacement) == reflect.TypeOf(value) {
+					t.Errorf("replace(%v) gave %s of the same type", value, description)
+				}
+			}
+		}
+	}
+}
+
+func TestFuzzPath(t *testing.T) {
+	tests := []struct {
+		path  string
+		field string
+		want  string
+	}{
+		{path: "", field: "name", want: "name"},
+		{path: "instances[0]", field: "host", want: "instances[0].host"},
+		{path: "a.b", field: "c", want: "a.b.c"},
+	}
+
+	for _, test := range tests {
+		if got := fuzzPath(test.path, test.field); got != test.want {
+			t.Errorf("fuzzPath(%q, %q) = %q, want %q", test.path, test.field, got, test.want)
+		}
+	}
+}
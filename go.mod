@@ -8,4 +8,5 @@ require (
 	github.com/rs/zerolog v1.14.3
 	github.com/spf13/viper v1.4.0
 	golang.org/x/sys v0.0.0-20190620070143-6f217b454f45 // indirect
+	gopkg.in/yaml.v2 v2.2.2
 )
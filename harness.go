@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// stepFunc is the signature shared by every step of the suite.
+type stepFunc func(context.Context, zerolog.Logger, *clientStruct) error
+
+// step is one named unit of work in a scenario. A step runs only when
+// every step named in dependsOn, within the same scenario, has passed.
+type step struct {
+	name        string
+	description string
+	dependsOn   []string
+	tags        []string
+	run         stepFunc
+}
+
+// scenario is an ordered list of steps sharing state, usually a Model.
+type scenario struct {
+	name        string
+	description string
+	steps       []step
+}
+
+type stepStatus string
+
+const (
+	stepPassed  stepStatus = "pass"
+	stepFailed  stepStatus = "fail"
+	stepSkipped stepStatus = "skip"
+)
+
+type stepResult struct {
+	scenario    string
+	step        string
+	description string
+	status      stepStatus
+	started     time.Time
+	duration    time.Duration
+	err         error
+	reason      string
+}
+
+// skipError is returned by a step that decides it cannot apply, for
+// example because the control plane lacks a feature.
+type skipError struct {
+	reason string
+}
+
+func (skip skipError) Error() string {
+	return "skipped: " + skip.reason
+}
+
+func skipStep(format string, args ...interface{}) error {
+	return skipError{reason: fmt.Sprintf(format, args...)}
+}
+
+// runner runs scenarios, collecting a result for every step rather than
+// stopping at the first failure. When tags is non-empty only steps carrying
+// one of them run, together with the steps they depend on; steps carrying
+// one of skipTags never run.
+type runner struct {
+	logger   zerolog.Logger
+	client   *clientStruct
+	tags     []string
+	skipTags []string
+}
+
+func (r *runner) run(ctx context.Context, scenarios []scenario) []stepResult {
+	var results []stepResult
+	for _, s := range scenarios {
+		results = append(results, r.runScenario(ctx, s)...)
+	}
+	return results
+}
+
+func (r *runner) runScenario(ctx context.Context, s scenario) []stepResult {
+	logger := r.logger.With().Str("scenario", s.name).Logger()
+	logger.Info().Msg(s.description)
+
+	selected := r.selectSteps(s)
+	status := make(map[string]stepStatus)
+	var results []stepResult
+
+	for _, st := range s.steps {
+		result := stepResult{
+			scenario:    s.name,
+			step:        st.name,
+			description: st.description,
+			status:      stepSkipped,
+			started:     time.Now(),
+		}
+
+		switch {
+		case !selected[st.name]:
+			result.reason = "not selected by tags"
+		case ctx.Err() != nil:
+			result.reason = ctx.Err().Error()
+		default:
+			for _, dependency := range st.dependsOn {
+				if status[dependency] != stepPassed {
+					result.reason = fmt.Sprintf("dependency %s did not pass", dependency)
+					break
+				}
+			}
+		}
+
+		if result.reason == "" {
+			stepLogger := logger.With().Str("step", st.name).Logger()
+			stepLogger.Info().Msg(st.description)
+
+			err := st.run(ctx, stepLogger, r.client)
+			result.duration = time.Since(result.started)
+
+			if skip, ok := errors.Cause(err).(skipError); ok {
+				result.reason = skip.reason
+			} else if err != nil {
+				result.status = stepFailed
+				result.err = err
+				stepLogger.Error().Err(err).Dur("duration", result.duration).Msg("step failed")
+			} else {
+				result.status = stepPassed
+			}
+		}
+
+		if result.status == stepSkipped {
+			logger.Info().Str("step", st.name).Str("reason", result.reason).Msg("step skipped")
+		}
+
+		status[st.name] = result.status
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// selectSteps applies the runner's tags to the steps of s.
+func (r *runner) selectSteps(s scenario) map[string]bool {
+	byName := make(map[string]step, len(s.steps))
+	for _, st := range s.steps {
+		byName[st.name] = st
+	}
+
+	selected := make(map[string]bool)
+	var include func(name string)
+	include = func(name string) {
+		if selected[name] {
+			return
+		}
+		selected[name] = true
+		for _, dependency := range byName[name].dependsOn {
+			include(dependency)
+		}
+	}
+
+	for _, st := range s.steps {
+		if len(r.tags) == 0 || hasAnyTag(st, r.tags) {
+			include(st.name)
+		}
+	}
+	for _, st := range s.steps {
+		if hasAnyTag(st, r.skipTags) {
+			delete(selected, st.name)
+		}
+	}
+
+	return selected
+}
+
+func hasAnyTag(st step, tags []string) bool {
+	for _, tag := range tags {
+		for _, stepTag := range st.tags {
+			if tag == stepTag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseTags splits a comma separated tag list, ignoring blanks.
+func parseTags(text string) []string {
+	var tags []string
+	for _, tag := range strings.Split(text, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// countResults returns the number of passed, failed and skipped steps.
+func countResults(results []stepResult) (passed, failed, skipped int) {
+	for _, result := range results {
+		switch result.status {
+		case stepPassed:
+			passed++
+		case stepFailed:
+			failed++
+		case stepSkipped:
+			skipped++
+		}
+	}
+	return passed, failed, skipped
+}
+
+// writeSummary prints one line per step followed by the totals.
+func writeSummary(w io.Writer, results []stepResult) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "SCENARIO\tSTEP\tSTATUS\tDURATION\tDETAIL")
+	var total time.Duration
+	for _, result := range results {
+		detail := result.reason
+		if result.err != nil {
+			detail = result.err.Error()
+		}
+		total += result.duration
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			result.scenario,
+			result.step,
+			result.status,
+			result.duration.Round(time.Millisecond),
+			detail,
+		)
+	}
+
+	passed, failed, skipped := countResults(results)
+	fmt.Fprintf(tw, "\n%d passed, %d failed, %d skipped in %s\n",
+		passed, failed, skipped, total.Round(time.Millisecond))
+
+	return tw.Flush()
+}
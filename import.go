@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	api "github.com/deciphernow/gm-control-api/api"
+)
+
+// importedZone is a snapshot zone as import created it: the new zone, the
+// new keys of its objects by name, and by type and identity.
+type importedZone struct {
+	zone  api.Zone
+	index *zoneIndex
+	keys  map[string]map[string]string
+}
+
+// keyMapping is one object whose key in the snapshot was replaced by the
+// key gm-control-api assigned on import.
+type keyMapping struct {
+	segment  string
+	zone     string
+	identity string
+	oldKey   string
+	newKey   string
+}
+
+// importSnapshot creates every zone and object in s, in dependency order.
+// It refuses to start if any of the zones already exists. Each object
+// gets a new key; references are resolved by name to the new keys. Every
+// object is registered with created as it is made, so that a caller can
+// delete what a failed import left behind.
+func importSnapshot(
+	ctx context.Context,
+	logger zerolog.Logger,
+	client *clientStruct,
+	s snapshot,
+	created *cleanupRegistry,
+) (map[string]*importedZone, []keyMapping, error) {
+	for _, declared := range s.Zones {
+		_, exists, err := findZone(ctx, client, declared.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if exists {
+			return nil, nil, errors.Errorf("zone %s already exists", declared.Name)
+		}
+	}
+
+	imported := make(map[string]*importedZone)
+	var mappings []keyMapping
+
+	for i := range s.Zones {
+		declared := &s.Zones[i]
+		logger.Info().Str("zone", declared.Name).Msg("importing zone")
+
+		zone, err := createZone(ctx, client, declared.Name)
+		if err != nil {
+			return imported, mappings, errors.Wrapf(err, "create zone %s", declared.Name)
+		}
+		created.registerObject(client.zones().resourceClient, string(zone.ZoneKey))
+		result := &importedZone{
+			zone:  zone,
+			index: newZoneIndex(),
+			keys:  make(map[string]map[string]string),
+		}
+		imported[declared.Name] = result
+		mappings = append(mappings, keyMapping{
+			segment:  "zone",
+			zone:     declared.Name,
+			identity: declared.Name,
+			oldKey:   declared.ZoneKey,
+			newKey:   string(zone.ZoneKey),
+		})
+
+		for _, kind := range snapshotKinds {
+			segment := kind.resource.segment
+			result.keys[segment] = make(map[string]string)
+
+			for _, object := range *kind.section(declared) {
+				identity := kind.identity(object)
+				newKey, err := importObject(ctx, client, kind, object, result)
+				if err != nil {
+					return imported, mappings, errors.Wrapf(err, "zone %s: create %s %s", declared.Name, segment, identity)
+				}
+				created.registerObject(resourceClient{client: client, resource: kind.resource}, newKey)
+				logger.Debug().Str("zone", declared.Name).Str("type", segment).
+					Str("object", identity).Str("key", newKey).Msg("imported")
+
+				result.keys[segment][identity] = newKey
+				if name, ok := object["name"].(string); ok {
+					result.index.add(segment, name, newKey)
+				}
+				oldKey, _ := object[kind.keyField].(string)
+				mappings = append(mappings, keyMapping{
+					segment:  segment,
+					zone:     declared.Name,
+					identity: identity,
+					oldKey:   oldKey,
+					newKey:   newKey,
+				})
+			}
+		}
+	}
+
+	return imported, mappings, nil
+}
+
+func importObject(
+	ctx context.Context,
+	client *clientStruct,
+	kind snapshotKind,
+	object snapshotObject,
+	zone *importedZone,
+) (string, error) {
+	fields, err := fromSnapshotObject(kind, object, zone.zone.ZoneKey, zone.index)
+	if err != nil {
+		return "", err
+	}
+	value, err := fromFields(kind.resource, fields)
+	if err != nil {
+		return "", err
+	}
+
+	rc := resourceClient{client: client, resource: kind.resource}
+	result := kind.resource.newObject()
+	if err = rc.create(ctx, value, result); err != nil {
+		return "", err
+	}
+	key, _ := kind.resource.identify(result)
+	return key, nil
+}
+
+// verifyImport reads back every imported zone and checks, with the api
+// types' Equals methods, that each object is the snapshot's object under
+// its new keys, and that the zone holds nothing else. It returns one line
+// per difference.
+func verifyImport(ctx context.Context, client *clientStruct, s snapshot, imported map[string]*importedZone) ([]string, error) {
+	var problems []string
+
+	for i := range s.Zones {
+		declared := &s.Zones[i]
+		result := imported[declared.Name]
+
+		zone, err := getZoneByKey(ctx, client, result.zone.ZoneKey)
+		if err != nil {
+			return problems, errors.Wrapf(err, "get zone %s", declared.Name)
+		}
+		expectedZone := api.Zone{
+			ZoneKey:  result.zone.ZoneKey,
+			Name:     declared.Name,
+			OrgKey:   zone.OrgKey,
+			Checksum: zone.Checksum,
+		}
+		if !zone.Equals(expectedZone) {
+			problems = append(problems, fmt.Sprintf("zone %s: got %+v, want %+v", declared.Name, zone, expectedZone))
+		}
+
+		for _, kind := range snapshotKinds {
+			segment := kind.resource.segment
+			values, err := listZoneObjects(ctx, client, kind.resource, result.zone.ZoneKey)
+			if err != nil {
+				return problems, errors.Wrapf(err, "zone %s: list %s", declared.Name, segment)
+			}
+			live := make(map[string]interface{}, len(values))
+			for _, value := range values {
+				key, _ := kind.resource.identify(value)
+				live[key] = value
+			}
+
+			for _, object := range *kind.section(declared) {
+				identity := kind.identity(object)
+				key := result.keys[segment][identity]
+				value, ok := live[key]
+				delete(live, key)
+				if !ok {
+					problems = append(problems, fmt.Sprintf("zone %s: %s %s (%s) is missing", declared.Name, segment, identity, key))
+					continue
+				}
+
+				expected, err := expectedObject(kind, object, key, value, result)
+				if err != nil {
+					return problems, errors.Wrapf(err, "zone %s: %s %s", declared.Name, segment, identity)
+				}
+				if !kind.resource.equal(value, expected) {
+					problems = append(problems, fmt.Sprintf("zone %s: %s %s: got %+v, want %+v",
+						declared.Name, segment, identity, value, expected))
+				}
+			}
+
+			var extra []string
+			for key := range live {
+				extra = append(extra, key)
+			}
+			sort.Strings(extra)
+			for _, key := range extra {
+				problems = append(problems, fmt.Sprintf("zone %s: unexpected %s %s", declared.Name, segment, key))
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// expectedObject is the value a snapshot object should have after import:
+// its fields with references resolved to new keys, and its own key, org key
+// and checksum taken from what gm-control-api assigned.
+func expectedObject(kind snapshotKind, object snapshotObject, key string, live interface{}, zone *importedZone) (interface{}, error) {
+	fields, err := fromSnapshotObject(kind, object, zone.zone.ZoneKey, zone.index)
+	if err != nil {
+		return nil, err
+	}
+	liveFields, err := toFields(live)
+	if err != nil {
+		return nil, err
+	}
+	fields[kind.keyField] = key
+	fields["org_key"] = liveFields["org_key"]
+	fields["checksum"] = liveFields["checksum"]
+
+	return fromFields(kind.resource, fields)
+}
+
+// writeKeyMappings prints the old and new key of every imported object.
+func writeKeyMappings(w io.Writer, mappings []keyMapping) error {
+	for _, mapping := range mappings {
+		name := mapping.zone
+		if mapping.segment != "zone" {
+			name += "/" + mapping.identity
+		}
+		oldKey := mapping.oldKey
+		if oldKey == "" {
+			oldKey = "(none)"
+		}
+		if _, err := fmt.Fprintf(w, "%s %s: %s -> %s\n", mapping.segment, name, oldKey, mapping.newKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	api "github.com/deciphernow/gm-control-api/api"
+)
+
+// The referential integrity steps create scratch objects alongside the
+// model's, so that each refusal can only be explained by the one reference
+// under test, and then show the delete succeeding once that reference is
+// gone.
+
+func expectInUse(operation string, err error) error {
+	if err == nil {
+		return errors.Errorf("%s of a referenced object succeeded", operation)
+	}
+	if !IsInUse(err) {
+		return errors.Wrapf(err, "%s of a referenced object: expected an in use error", operation)
+	}
+	return nil
+}
+
+func expectMissingReference(operation string, err error) error {
+	if err == nil {
+		return errors.Errorf("%s with a dangling reference succeeded", operation)
+	}
+	if !IsMissingReference(err) {
+		return errors.Wrapf(err, "%s with a dangling reference: expected a missing reference error", operation)
+	}
+	return nil
+}
+
+func (model *Model) refuseDeleteZoneInUse(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	logger.Debug().Msg("creating a scratch zone with a cluster")
+	zone, err := createZone(ctx, client, model.names.zone+"-in-use")
+	if err != nil {
+		return errors.Wrap(err, "createZone")
+	}
+	model.cleanup.registerObject(client.zones().resourceClient, string(zone.ZoneKey))
+	cluster, err := createCluster(ctx, client, zone, model.names.cluster+"-in-use")
+	if err != nil {
+		return errors.Wrap(err, "createCluster")
+	}
+	model.cleanup.registerObject(client.clusters().resourceClient, string(cluster.ClusterKey))
+
+	logger.Debug().Msg("deleting the zone while the cluster is live")
+	if err = expectInUse("deleteZone", deleteZone(ctx, client, zone)); err != nil {
+		return err
+	}
+	if _, err = getZoneByKey(ctx, client, zone.ZoneKey); err != nil {
+		return errors.Wrap(err, "getZoneByKey after refused delete")
+	}
+
+	logger.Debug().Msg("deleting the cluster, then the zone")
+	if err = deleteCluster(ctx, client, cluster); err != nil {
+		return errors.Wrap(err, "deleteCluster")
+	}
+	model.cleanup.forgetObject(clusterResource, string(cluster.ClusterKey))
+	if err = deleteZone(ctx, client, zone); err != nil {
+		return errors.Wrap(err, "deleteZone after deleting cluster")
+	}
+	model.cleanup.forgetObject(zoneResource, string(zone.ZoneKey))
+
+	return nil
+}
+
+func (model *Model) refuseDeleteDomainInUse(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	logger.Debug().Msg("creating a scratch domain with a listener")
+	domain, err := createDomain(ctx, client, model.Zone, model.names.domain+"-in-use")
+	if err != nil {
+		return errors.Wrap(err, "createDomain")
+	}
+	model.cleanup.registerObject(client.domains().resourceClient, string(domain.DomainKey))
+	listener, err := createListener(ctx, client, model.Zone, domain, model.names.listener+"-in-use")
+	if err != nil {
+		return errors.Wrap(err, "createListener")
+	}
+	model.cleanup.registerObject(client.listeners().resourceClient, string(listener.ListenerKey))
+
+	logger.Debug().Msg("deleting the domain while the listener refers to it")
+	if err = expectInUse("deleteDomain", deleteDomain(ctx, client, domain)); err != nil {
+		return errors.Wrap(err, "referenced by listener")
+	}
+	if err = deleteListener(ctx, client, listener); err != nil {
+		return errors.Wrap(err, "deleteListener")
+	}
+	model.cleanup.forgetObject(listenerResource, string(listener.ListenerKey))
+
+	logger.Debug().Msg("deleting the domain while a proxy refers to it")
+	proxy, err := client.proxies().Create(ctx, api.Proxy{
+		Name:       model.names.proxy + "-in-use",
+		ZoneKey:    model.Zone.ZoneKey,
+		DomainKeys: []api.DomainKey{domain.DomainKey},
+	})
+	if err != nil {
+		return errors.Wrap(err, "Create proxy")
+	}
+	model.cleanup.registerObject(client.proxies().resourceClient, string(proxy.ProxyKey))
+	if err = expectInUse("deleteDomain", deleteDomain(ctx, client, domain)); err != nil {
+		return errors.Wrap(err, "referenced by proxy")
+	}
+	if err = deleteProxy(ctx, client, proxy); err != nil {
+		return errors.Wrap(err, "deleteProxy")
+	}
+	model.cleanup.forgetObject(proxyResource, string(proxy.ProxyKey))
+
+	logger.Debug().Msg("deleting the unreferenced domain")
+	if err = deleteDomain(ctx, client, domain); err != nil {
+		return errors.Wrap(err, "deleteDomain after deleting referrers")
+	}
+	model.cleanup.forgetObject(domainResource, string(domain.DomainKey))
+
+	return nil
+}
+
+func (model *Model) refuseDeleteSharedRulesInUse(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	logger.Debug().Msg("creating scratch shared rules with a route")
+	sharedRules, err := createSharedRules(ctx, client, model.Zone, model.names.sharedRules+"-in-use")
+	if err != nil {
+		return errors.Wrap(err, "createSharedRules")
+	}
+	model.cleanup.registerObject(client.sharedRules().resourceClient, string(sharedRules.SharedRulesKey))
+	route, err := createRoute(ctx, client, model.Zone, model.Domain, sharedRules, model.names.routePath+"/in-use")
+	if err != nil {
+		return errors.Wrap(err, "createRoute")
+	}
+	model.cleanup.registerObject(client.routes().resourceClient, string(route.RouteKey))
+
+	logger.Debug().Msg("deleting the shared rules while the route refers to them")
+	if err = expectInUse("deleteSharedRules", deleteSharedRules(ctx, client, sharedRules)); err != nil {
+		return err
+	}
+
+	logger.Debug().Msg("deleting the route, then the shared rules")
+	if err = deleteRoute(ctx, client, route); err != nil {
+		return errors.Wrap(err, "deleteRoute")
+	}
+	model.cleanup.forgetObject(routeResource, string(route.RouteKey))
+	if err = deleteSharedRules(ctx, client, sharedRules); err != nil {
+		return errors.Wrap(err, "deleteSharedRules after deleting route")
+	}
+	model.cleanup.forgetObject(sharedRulesResource, string(sharedRules.SharedRulesKey))
+
+	return nil
+}
+
+func (model *Model) refuseRouteWithMissingSharedRules(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	missing := api.SharedRules{SharedRulesKey: api.SharedRulesKey(model.names.sharedRules + "-missing")}
+	route, err := createRoute(ctx, client, model.Zone, model.Domain, missing, model.names.routePath+"/missing-shared-rules")
+	if err == nil {
+		model.cleanup.registerObject(client.routes().resourceClient, string(route.RouteKey))
+	}
+	return expectMissingReference("createRoute", err)
+}
+
+func (model *Model) refuseRouteWithMissingDomain(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	missing := api.Domain{DomainKey: api.DomainKey(model.names.domain + "-missing")}
+	route, err := createRoute(ctx, client, model.Zone, missing, model.SharedRules, model.names.routePath+"/missing-domain")
+	if err == nil {
+		model.cleanup.registerObject(client.routes().resourceClient, string(route.RouteKey))
+	}
+	return expectMissingReference("createRoute", err)
+}
@@ -1,14 +1,7 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
-
-	"github.com/pkg/errors"
+	"context"
 
 	api "github.com/deciphernow/gm-control-api/api"
 	service "github.com/deciphernow/gm-control-api/api/service"
@@ -20,157 +13,77 @@ const listenerPort = 3333
 
 var listenerProtocol api.ListenerProtocol = api.HttpListenerProtocol
 
-func createListener(
-	client *clientStruct,
-	zone api.Zone,
-	domain api.Domain,
-) (api.Listener, error) {
-	var reqListener api.Listener
-	var respListener api.Listener
-	var buffer bytes.Buffer
-	var request http.Request
-
-	reqListener.ZoneKey = zone.ZoneKey
-	reqListener.Name = listenerName
-	reqListener.IP = listenerIP
-	reqListener.Port = listenerPort
-	reqListener.Protocol = listenerProtocol
-	reqListener.DomainKeys = []api.DomainKey{domain.DomainKey}
-
-	if err := json.NewEncoder(&buffer).Encode(&reqListener); err != nil {
-		return api.Listener{}, errors.Wrap(err, "Encode")
-	}
-
-	request.Method = "POST"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   "/v1.0/listener",
-	}
-	request.Body = ioutil.NopCloser(&buffer)
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Listener{}, errors.Wrap(err, "doHTTP")
-	}
-
-	if err = json.Unmarshal(rawMessage, &respListener); err != nil {
-		return api.Listener{}, errors.Wrap(err, "Unmarshal")
-	}
-
-	return respListener, nil
+var listenerResource = resource{
+	segment:   "listener",
+	newObject: func() interface{} { return &api.Listener{} },
+	identify: func(object interface{}) (string, string) {
+		listener := object.(*api.Listener)
+		return string(listener.ListenerKey), listener.Checksum.Checksum
+	},
+	equal: func(a interface{}, b interface{}) bool {
+		return a.(*api.Listener).Equals(*b.(*api.Listener))
+	},
 }
 
-func queryListenerByName(client *clientStruct) (api.Listeners, error) {
-	var buffer bytes.Buffer
-	var request http.Request
-
-	nameFilter := service.ListenerFilter{Name: listenerName}
-	listenerFilters := []service.ListenerFilter{nameFilter}
-
-	if err := json.NewEncoder(&buffer).Encode(listenerFilters); err != nil {
-		return nil, errors.Wrap(err, "Encode filters")
-	}
-
-	request.Method = "GET"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   "/v1.0/listener",
-	}
-
-	values := url.Values{}
-	values.Add("filters", buffer.String())
-	request.URL.RawQuery = values.Encode()
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return nil, errors.Wrap(err, "doHTTP")
-	}
-
-	var listeners []api.Listener
-
-	if err = json.Unmarshal(rawMessage, &listeners); err != nil {
-		return nil, errors.Wrap(err, "Unmarshal")
-	}
+type listenerClient struct{ resourceClient }
 
-	return listeners, nil
+func (client *clientStruct) listeners() listenerClient {
+	return listenerClient{resourceClient{client: client, resource: listenerResource}}
 }
 
-func getListenerByKey(client *clientStruct, listenerKey api.ListenerKey) (api.Listener, error) {
-	var respListener api.Listener
-	var request http.Request
-
-	request.Method = "GET"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   fmt.Sprintf("/v1.0/listener/%s", url.PathEscape(string(listenerKey))),
-	}
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Listener{}, errors.Wrap(err, "doHTTP")
-	}
+func (c listenerClient) Create(ctx context.Context, listener api.Listener) (result api.Listener, err error) {
+	err = c.create(ctx, &listener, &result)
+	return result, err
+}
 
-	if err = json.Unmarshal(rawMessage, &respListener); err != nil {
-		return api.Listener{}, errors.Wrap(err, "Unmarshal")
-	}
+func (c listenerClient) List(ctx context.Context, filters ...service.ListenerFilter) (result api.Listeners, err error) {
+	err = c.list(ctx, filters, &result)
+	return result, err
+}
 
-	return respListener, nil
+func (c listenerClient) Get(ctx context.Context, listenerKey api.ListenerKey) (result api.Listener, err error) {
+	err = c.get(ctx, string(listenerKey), &result)
+	return result, err
 }
 
-func editListener(client *clientStruct, listener api.Listener) (api.Listener, error) {
-	var respListener api.Listener
-	var buffer bytes.Buffer
-	var request http.Request
-
-	if err := json.NewEncoder(&buffer).Encode(&listener); err != nil {
-		return api.Listener{}, errors.Wrap(err, "Encode")
-	}
-
-	request.Method = "PUT"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   listenerKeyPath(listener),
-	}
-	request.Body = ioutil.NopCloser(&buffer)
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Listener{}, errors.Wrap(err, "doHTTP")
-	}
-
-	if err = json.Unmarshal(rawMessage, &respListener); err != nil {
-		return api.Listener{}, errors.Wrap(err, "Unmarshal")
-	}
-
-	return respListener, nil
+func (c listenerClient) Update(ctx context.Context, listener api.Listener) (result api.Listener, err error) {
+	err = c.update(ctx, &listener, &result)
+	return result, err
 }
 
-func deleteListener(client *clientStruct, listener api.Listener) error {
-	var request http.Request
+func (c listenerClient) Delete(ctx context.Context, listener api.Listener) error {
+	return c.remove(ctx, &listener)
+}
 
-	request.Method = "DELETE"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   listenerKeyPath(listener),
-	}
+func createListener(
+	ctx context.Context,
+	client *clientStruct,
+	zone api.Zone,
+	domain api.Domain,
+	name string,
+) (api.Listener, error) {
+	return client.listeners().Create(ctx, api.Listener{
+		ZoneKey:    zone.ZoneKey,
+		Name:       name,
+		IP:         listenerIP,
+		Port:       listenerPort,
+		Protocol:   listenerProtocol,
+		DomainKeys: []api.DomainKey{domain.DomainKey},
+	})
+}
 
-	values := url.Values{}
-	values.Add("checksum", listener.Checksum.Checksum)
-	request.URL.RawQuery = values.Encode()
+func queryListenerByName(ctx context.Context, client *clientStruct, name string) (api.Listeners, error) {
+	return client.listeners().List(ctx, service.ListenerFilter{Name: name})
+}
 
-	_, err := client.doHTTP(&request)
-	if err != nil {
-		return errors.Wrap(err, "doHTTP")
-	}
+func getListenerByKey(ctx context.Context, client *clientStruct, listenerKey api.ListenerKey) (api.Listener, error) {
+	return client.listeners().Get(ctx, listenerKey)
+}
 
-	return nil
+func editListener(ctx context.Context, client *clientStruct, listener api.Listener) (api.Listener, error) {
+	return client.listeners().Update(ctx, listener)
 }
 
-func listenerKeyPath(listener api.Listener) string {
-	return fmt.Sprintf("/v1.0/listener/%s", url.PathEscape(string(listener.ListenerKey)))
+func deleteListener(ctx context.Context, client *clientStruct, listener api.Listener) error {
+	return client.listeners().Delete(ctx, listener)
 }
@@ -0,0 +1,732 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/spf13/viper"
+
+	api "github.com/deciphernow/gm-control-api/api"
+)
+
+// machineOpKind is one kind of operation the state machine makes.
+type machineOpKind string
+
+const (
+	machineCreate         machineOpKind = "create"
+	machineEdit           machineOpKind = "edit"
+	machineDelete         machineOpKind = "delete"
+	machinePutInstance    machineOpKind = "put instance"
+	machineDeleteInstance machineOpKind = "delete instance"
+)
+
+// machineVariant makes an operation deliberately invalid.
+type machineVariant string
+
+const (
+	machineValid    machineVariant = ""
+	machineUnnamed  machineVariant = "unnamed"
+	machineDangling machineVariant = "dangling"
+	machineStale    machineVariant = "stale"
+)
+
+// machineOp is one generated operation. Objects are named by the logical
+// id their create was given rather than by key, so that a sequence can be
+// replayed, and shrunk, against a fresh zone. An operation naming an id
+// that was never created in the replay is left out.
+type machineOp struct {
+	kind    machineOpKind
+	segment string
+	target  int
+	deps    []int
+	variant machineVariant
+	value   int
+}
+
+func (op machineOp) String() string {
+	switch op.kind {
+	case machineCreate:
+		text := fmt.Sprintf("create %s #%d", op.segment, op.target)
+		var deps []string
+		for i, id := range op.deps {
+			deps = append(deps, fmt.Sprintf("%s #%d", machineKinds[op.segment].deps[i], id))
+		}
+		if len(deps) > 0 {
+			text += " (" + strings.Join(deps, ", ") + ")"
+		}
+		switch op.variant {
+		case machineUnnamed:
+			text += " without a name"
+		case machineDangling:
+			text += " with its last reference replaced by a missing key"
+		}
+		return text
+	case machineEdit:
+		text := fmt.Sprintf("edit %s #%d to %d", op.segment, op.target, op.value)
+		if op.variant == machineStale {
+			text += " with a stale checksum"
+		}
+		return text
+	case machinePutInstance, machineDeleteInstance:
+		return fmt.Sprintf("%s %s on cluster #%d", op.kind, machineInstance(op.value).Key(), op.target)
+	}
+	return fmt.Sprintf("%s %s #%d", op.kind, op.segment, op.target)
+}
+
+// machineKind is how the state machine creates and edits one object
+// type. deps are the types of the objects a create refers to.
+type machineKind struct {
+	resource resource
+	keyField string
+	deps     []string
+	create   func(ctx context.Context, client *clientStruct, zone api.Zone, name string, deps []interface{}) (interface{}, error)
+	edit     func(object interface{}, value int)
+}
+
+// machineSegments lists the types the state machine uses in dependency
+// order.
+var machineSegments = []string{"cluster", "domain", "shared_rules", "listener", "route", "proxy"}
+
+var machineKinds = map[string]machineKind{
+	"cluster": {
+		resource: clusterResource,
+		keyField: "cluster_key",
+		create: func(ctx context.Context, client *clientStruct, zone api.Zone, name string, deps []interface{}) (interface{}, error) {
+			cluster, err := createCluster(ctx, client, zone, name)
+			return &cluster, err
+		},
+		edit: func(object interface{}, value int) {
+			object.(*api.Cluster).CircuitBreakers = &api.CircuitBreakers{MaxConnections: &value}
+		},
+	},
+	"domain": {
+		resource: domainResource,
+		keyField: "domain_key",
+		create: func(ctx context.Context, client *clientStruct, zone api.Zone, name string, deps []interface{}) (interface{}, error) {
+			domain, err := createDomain(ctx, client, zone, name)
+			return &domain, err
+		},
+		edit: func(object interface{}, value int) {
+			object.(*api.Domain).Port = 1000 + value
+		},
+	},
+	"shared_rules": {
+		resource: sharedRulesResource,
+		keyField: "shared_rules_key",
+		create: func(ctx context.Context, client *clientStruct, zone api.Zone, name string, deps []interface{}) (interface{}, error) {
+			sharedRules, err := createSharedRules(ctx, client, zone, name)
+			return &sharedRules, err
+		},
+		edit: func(object interface{}, value int) {
+			object.(*api.SharedRules).Properties = api.Metadata{{Key: "machine", Value: fmt.Sprint(value)}}
+		},
+	},
+	"listener": {
+		resource: listenerResource,
+		keyField: "listener_key",
+		deps:     []string{"domain"},
+		create: func(ctx context.Context, client *clientStruct, zone api.Zone, name string, deps []interface{}) (interface{}, error) {
+			listener, err := createListener(ctx, client, zone, *deps[0].(*api.Domain), name)
+			return &listener, err
+		},
+		edit: func(object interface{}, value int) {
+			object.(*api.Listener).Port = 2000 + value
+		},
+	},
+	"route": {
+		resource: routeResource,
+		keyField: "route_key",
+		deps:     []string{"domain", "shared_rules"},
+		create: func(ctx context.Context, client *clientStruct, zone api.Zone, name string, deps []interface{}) (interface{}, error) {
+			route, err := createRoute(ctx, client, zone, *deps[0].(*api.Domain), *deps[1].(*api.SharedRules), name)
+			return &route, err
+		},
+		edit: func(object interface{}, value int) {
+			object.(*api.Route).PrefixRewrite = fmt.Sprintf("/rewrite/%d", value)
+		},
+	},
+	"proxy": {
+		resource: proxyResource,
+		keyField: "proxy_key",
+		deps:     []string{"domain", "listener"},
+		create: func(ctx context.Context, client *clientStruct, zone api.Zone, name string, deps []interface{}) (interface{}, error) {
+			proxy, err := createProxy(ctx, client, zone, *deps[0].(*api.Domain), *deps[1].(*api.Listener), name)
+			return &proxy, err
+		},
+		edit: func(object interface{}, value int) {
+			object.(*api.Proxy).ActiveFilters = []api.GMProxyFilter{api.GMProxyFilter(fmt.Sprintf("filter-%d", value))}
+		},
+	},
+}
+
+// machineInstance is the instance an instance operation with value uses.
+// Values share a handful of instances, so that puts replace and deletes
+// find them.
+func machineInstance(value int) api.Instance {
+	return api.Instance{Host: fmt.Sprintf("10.1.0.%d", value%4+1), Port: 9000 + value%4}
+}
+
+// generateMachineOps returns steps operations chosen by rng. Most are
+// valid given the objects the earlier operations create, but some refer
+// to deleted objects, omit a name, refer to a missing key or carry a
+// stale checksum.
+func generateMachineOps(rng *rand.Rand, steps int) []machineOp {
+	var ops []machineOp
+	ids := make(map[string][]int)
+	next := 0
+
+	pick := func(segment string) (int, bool) {
+		if len(ids[segment]) == 0 {
+			return 0, false
+		}
+		return ids[segment][rng.Intn(len(ids[segment]))], true
+	}
+
+	for len(ops) < steps {
+		segment := machineSegments[rng.Intn(len(machineSegments))]
+		op := machineOp{segment: segment, value: rng.Intn(100)}
+		roll := rng.Intn(100)
+
+		switch {
+		case roll < 40:
+			op.kind = machineCreate
+			ok := true
+			for _, dep := range machineKinds[segment].deps {
+				id, found := pick(dep)
+				ok = ok && found
+				op.deps = append(op.deps, id)
+			}
+			if !ok {
+				continue
+			}
+			switch variant := rng.Intn(10); {
+			case variant == 0:
+				op.variant = machineUnnamed
+			case variant == 1 && len(op.deps) > 0:
+				op.variant = machineDangling
+			default:
+				ids[segment] = append(ids[segment], next)
+			}
+			op.target = next
+			next++
+
+		case roll < 80:
+			op.kind = machineEdit
+			if roll >= 65 {
+				op.kind = machineDelete
+			}
+			id, ok := pick(segment)
+			if !ok {
+				continue
+			}
+			op.target = id
+			if op.kind == machineEdit && rng.Intn(6) == 0 {
+				op.variant = machineStale
+			}
+
+		default:
+			op.kind = machinePutInstance
+			if roll >= 92 {
+				op.kind = machineDeleteInstance
+			}
+			op.segment = "cluster"
+			id, ok := pick("cluster")
+			if !ok {
+				continue
+			}
+			op.target = id
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops
+}
+
+// machineObject is the reference model's view of one object: its value
+// as the API last returned it and the ids of the objects it refers to.
+type machineObject struct {
+	segment          string
+	value            interface{}
+	deps             []int
+	deleted          bool
+	previousChecksum string
+}
+
+// machineFailure is the first operation whose outcome differed from the
+// model's prediction. step is len(ops) when the final check of the zone
+// failed.
+type machineFailure struct {
+	step int
+	op   machineOp
+	err  error
+}
+
+// machine runs generated sequences against the API, each in a zone of its
+// own, and shrinks the first failing one.
+type machine struct {
+	logger          zerolog.Logger
+	client          *clientStruct
+	names           namer
+	runs            int
+	cleanupFailures int
+}
+
+// machineRun is one sequence being replayed and the reference model it
+// is checked against.
+type machineRun struct {
+	*machine
+	run     int
+	zone    api.Zone
+	objects map[int]*machineObject
+}
+
+// runSequence replays ops against a fresh zone, then checks that the
+// zone holds exactly the objects the model does, and deletes it within
+// CLEANUP_TIMEOUT even if ctx has ended. The error is for a failure to set
+// up or ctx ending, not a difference from the model.
+func (m *machine) runSequence(ctx context.Context, ops []machineOp) (*machineFailure, error) {
+	m.runs++
+	run := machineRun{machine: m, run: m.runs, objects: make(map[int]*machineObject)}
+
+	var err error
+	run.zone, err = createZone(ctx, m.client, m.names.name(fmt.Sprintf("machine-%d", run.run)))
+	if err != nil {
+		return nil, errors.Wrap(err, "createZone")
+	}
+	defer func() {
+		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), viper.GetDuration("cleanup_timeout"))
+		defer cleanupCancel()
+		m.cleanupFailures += run.cleanup(cleanupCtx)
+	}()
+
+	for i, op := range ops {
+		err = run.execute(ctx, op)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return &machineFailure{step: i, op: op, err: err}, nil
+		}
+	}
+	err = run.checkZone(ctx)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return &machineFailure{step: len(ops), err: err}, nil
+	}
+
+	return nil, nil
+}
+
+// shrink removes ever smaller runs of operations from a failing sequence
+// for as long as it still fails, making at most attempts replays.
+func (m *machine) shrink(ctx context.Context, ops []machineOp, failure *machineFailure, attempts int) ([]machineOp, *machineFailure) {
+	return shrinkMachineOps(ops, failure, attempts, func(candidate []machineOp) (*machineFailure, error) {
+		return m.runSequence(ctx, candidate)
+	})
+}
+
+// shrinkMachineOps is shrink with replay making each attempt. Operations
+// after the failing one are dropped first, since they cannot matter.
+func shrinkMachineOps(
+	ops []machineOp,
+	failure *machineFailure,
+	attempts int,
+	replay func(ops []machineOp) (*machineFailure, error),
+) ([]machineOp, *machineFailure) {
+	if failure.step < len(ops) {
+		ops = ops[:failure.step+1]
+	}
+
+	for chunk := len(ops) / 2; chunk >= 1 && attempts > 0; chunk /= 2 {
+		for start := 0; start+chunk <= len(ops) && attempts > 0; {
+			candidate := append(append([]machineOp(nil), ops[:start]...), ops[start+chunk:]...)
+			attempts--
+			shrunk, err := replay(candidate)
+			if err != nil || shrunk == nil {
+				start += chunk
+				continue
+			}
+			ops, failure = candidate, shrunk
+			if failure.step < len(ops) {
+				ops = ops[:failure.step+1]
+			}
+		}
+	}
+
+	return ops, failure
+}
+
+func (run *machineRun) objectName(op machineOp) string {
+	if op.segment == "route" {
+		return run.names.path(fmt.Sprintf("/machine/%d/%d", run.run, op.target))
+	}
+	return run.names.name(fmt.Sprintf("machine-%d-%s-%d", run.run, op.segment, op.target))
+}
+
+// execute makes one operation, checks its outcome against the model's
+// prediction and the object read back afterwards against the model.
+func (run *machineRun) execute(ctx context.Context, op machineOp) error {
+	if op.kind == machineCreate {
+		return run.create(ctx, op)
+	}
+
+	object, ok := run.objects[op.target]
+	if !ok || object.segment != op.segment {
+		return nil
+	}
+	kind := machineKinds[op.segment]
+	rc := resourceClient{client: run.client, resource: kind.resource}
+
+	var result interface{}
+	var expected interface{}
+	var err error
+
+	switch op.kind {
+	case machineEdit:
+		if expected, err = copyMachineValue(kind.resource, object.value); err != nil {
+			return err
+		}
+		kind.edit(expected, op.value)
+		sent := expected
+		if op.variant == machineStale {
+			if sent, err = withMachineChecksum(kind.resource, expected, run.staleChecksum(object)); err != nil {
+				return err
+			}
+		}
+		result = kind.resource.newObject()
+		err = rc.update(ctx, sent, result)
+		switch {
+		case object.deleted:
+			return expectRefusal(err, IsNotFound, "not found")
+		case op.variant == machineStale:
+			return expectRefusal(err, IsConflict, "a checksum conflict")
+		}
+
+	case machineDelete:
+		err = rc.remove(ctx, object.value)
+		switch {
+		case object.deleted:
+			return expectRefusal(err, IsNotFound, "not found")
+		case run.inUse(op.target):
+			return expectRefusal(err, IsInUse, "refused as in use")
+		}
+		if err != nil {
+			return errors.Wrap(err, "delete")
+		}
+		object.deleted = true
+		return run.checkObject(ctx, object)
+
+	case machinePutInstance, machineDeleteInstance:
+		cluster := *object.value.(*api.Cluster)
+		instance := machineInstance(op.value)
+		var instances []api.Instance
+		present := false
+		for _, existing := range cluster.Instances {
+			if existing.Key() == instance.Key() {
+				present = true
+				continue
+			}
+			instances = append(instances, existing)
+		}
+		if op.kind == machinePutInstance {
+			instances = append(instances, instance)
+		}
+		wanted := cluster
+		wanted.Instances = instances
+		expected = &wanted
+
+		var updated api.Cluster
+		if op.kind == machinePutInstance {
+			updated, err = putClusterInstance(ctx, run.client, cluster, instance)
+		} else {
+			updated, err = deleteClusterInstance(ctx, run.client, cluster, instance)
+		}
+		result = &updated
+		switch {
+		case object.deleted:
+			return expectRefusal(err, IsNotFound, "not found")
+		case op.kind == machineDeleteInstance && !present:
+			return expectRefusal(err, IsNotFound, "not found")
+		}
+	}
+
+	if err != nil {
+		return errors.Wrap(err, string(op.kind))
+	}
+	_, checksum := kind.resource.identify(result)
+	if expected, err = withMachineChecksum(kind.resource, expected, checksum); err != nil {
+		return err
+	}
+	if !machineEqual(kind.resource, result, expected) {
+		return errors.Errorf("%s returned %+v, want %+v", op.kind, result, expected)
+	}
+
+	_, object.previousChecksum = kind.resource.identify(object.value)
+	object.value = result
+	return run.checkObject(ctx, object)
+}
+
+func (run *machineRun) create(ctx context.Context, op machineOp) error {
+	if _, exists := run.objects[op.target]; exists {
+		return nil
+	}
+	kind := machineKinds[op.segment]
+
+	deps := make([]interface{}, len(op.deps))
+	dangling := op.variant == machineDangling
+	for i, id := range op.deps {
+		dep, ok := run.objects[id]
+		if !ok || dep.segment != kind.deps[i] {
+			return nil
+		}
+		deps[i] = dep.value
+		dangling = dangling || dep.deleted
+	}
+
+	name := run.objectName(op)
+	switch op.variant {
+	case machineUnnamed:
+		name = ""
+	case machineDangling:
+		last := len(deps) - 1
+		missing := fmt.Sprintf("missing-%d-%d", run.run, op.target)
+		var err error
+		if deps[last], err = withMachineKey(machineKinds[kind.deps[last]], deps[last], missing); err != nil {
+			return err
+		}
+	}
+
+	created, err := kind.create(ctx, run.client, run.zone, name, deps)
+	if err == nil {
+		run.objects[op.target] = &machineObject{segment: op.segment, value: created, deps: op.deps}
+	}
+	switch {
+	case op.variant == machineUnnamed:
+		return expectRefusal(err, IsValidation, "a validation error")
+	case dangling:
+		return expectRefusal(err, IsMissingReference, "a missing reference error")
+	}
+	if err != nil {
+		return errors.Wrap(err, "create")
+	}
+
+	fields, err := toFields(created)
+	if err != nil {
+		return err
+	}
+	nameField := "name"
+	if op.segment == "route" {
+		nameField = "path"
+	}
+	if fields[nameField] != name || fields["zone_key"] != string(run.zone.ZoneKey) {
+		return errors.Errorf("create returned %+v, want %s %q in zone %s", created, nameField, name, run.zone.ZoneKey)
+	}
+
+	return run.checkObject(ctx, run.objects[op.target])
+}
+
+// staleChecksum is a checksum the object had before its last change, or
+// one it never had.
+func (run *machineRun) staleChecksum(object *machineObject) string {
+	_, current := machineKinds[object.segment].resource.identify(object.value)
+	if object.previousChecksum != "" && object.previousChecksum != current {
+		return object.previousChecksum
+	}
+	return "stale-" + current
+}
+
+// inUse reports whether an object the model holds refers to id.
+func (run *machineRun) inUse(id int) bool {
+	for _, object := range run.objects {
+		if object.deleted {
+			continue
+		}
+		for _, dep := range object.deps {
+			if dep == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkObject reads object back and compares it with the model.
+func (run *machineRun) checkObject(ctx context.Context, object *machineObject) error {
+	r := machineKinds[object.segment].resource
+	key, _ := r.identify(object.value)
+	got := r.newObject()
+	err := resourceClient{client: run.client, resource: r}.get(ctx, key, got)
+	if object.deleted {
+		if !IsNotFound(err) {
+			return errors.Errorf("get %s %s after delete returned %v, want not found", object.segment, key, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "get %s %s", object.segment, key)
+	}
+	if !machineEqual(r, got, object.value) {
+		return errors.Errorf("get %s %s returned %+v, want %+v", object.segment, key, got, object.value)
+	}
+	return nil
+}
+
+// checkZone lists the zone's objects of every type and compares their
+// keys with the objects the model holds.
+func (run *machineRun) checkZone(ctx context.Context) error {
+	for _, segment := range machineSegments {
+		r := machineKinds[segment].resource
+		listed, err := listZoneObjects(ctx, run.client, r, run.zone.ZoneKey)
+		if err != nil {
+			return errors.Wrapf(err, "list %s", segment)
+		}
+		var got, want []string
+		for _, value := range listed {
+			key, _ := r.identify(value)
+			got = append(got, key)
+		}
+		for _, object := range run.objects {
+			if object.segment == segment && !object.deleted {
+				key, _ := r.identify(object.value)
+				want = append(want, key)
+			}
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			return errors.Errorf("zone lists %s %v, want %v", segment, got, want)
+		}
+	}
+	return nil
+}
+
+// cleanup deletes the objects the run left and its zone, dependents
+// first, and returns the number of failures.
+func (run *machineRun) cleanup(ctx context.Context) int {
+	failures := 0
+	for i := len(machineSegments) - 1; i >= 0; i-- {
+		r := machineKinds[machineSegments[i]].resource
+		rc := resourceClient{client: run.client, resource: r}
+		listed, err := listZoneObjects(ctx, run.client, r, run.zone.ZoneKey)
+		if err != nil {
+			run.logger.Error().Err(err).Str("type", r.segment).Msg("cleanup list failed")
+			failures++
+			continue
+		}
+		for _, value := range listed {
+			if err = rc.remove(ctx, value); err != nil && !IsNotFound(err) {
+				key, _ := r.identify(value)
+				run.logger.Error().Err(err).Str("type", r.segment).Str("key", key).Msg("cleanup failed")
+				failures++
+			}
+		}
+	}
+
+	zone, err := getZoneByKey(ctx, run.client, run.zone.ZoneKey)
+	if err == nil {
+		err = deleteZone(ctx, run.client, zone)
+	}
+	if err != nil && !IsNotFound(err) {
+		run.logger.Error().Err(err).Str("zone", run.zone.Name).Msg("cleanup failed")
+		failures++
+	}
+	return failures
+}
+
+// expectRefusal checks that err is the refusal the model predicts.
+func expectRefusal(err error, is func(error) bool, want string) error {
+	if err == nil {
+		return errors.Errorf("succeeded, want %s", want)
+	}
+	if !is(err) {
+		return errors.Wrapf(err, "want %s, got", want)
+	}
+	return nil
+}
+
+func copyMachineValue(r resource, value interface{}) (interface{}, error) {
+	fields, err := toFields(value)
+	if err != nil {
+		return nil, err
+	}
+	return fromFields(r, fields)
+}
+
+func withMachineChecksum(r resource, value interface{}, checksum string) (interface{}, error) {
+	fields, err := toFields(value)
+	if err != nil {
+		return nil, err
+	}
+	fields["checksum"] = checksum
+	return fromFields(r, fields)
+}
+
+func withMachineKey(kind machineKind, value interface{}, key string) (interface{}, error) {
+	fields, err := toFields(value)
+	if err != nil {
+		return nil, err
+	}
+	fields[kind.keyField] = key
+	return fromFields(kind.resource, fields)
+}
+
+// machineEqual compares two values with the api type's Equals, ignoring
+// the order of cluster instances, which the API does not promise. Clusters
+// are compared as sorted copies, leaving a and b as they are.
+func machineEqual(r resource, a interface{}, b interface{}) bool {
+	values := []interface{}{a, b}
+	for i, value := range values {
+		if cluster, ok := value.(*api.Cluster); ok {
+			instances := append(api.Instances(nil), cluster.Instances...)
+			sort.Slice(instances, func(i, j int) bool { return instances[i].Key() < instances[j].Key() })
+			if len(instances) == 0 {
+				instances = nil
+			}
+			sorted := *cluster
+			sorted.Instances = instances
+			values[i] = &sorted
+		}
+	}
+	return r.equal(values[0], values[1])
+}
+
+// writeMachineFailure prints a failing sequence as a numbered list of
+// operations with the failure and how to replay it. The replay command
+// regenerates all generated operations from the seed rather than running
+// the shrunk list; shrinking is deterministic, so a failure that is too
+// shrinks to the same list again.
+func writeMachineFailure(w io.Writer, seed int64, steps int, generated int, ops []machineOp, failure *machineFailure) error {
+	heading := fmt.Sprintf("sequence with seed %d failed; reproducer of %d operations:", seed, len(ops))
+	if len(ops) < generated {
+		heading = fmt.Sprintf("sequence with seed %d failed; reproducer of %d operations, shrunk from %d:",
+			seed, len(ops), generated)
+	}
+	lines := []string{heading}
+	for i, op := range ops {
+		lines = append(lines, fmt.Sprintf("  %d. %s", i+1, op))
+	}
+	if failure.step < len(ops) {
+		lines = append(lines, fmt.Sprintf("operation %d failed: %s", failure.step+1, failure.err))
+	} else {
+		lines = append(lines, fmt.Sprintf("final check failed: %s", failure.err))
+	}
+	replay := fmt.Sprintf("integration statemachine --seed %d --sequences 1 --steps %d", seed, steps)
+	if len(ops) < generated {
+		lines = append(lines, fmt.Sprintf("replay the unshrunk %d operations, which shrink to the list above again, with: %s",
+			generated, replay))
+	} else {
+		lines = append(lines, "replay with: "+replay)
+	}
+
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestGenerateMachineOps(t *testing.T) {
+	tests := []struct {
+		seed  int64
+		steps int
+	}{
+		{seed: 1, steps: 1},
+		{seed: 2, steps: 40},
+		{seed: 3, steps: 200},
+		{seed: 4, steps: 1000},
+	}
+
+	for _, test := range tests {
+		ops := generateMachineOps(rand.New(rand.NewSource(test.seed)), test.steps)
+		if len(ops) != test.steps {
+			t.Fatalf("seed %d: %d ops, want %d", test.seed, len(ops), test.steps)
+		}
+		again := generateMachineOps(rand.New(rand.NewSource(test.seed)), test.steps)
+		if !reflect.DeepEqual(ops, again) {
+			t.Errorf("seed %d: ops differ between two generations", test.seed)
+		}
+
+		// every id an operation names was given to an earlier valid create
+		// of the right type
+		created := make(map[int]string)
+		for i, op := range ops {
+			switch op.kind {
+			case machineCreate:
+				deps := machineKinds[op.segment].deps
+				if len(op.deps) != len(deps) {
+					t.Fatalf("seed %d op %d %s: %d deps, want %d", test.seed, i, op, len(op.deps), len(deps))
+				}
+				for j, id := range op.deps {
+					if created[id] != deps[j] {
+						t.Errorf("seed %d op %d %s: dep #%d is not a %s", test.seed, i, op, id, deps[j])
+					}
+				}
+				if _, exists := created[op.target]; exists {
+					t.Errorf("seed %d op %d %s: id reused", test.seed, i, op)
+				}
+				if op.variant == machineDangling && len(op.deps) == 0 {
+					t.Errorf("seed %d op %d %s: dangling without references", test.seed, i, op)
+				}
+				if op.variant == machineValid {
+					created[op.target] = op.segment
+				}
+			case machineEdit, machineDelete, machinePutInstance, machineDeleteInstance:
+				if created[op.target] != op.segment {
+					t.Errorf("seed %d op %d %s: #%d is not a %s", test.seed, i, op, op.target, op.segment)
+				}
+				if op.kind != machineEdit && op.variant != machineValid {
+					t.Errorf("seed %d op %d %s: variant %q", test.seed, i, op, op.variant)
+				}
+			default:
+				t.Errorf("seed %d op %d: unknown kind %q", test.seed, i, op.kind)
+			}
+		}
+	}
+}
+
+// failWhen returns a replay that fails at the last of the targets in
+// needed once all of them are in a sequence, or at the end of the
+// sequence with final.
+func failWhen(final bool, needed ...int) func(ops []machineOp) (*machineFailure, error) {
+	return func(ops []machineOp) (*machineFailure, error) {
+		remaining := make(map[int]bool)
+		for _, target := range needed {
+			remaining[target] = true
+		}
+		for i, op := range ops {
+			delete(remaining, op.target)
+			if len(remaining) == 0 && !final {
+				return &machineFailure{step: i, op: op}, nil
+			}
+		}
+		if len(remaining) == 0 {
+			return &machineFailure{step: len(ops)}, nil
+		}
+		return nil, nil
+	}
+}
+
+func machineTargets(ops []machineOp) []int {
+	targets := []int{}
+	for _, op := range ops {
+		targets = append(targets, op.target)
+	}
+	return targets
+}
+
+func TestShrinkMachineOps(t *testing.T) {
+	tests := []struct {
+		name   string
+		replay func(ops []machineOp) (*machineFailure, error)
+		// failure is the full sequence's, when replay does not give it
+		failure  *machineFailure
+		attempts int
+		want     []int
+		wantStep int
+	}{
+		{
+			name:     "one operation",
+			replay:   failWhen(false, 5),
+			attempts: 100,
+			want:     []int{5},
+			wantStep: 0,
+		},
+		{
+			name:     "two operations",
+			replay:   failWhen(false, 3, 7),
+			attempts: 100,
+			want:     []int{3, 7},
+			wantStep: 1,
+		},
+		{
+			name:     "final check",
+			replay:   failWhen(true, 2),
+			attempts: 100,
+			want:     []int{2},
+			wantStep: 1,
+		},
+		{
+			name:     "no attempts",
+			replay:   failWhen(false, 3, 7),
+			want:     []int{0, 1, 2, 3, 4, 5, 6, 7},
+			wantStep: 7,
+		},
+		{
+			name: "replay errors",
+			replay: func(ops []machineOp) (*machineFailure, error) {
+				return nil, errors.New("unreachable")
+			},
+			failure:  &machineFailure{step: 7},
+			attempts: 100,
+			want:     []int{0, 1, 2, 3, 4, 5, 6, 7},
+			wantStep: 7,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var ops []machineOp
+			for target := 0; target < 10; target++ {
+				ops = append(ops, machineOp{kind: machineCreate, segment: "cluster", target: target})
+			}
+			failure := test.failure
+			if failure == nil {
+				var err error
+				if failure, err = test.replay(ops); err != nil || failure == nil {
+					t.Fatalf("the full sequence does not fail")
+				}
+			}
+
+			replays := 0
+			replay := func(candidate []machineOp) (*machineFailure, error) {
+				replays++
+				return test.replay(candidate)
+			}
+			shrunk, shrunkFailure := shrinkMachineOps(ops, failure, test.attempts, replay)
+			if got := machineTargets(shrunk); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("shrunk to %v, want %v", got, test.want)
+			}
+			if shrunkFailure.step != test.wantStep {
+				t.Errorf("failure at step %d, want %d", shrunkFailure.step, test.wantStep)
+			}
+			if replays > test.attempts {
+				t.Errorf("%d replays, want at most %d", replays, test.attempts)
+			}
+		})
+	}
+}
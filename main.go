@@ -1,9 +1,14 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"math/rand"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/spf13/viper"
 )
@@ -11,10 +16,21 @@ import (
 func main() {
 	var err error
 
-	logger := zerolog.New(os.Stdout).
+	// registered first so that it runs after every other deferred call
+	exitCode := 0
+	defer func() { os.Exit(exitCode) }()
+
+	// commands keep stdout for their own output
+	logOutput := os.Stdout
+	if len(os.Args) > 1 {
+		logOutput = os.Stderr
+	}
+	logger := zerolog.New(logOutput).
 		With().Timestamp().Str("program", "integration").Logger()
 	logger.Info().Msg("program starts")
 
+	rand.Seed(time.Now().UnixNano())
+
 	viper.AutomaticEnv()
 	setEnvironmentDefaults()
 
@@ -23,46 +39,276 @@ func main() {
 		logger.Debug().Msg("log level set to debug")
 	}
 
-	model := Model{}
+	if len(os.Args) > 1 {
+		exitCode = runCommand(logger, os.Args[1], os.Args[2:])
+		return
+	}
+
+	runID := viper.GetString("run_id")
+	if runID == "" {
+		if runID, err = newRunID(); err != nil {
+			logger.Fatal().AnErr("newRunID", err).Msg("main")
+		}
+	}
+	runNamer := namer{
+		prefix: viper.GetString("name_prefix"),
+		runID:  runID,
+		suffix: viper.GetString("name_suffix"),
+	}
+	logger = logger.With().Str("run_id", runID).Logger()
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		viper.GetDuration("run_timeout"),
+	)
+	defer cancel()
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if sig, ok := <-interrupts; ok {
+			logger.Warn().Str("signal", sig.String()).Msg("interrupted: stopping run and cleaning up")
+			cancel()
+		}
+	}()
+
+	var registry cleanupRegistry
+	model := Model{
+		names:   newObjectNames(runNamer),
+		cleanup: &registry,
+	}
+	conflictNamer := runNamer
+	conflictNamer.prefix += "conflict-"
+	conflictModel := Model{
+		names:   newObjectNames(conflictNamer),
+		cleanup: &registry,
+	}
+	integrityNamer := runNamer
+	integrityNamer.prefix += "integrity-"
+	integrityModel := Model{
+		names:   newObjectNames(integrityNamer),
+		cleanup: &registry,
+	}
+	filterNamer := runNamer
+	filterNamer.prefix += "filter-"
+	seed := filterSeed{
+		names:   newObjectNames(filterNamer),
+		cleanup: &registry,
+	}
+
+	backupNamer := runNamer
+	backupNamer.prefix += "backup-"
+	trip := backupRoundTrip{
+		model: &Model{
+			names:   newObjectNames(backupNamer),
+			cleanup: &registry,
+		},
+		// the fake belongs to this run alone
+		allowRestore: viper.GetBool("allow_restore") || viper.GetBool("gm_control_api_fake"),
+	}
+	if viper.GetBool("allow_restore") && !viper.GetBool("gm_control_api_fake") {
+		logger.Warn().Str("address", viper.GetString("gm_control_api_address")).
+			Msg("ALLOW_RESTORE is set: the backup scenario will replace EVERY object on this control plane, " +
+				"including those of other users and runs")
+	}
+
+	stressNamer := runNamer
+	stressNamer.prefix += "stress-"
+	stress := stressTest{
+		model: &Model{
+			names:   newObjectNames(stressNamer),
+			cleanup: &registry,
+		},
+		writers:       viper.GetInt("stress_writers"),
+		edits:         viper.GetInt("stress_edits"),
+		instances:     viper.GetInt("stress_instances"),
+		registrations: viper.GetInt("stress_registrations"),
+		maxAttempts:   viper.GetInt("stress_max_attempts"),
+	}
+
+	client, closeClient, err := newClient(logger, viper.GetString("gm_control_api_address"))
+	if err != nil {
+		logger.Fatal().AnErr("newClient", err).Msg("main")
+	}
+	defer closeClient()
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, viper.GetDuration("wait_for_api"))
+	err = waitForAPI(waitCtx, logger, client)
+	waitCancel()
+	if err != nil {
+		logger.Fatal().AnErr("waitForAPI", err).Msg("main")
+	}
+
+	suite := runner{
+		logger:   logger,
+		client:   client,
+		tags:     parseTags(viper.GetString("tags")),
+		skipTags: parseTags(viper.GetString("skip_tags")),
+	}
+	started := time.Now()
+	results := suite.run(ctx, []scenario{
+		transportScenario(),
+		lifecycleScenario(&model),
+		checksumScenario(&conflictModel),
+		integrityScenario(&integrityModel),
+		filterScenario(&seed),
+		backupScenario(&trip),
+		stressScenario(&stress),
+		backendScenario(),
+	})
+
+	if err = writeSummary(os.Stdout, results); err != nil {
+		logger.Error().AnErr("writeSummary", err).Msg("main")
+	}
+	if _, failed, _ := countResults(results); failed > 0 {
+		exitCode = 1
+	}
+
+	cleanupCtx, cleanupCancel := context.WithTimeout(
+		context.Background(),
+		viper.GetDuration("cleanup_timeout"),
+	)
+	defer cleanupCancel()
+	go func() {
+		select {
+		case <-interrupts:
+			logger.Warn().Msg("interrupted again: abandoning cleanup")
+			cleanupCancel()
+		case <-cleanupCtx.Done():
+		}
+	}()
+
+	cleanupResults := registry.run(cleanupCtx, logger)
+	cleanupFailures, err := writeCleanupSummary(os.Stdout, cleanupResults)
+	if err != nil {
+		logger.Error().AnErr("writeCleanupSummary", err).Msg("main")
+	}
+	if cleanupFailures > 0 {
+		exitCode = 1
+	}
+
+	report := runReport{
+		runID:    runID,
+		started:  started,
+		duration: time.Since(started),
+		results:  results,
+		cleanup:  cleanupResults,
+	}
+	if path := viper.GetString("junit_report_path"); path != "" {
+		if err = writeJUnitReport(path, report); err != nil {
+			logger.Error().AnErr("writeJUnitReport", err).Msg("main")
+			exitCode = 1
+		}
+	}
+	if path := viper.GetString("json_report_path"); path != "" {
+		if err = writeJSONReport(path, report); err != nil {
+			logger.Error().AnErr("writeJSONReport", err).Msg("main")
+			exitCode = 1
+		}
+	}
+}
+
+// newClient builds a client for the gm-control-api at address from the
+// environment settings. When GM_CONTROL_API_FAKE is set it starts a fake
+// gm-control-api instead and ignores address. The caller calls the
+// returned function when done with the client.
+func newClient(logger zerolog.Logger, address string) (*clientStruct, func(), error) {
+	headers, err := parseHeaders(viper.GetString("gm_control_api_headers"))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parseHeaders")
+	}
 
 	client := clientStruct{
-		logger:        logger,
-		serverAddress: viper.GetString("gm_control_api_address"),
-	}
-
-	for i, f := range []func(zerolog.Logger, *clientStruct) error{
-		model.loadZone,
-		model.loadCluster,
-		model.loadDomain,
-		model.loadListener,
-		model.loadSharedRules,
-		model.loadRoute,
-		model.loadProxy,
-		model.getZone,
-		model.modifyCluster,
-		model.modifyDomain,
-		model.modifyListener,
-		model.modifySharedRules,
-		model.modifyRoute,
-		model.modifyProxy,
-		model.deleteProxy,
-		model.deleteSharedRules,
-		model.deleteRoute,
-		model.deleteListener,
-		model.deleteDomain,
-		model.deleteCluster,
-		model.deleteZone,
-	} {
-		if err =f(logger, &client); err != nil {
-			logger.Fatal().AnErr(fmt.Sprintf("%d", i), err).Msg("main")
-		}		
+		logger:         logger,
+		scheme:         "http",
+		serverAddress:  address,
+		requestTimeout: viper.GetDuration("request_timeout"),
+		retryPolicy: defaultRetryPolicy(
+			viper.GetInt("retry_max_attempts"),
+			viper.GetDuration("retry_base_delay"),
+			viper.GetDuration("retry_max_delay"),
+			viper.GetBool("retry_post"),
+		),
+		credentials: credentials{
+			required:      viper.GetBool("gm_control_api_auth_required"),
+			orgKeyHeader:  viper.GetString("gm_control_api_org_key_header"),
+			orgKey:        viper.GetString("gm_control_api_org_key"),
+			bearerToken:   viper.GetString("gm_control_api_bearer_token"),
+			basicUser:     viper.GetString("gm_control_api_basic_user"),
+			basicPassword: viper.GetString("gm_control_api_basic_password"),
+			headers:       headers,
+		},
 	}
 
+	if viper.GetBool("gm_control_api_fake") {
+		fake := startFakeServer(
+			logger,
+			viper.GetString("gm_control_api_org_key"),
+			client.credentials,
+			viper.GetString("gm_control_api_backend_path"),
+		)
+		client.serverAddress = fake.Listener.Addr().String()
+		return &client, fake.Close, nil
+	}
 
+	if viper.GetBool("gm_control_api_use_tls") {
+		if err = client.useTLS(environmentTLSSettings()); err != nil {
+			return nil, nil, errors.Wrap(err, "useTLS")
+		}
+	}
+
+	return &client, func() {}, nil
+}
+
+// environmentTLSSettings returns the TLS settings from the environment.
+func environmentTLSSettings() tlsSettings {
+	return tlsSettings{
+		caFile:             viper.GetString("gm_control_api_ca_file"),
+		certFile:           viper.GetString("gm_control_api_cert_file"),
+		keyFile:            viper.GetString("gm_control_api_key_file"),
+		serverName:         viper.GetString("gm_control_api_server_name"),
+		insecureSkipVerify: viper.GetBool("gm_control_api_insecure_skip_verify"),
+	}
 }
 
 func setEnvironmentDefaults() {
 	viper.SetDefault("gm_control_api_address", "localhost:5555")
+	viper.SetDefault("gm_control_api_fake", false)
 	viper.SetDefault("gm_control_api_org_key", "deciphernow")
+	viper.SetDefault("gm_control_api_org_key_header", "x-gm-org-key")
+	viper.SetDefault("gm_control_api_bearer_token", "")
+	viper.SetDefault("gm_control_api_basic_user", "")
+	viper.SetDefault("gm_control_api_basic_password", "")
+	viper.SetDefault("gm_control_api_headers", "")
+	viper.SetDefault("gm_control_api_auth_required", false)
+	viper.SetDefault("gm_control_api_use_tls", false)
+	viper.SetDefault("gm_control_api_ca_file", "")
+	viper.SetDefault("gm_control_api_cert_file", "")
+	viper.SetDefault("gm_control_api_key_file", "")
+	viper.SetDefault("gm_control_api_server_name", "")
+	viper.SetDefault("gm_control_api_insecure_skip_verify", false)
+	viper.SetDefault("gm_control_api_backend_path", "")
+	viper.SetDefault("backend_settle_timeout", "10s")
+	viper.SetDefault("allow_restore", false)
 	viper.SetDefault("log_level", "debug")
+	viper.SetDefault("run_timeout", "10m")
+	viper.SetDefault("request_timeout", "30s")
+	viper.SetDefault("retry_max_attempts", 5)
+	viper.SetDefault("retry_base_delay", "200ms")
+	viper.SetDefault("retry_max_delay", "5s")
+	viper.SetDefault("retry_post", false)
+	viper.SetDefault("wait_for_api", "1m")
+	viper.SetDefault("cleanup_timeout", "1m")
+	viper.SetDefault("run_id", "")
+	viper.SetDefault("name_prefix", "")
+	viper.SetDefault("name_suffix", "")
+	viper.SetDefault("junit_report_path", "")
+	viper.SetDefault("json_report_path", "")
+	viper.SetDefault("stress_writers", 8)
+	viper.SetDefault("stress_edits", 10)
+	viper.SetDefault("stress_instances", 16)
+	viper.SetDefault("stress_registrations", 3)
+	viper.SetDefault("stress_max_attempts", 100)
+	viper.SetDefault("tags", "")
+	viper.SetDefault("skip_tags", "")
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/pkg/errors"
@@ -17,11 +18,14 @@ type Model struct {
 	SharedRules api.SharedRules
 	Route       api.Route
 	Proxy       api.Proxy
+
+	names   objectNames
+	cleanup *cleanupRegistry
 }
 
-func (model *Model) loadZone(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) loadZone(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("verifying that zone does not exist before test")
-	zones, err := queryZoneByName(client)
+	zones, err := queryZoneByName(ctx, client, model.names.zone)
 	if err != nil {
 		return errors.Wrap(err, "queryZoneByName")
 	}
@@ -29,12 +33,13 @@ func (model *Model) loadZone(logger zerolog.Logger, client *clientStruct) error
 		return errors.Errorf("zone found before test: %+v", zones)
 	}
 	logger.Debug().Msg("creating zone")
-	model.Zone, err = createZone(client)
+	model.Zone, err = createZone(ctx, client, model.names.zone)
 	if err != nil {
 		return errors.Wrap(err, "createZone")
 	}
+	model.cleanup.registerObject(client.zones().resourceClient, string(model.Zone.ZoneKey))
 	logger.Debug().Msg("verifying that zone exists")
-	zones, err = queryZoneByName(client)
+	zones, err = queryZoneByName(ctx, client, model.names.zone)
 	if err != nil {
 		return errors.Wrap(err, "queryZoneByName")
 	}
@@ -45,9 +50,9 @@ func (model *Model) loadZone(logger zerolog.Logger, client *clientStruct) error
 	return nil
 }
 
-func (model *Model) loadCluster(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) loadCluster(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("verifying that cluster does not exist before test")
-	clusters, err := queryClusterByName(client)
+	clusters, err := queryClusterByName(ctx, client, model.names.cluster)
 	if err != nil {
 		return errors.Wrap(err, "queryClustersByName")
 	}
@@ -55,12 +60,13 @@ func (model *Model) loadCluster(logger zerolog.Logger, client *clientStruct) err
 		return errors.Errorf("cluster found before test: %+v", clusters)
 	}
 	logger.Debug().Msg("creating cluster")
-	model.Cluster1, err = createCluster(client, model.Zone)
+	model.Cluster1, err = createCluster(ctx, client, model.Zone, model.names.cluster)
 	if err != nil {
 		return errors.Wrap(err, "createCluster")
 	}
+	model.cleanup.registerObject(client.clusters().resourceClient, string(model.Cluster1.ClusterKey))
 	logger.Debug().Msg("verifying that cluster exists")
-	clusters, err = queryClusterByName(client)
+	clusters, err = queryClusterByName(ctx, client, model.names.cluster)
 	if err != nil {
 		return errors.Wrap(err, "queryClusterByName")
 	}
@@ -71,9 +77,9 @@ func (model *Model) loadCluster(logger zerolog.Logger, client *clientStruct) err
 	return nil
 }
 
-func (model *Model) loadDomain(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) loadDomain(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("verifying that domain does not exist before test")
-	domains, err := queryDomainByName(client)
+	domains, err := queryDomainByName(ctx, client, model.names.domain)
 	if err != nil {
 		return errors.Wrap(err, "queryDomainByName")
 	}
@@ -81,12 +87,13 @@ func (model *Model) loadDomain(logger zerolog.Logger, client *clientStruct) erro
 		return errors.Errorf("domain found before test: %+v", domains)
 	}
 	logger.Debug().Msg("creating domain")
-	model.Domain, err = createDomain(client, model.Zone)
+	model.Domain, err = createDomain(ctx, client, model.Zone, model.names.domain)
 	if err != nil {
 		return errors.Wrap(err, "createDomain")
 	}
+	model.cleanup.registerObject(client.domains().resourceClient, string(model.Domain.DomainKey))
 	logger.Debug().Msg("verifying that domain exists")
-	domains, err = queryDomainByName(client)
+	domains, err = queryDomainByName(ctx, client, model.names.domain)
 	if err != nil {
 		return errors.Wrap(err, "queryDomainByName")
 	}
@@ -97,9 +104,9 @@ func (model *Model) loadDomain(logger zerolog.Logger, client *clientStruct) erro
 	return nil
 }
 
-func (model *Model) loadListener(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) loadListener(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("verifying that listener does not exist before test")
-	listeners, err := queryListenerByName(client)
+	listeners, err := queryListenerByName(ctx, client, model.names.listener)
 	if err != nil {
 		return errors.Wrap(err, "queryListenerByName")
 	}
@@ -107,12 +114,13 @@ func (model *Model) loadListener(logger zerolog.Logger, client *clientStruct) er
 		return errors.Errorf("listener found before test: %+v", listeners)
 	}
 	logger.Debug().Msg("creating listener")
-	model.Listener, err = createListener(client, model.Zone, model.Domain)
+	model.Listener, err = createListener(ctx, client, model.Zone, model.Domain, model.names.listener)
 	if err != nil {
 		return errors.Wrap(err, "createListener")
 	}
+	model.cleanup.registerObject(client.listeners().resourceClient, string(model.Listener.ListenerKey))
 	logger.Debug().Msg("verifying that listener exists")
-	listeners, err = queryListenerByName(client)
+	listeners, err = queryListenerByName(ctx, client, model.names.listener)
 	if err != nil {
 		return errors.Wrap(err, "queryListenerByName")
 	}
@@ -123,9 +131,9 @@ func (model *Model) loadListener(logger zerolog.Logger, client *clientStruct) er
 	return nil
 }
 
-func (model *Model) loadSharedRules(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) loadSharedRules(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("verifying that shared_rules does not exist before test")
-	sharedRulesSlice, err := querySharedRulesByName(client)
+	sharedRulesSlice, err := querySharedRulesByName(ctx, client, model.names.sharedRules)
 	if err != nil {
 		return errors.Wrap(err, "querySharedRulesByName")
 	}
@@ -133,12 +141,13 @@ func (model *Model) loadSharedRules(logger zerolog.Logger, client *clientStruct)
 		return errors.Errorf("sharedRules found before test: %+v", sharedRulesSlice)
 	}
 	logger.Debug().Msg("creating shared_rules")
-	model.SharedRules, err = createSharedRules(client, model.Zone)
+	model.SharedRules, err = createSharedRules(ctx, client, model.Zone, model.names.sharedRules)
 	if err != nil {
 		return errors.Wrap(err, "createSharedRules")
 	}
+	model.cleanup.registerObject(client.sharedRules().resourceClient, string(model.SharedRules.SharedRulesKey))
 	logger.Debug().Msg("verifying that shared_rules exists")
-	sharedRulesSlice, err = querySharedRulesByName(client)
+	sharedRulesSlice, err = querySharedRulesByName(ctx, client, model.names.sharedRules)
 	if err != nil {
 		return errors.Wrap(err, "querySharedRulesByName")
 	}
@@ -149,9 +158,9 @@ func (model *Model) loadSharedRules(logger zerolog.Logger, client *clientStruct)
 	return nil
 }
 
-func (model *Model) loadRoute(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) loadRoute(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("verifying that route does not exist before test")
-	routes, err := queryRouteByPath(client)
+	routes, err := queryRouteByPath(ctx, client, model.names.routePath)
 	if err != nil {
 		return errors.Wrap(err, "queryRouteByPath")
 	}
@@ -159,12 +168,20 @@ func (model *Model) loadRoute(logger zerolog.Logger, client *clientStruct) error
 		return errors.Errorf("routes found before test: %+v", routes)
 	}
 	logger.Debug().Msg("creating route")
-	model.Route, err = createRoute(client, model.Zone, model.Domain, model.SharedRules)
+	model.Route, err = createRoute(
+		ctx,
+		client,
+		model.Zone,
+		model.Domain,
+		model.SharedRules,
+		model.names.routePath,
+	)
 	if err != nil {
 		return errors.Wrap(err, "createRoute")
 	}
+	model.cleanup.registerObject(client.routes().resourceClient, string(model.Route.RouteKey))
 	logger.Debug().Msg("verifying that route exists")
-	routes, err = queryRouteByPath(client)
+	routes, err = queryRouteByPath(ctx, client, model.names.routePath)
 	if err != nil {
 		return errors.Wrap(err, "queryRouteByPath")
 	}
@@ -175,9 +192,9 @@ func (model *Model) loadRoute(logger zerolog.Logger, client *clientStruct) error
 	return nil
 }
 
-func (model *Model) loadProxy(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) loadProxy(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("verifying that proxy does not exist before test")
-	proxies, err := queryProxyByName(client)
+	proxies, err := queryProxyByName(ctx, client, model.names.proxy)
 	if err != nil {
 		return errors.Wrap(err, "queryProxyByName")
 	}
@@ -185,12 +202,20 @@ func (model *Model) loadProxy(logger zerolog.Logger, client *clientStruct) error
 		return errors.Errorf("proxies found before test: %+v", proxies)
 	}
 	logger.Debug().Msg("creating proxy")
-	model.Proxy, err = createProxy(client, model.Zone, model.Domain, model.Listener)
+	model.Proxy, err = createProxy(
+		ctx,
+		client,
+		model.Zone,
+		model.Domain,
+		model.Listener,
+		model.names.proxy,
+	)
 	if err != nil {
 		return errors.Wrap(err, "createProxy")
 	}
+	model.cleanup.registerObject(client.proxies().resourceClient, string(model.Proxy.ProxyKey))
 	logger.Debug().Msg("verifying that route exists")
-	proxies, err = queryProxyByName(client)
+	proxies, err = queryProxyByName(ctx, client, model.names.proxy)
 	if err != nil {
 		return errors.Wrap(err, "queryProxyByName")
 	}
@@ -201,9 +226,9 @@ func (model *Model) loadProxy(logger zerolog.Logger, client *clientStruct) error
 	return nil
 }
 
-func (model *Model) getZone(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) getZone(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("getting the zone object")
-	zone2, err := getZoneByKey(client, model.Zone.ZoneKey)
+	zone2, err := getZoneByKey(ctx, client, model.Zone.ZoneKey)
 	if err != nil {
 		return errors.Wrap(err, "getZoneByKey")
 	}
@@ -218,11 +243,11 @@ func (model *Model) getZone(logger zerolog.Logger, client *clientStruct) error {
 	return nil
 }
 
-func (model *Model) modifyCluster(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) modifyCluster(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("editing the cluster object")
 	maxConnections := 42
 	model.Cluster1.CircuitBreakers = &api.CircuitBreakers{MaxConnections: &maxConnections}
-	cluster2, err := editCluster(client, model.Cluster1)
+	cluster2, err := editCluster(ctx, client, model.Cluster1)
 	if err != nil {
 		return errors.Wrap(err, "editCluster")
 	}
@@ -237,7 +262,7 @@ func (model *Model) modifyCluster(logger zerolog.Logger, client *clientStruct) e
 
 	logger.Debug().Msg("adding a cluster instance")
 	instance := api.Instance{Host: "localhost", Port: 42}
-	cluster3, err := putClusterInstance(client, model.Cluster1, instance)
+	cluster3, err := putClusterInstance(ctx, client, model.Cluster1, instance)
 	if err != nil {
 		return errors.Wrap(err, "putClusterInstance")
 	}
@@ -251,7 +276,7 @@ func (model *Model) modifyCluster(logger zerolog.Logger, client *clientStruct) e
 	model.Cluster1 = cluster3
 
 	logger.Debug().Msg("deleting a cluster instance")
-	cluster4, err := deleteClusterInstance(client, model.Cluster1, instance)
+	cluster4, err := deleteClusterInstance(ctx, client, model.Cluster1, instance)
 	if err != nil {
 		return errors.Wrap(err, "deleteClusterInstance")
 	}
@@ -265,7 +290,7 @@ func (model *Model) modifyCluster(logger zerolog.Logger, client *clientStruct) e
 	model.Cluster1 = cluster4
 
 	logger.Debug().Msg("getting the cluster object")
-	cluster5, err := getClusterByKey(client, model.Cluster1.ClusterKey)
+	cluster5, err := getClusterByKey(ctx, client, model.Cluster1.ClusterKey)
 	if err != nil {
 		return errors.Wrap(err, "getClusterByKey")
 	}
@@ -280,11 +305,11 @@ func (model *Model) modifyCluster(logger zerolog.Logger, client *clientStruct) e
 	return nil
 }
 
-func (model *Model) modifyDomain(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) modifyDomain(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("editing the domain object")
 	const testPort = 333
 	model.Domain.Port = testPort
-	domain2, err := editDomain(client, model.Domain)
+	domain2, err := editDomain(ctx, client, model.Domain)
 	if err != nil {
 		return errors.Wrap(err, "editDomain")
 	}
@@ -298,7 +323,7 @@ func (model *Model) modifyDomain(logger zerolog.Logger, client *clientStruct) er
 	model.Domain = domain2
 
 	logger.Debug().Msg("getting the domain object")
-	domain3, err := getDomainByKey(client, model.Domain.DomainKey)
+	domain3, err := getDomainByKey(ctx, client, model.Domain.DomainKey)
 	if err != nil {
 		return errors.Wrap(err, "getDomainByKey")
 	}
@@ -313,11 +338,11 @@ func (model *Model) modifyDomain(logger zerolog.Logger, client *clientStruct) er
 	return nil
 }
 
-func (model *Model) modifyListener(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) modifyListener(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("editing the listener object")
 	const testPort = 888
 	model.Listener.Port = testPort
-	listener2, err := editListener(client, model.Listener)
+	listener2, err := editListener(ctx, client, model.Listener)
 	if err != nil {
 		return errors.Wrap(err, "editListener")
 	}
@@ -331,7 +356,7 @@ func (model *Model) modifyListener(logger zerolog.Logger, client *clientStruct)
 	model.Listener = listener2
 
 	logger.Debug().Msg("getting the listener object")
-	listener3, err := getListenerByKey(client, model.Listener.ListenerKey)
+	listener3, err := getListenerByKey(ctx, client, model.Listener.ListenerKey)
 	if err != nil {
 		return errors.Wrap(err, "getListenerByKey")
 	}
@@ -346,11 +371,11 @@ func (model *Model) modifyListener(logger zerolog.Logger, client *clientStruct)
 	return nil
 }
 
-func (model *Model) modifySharedRules(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) modifySharedRules(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("editing the shared_rules object")
 
 	model.SharedRules.Properties = api.Metadata{api.Metadatum{Key: "sr-key", Value: "sr-value"}}
-	sharedRules2, err := editSharedRules(client, model.SharedRules)
+	sharedRules2, err := editSharedRules(ctx, client, model.SharedRules)
 	if err != nil {
 		return errors.Wrap(err, "editSharedRules")
 	}
@@ -364,7 +389,7 @@ func (model *Model) modifySharedRules(logger zerolog.Logger, client *clientStruc
 	model.SharedRules = sharedRules2
 
 	logger.Debug().Msg("getting the shared rules object")
-	sharedRules3, err := getSharedRulesByKey(client, model.SharedRules.SharedRulesKey)
+	sharedRules3, err := getSharedRulesByKey(ctx, client, model.SharedRules.SharedRulesKey)
 	if err != nil {
 		return errors.Wrap(err, "getSharedRulesByKey")
 	}
@@ -379,11 +404,11 @@ func (model *Model) modifySharedRules(logger zerolog.Logger, client *clientStruc
 	return nil
 }
 
-func (model *Model) modifyRoute(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) modifyRoute(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("editing the route object")
 
 	model.Route.PrefixRewrite = "/prefix"
-	route2, err := editRoute(client, model.Route)
+	route2, err := editRoute(ctx, client, model.Route)
 	if err != nil {
 		return errors.Wrap(err, "editRoute")
 	}
@@ -397,7 +422,7 @@ func (model *Model) modifyRoute(logger zerolog.Logger, client *clientStruct) err
 	model.Route = route2
 
 	logger.Debug().Msg("getting the route object")
-	route3, err := getRouteByKey(client, model.Route.RouteKey)
+	route3, err := getRouteByKey(ctx, client, model.Route.RouteKey)
 	if err != nil {
 		return errors.Wrap(err, "getRouteByKey")
 	}
@@ -412,11 +437,11 @@ func (model *Model) modifyRoute(logger zerolog.Logger, client *clientStruct) err
 	return nil
 }
 
-func (model *Model) modifyProxy(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) modifyProxy(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("editing the proxy object")
 
 	model.Proxy.ActiveFilters = []api.GMProxyFilter{api.GMProxyFilter("test-filter")}
-	proxy2, err := editProxy(client, model.Proxy)
+	proxy2, err := editProxy(ctx, client, model.Proxy)
 	if err != nil {
 		return errors.Wrap(err, "editRoute")
 	}
@@ -430,7 +455,7 @@ func (model *Model) modifyProxy(logger zerolog.Logger, client *clientStruct) err
 	model.Proxy = proxy2
 
 	logger.Debug().Msg("getting the proxy object")
-	proxy3, err := getProxyByKey(client, model.Proxy.ProxyKey)
+	proxy3, err := getProxyByKey(ctx, client, model.Proxy.ProxyKey)
 	if err != nil {
 		return errors.Wrap(err, "getProxyByKey")
 	}
@@ -445,14 +470,15 @@ func (model *Model) modifyProxy(logger zerolog.Logger, client *clientStruct) err
 	return nil
 }
 
-func (model *Model) deleteCluster(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) deleteCluster(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("deleting cluster")
-	err := deleteCluster(client, model.Cluster1)
+	err := deleteCluster(ctx, client, model.Cluster1)
 	if err != nil {
 		return errors.Wrap(err, "deleteCluster")
 	}
+	model.cleanup.forgetObject(clusterResource, string(model.Cluster1.ClusterKey))
 	logger.Debug().Msg("verifying that cluster does not exist after test")
-	clusters, err := queryClusterByName(client)
+	clusters, err := queryClusterByName(ctx, client, model.names.cluster)
 	if err != nil {
 		return errors.Wrap(err, "queryClusterByName")
 	}
@@ -464,14 +490,15 @@ func (model *Model) deleteCluster(logger zerolog.Logger, client *clientStruct) e
 	return nil
 }
 
-func (model *Model) deleteListener(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) deleteListener(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("deleting listener")
-	err := deleteListener(client, model.Listener)
+	err := deleteListener(ctx, client, model.Listener)
 	if err != nil {
 		return errors.Wrap(err, "deleteListener")
 	}
+	model.cleanup.forgetObject(listenerResource, string(model.Listener.ListenerKey))
 	logger.Debug().Msg("verifying that listener does not exist after test")
-	listeners, err := queryListenerByName(client)
+	listeners, err := queryListenerByName(ctx, client, model.names.listener)
 	if err != nil {
 		return errors.Wrap(err, "queryListenerByName")
 	}
@@ -483,14 +510,15 @@ func (model *Model) deleteListener(logger zerolog.Logger, client *clientStruct)
 	return nil
 }
 
-func (model *Model) deleteSharedRules(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) deleteSharedRules(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("deleting shared_rules")
-	err := deleteSharedRules(client, model.SharedRules)
+	err := deleteSharedRules(ctx, client, model.SharedRules)
 	if err != nil {
 		return errors.Wrap(err, "deleteSharedRules")
 	}
+	model.cleanup.forgetObject(sharedRulesResource, string(model.SharedRules.SharedRulesKey))
 	logger.Debug().Msg("verifying that shared rules does not exist after test")
-	sharedRulesSlice, err := querySharedRulesByName(client)
+	sharedRulesSlice, err := querySharedRulesByName(ctx, client, model.names.sharedRules)
 	if err != nil {
 		return errors.Wrap(err, "querySharedRulesByName")
 	}
@@ -502,14 +530,15 @@ func (model *Model) deleteSharedRules(logger zerolog.Logger, client *clientStruc
 	return nil
 }
 
-func (model *Model) deleteRoute(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) deleteRoute(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("deleting route")
-	err := deleteRoute(client, model.Route)
+	err := deleteRoute(ctx, client, model.Route)
 	if err != nil {
 		return errors.Wrap(err, "deleteRoute")
 	}
+	model.cleanup.forgetObject(routeResource, string(model.Route.RouteKey))
 	logger.Debug().Msg("verifying that route does not exist after test")
-	routes, err := queryRouteByPath(client)
+	routes, err := queryRouteByPath(ctx, client, model.names.routePath)
 	if err != nil {
 		return errors.Wrap(err, "queryRouteByPath")
 	}
@@ -521,14 +550,15 @@ func (model *Model) deleteRoute(logger zerolog.Logger, client *clientStruct) err
 	return nil
 }
 
-func (model *Model) deleteProxy(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) deleteProxy(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("deleting proxy")
-	err := deleteProxy(client, model.Proxy)
+	err := deleteProxy(ctx, client, model.Proxy)
 	if err != nil {
 		return errors.Wrap(err, "deleteProxy")
 	}
+	model.cleanup.forgetObject(proxyResource, string(model.Proxy.ProxyKey))
 	logger.Debug().Msg("verifying that proxy does not exist after test")
-	proxies, err := queryProxyByName(client)
+	proxies, err := queryProxyByName(ctx, client, model.names.proxy)
 	if err != nil {
 		return errors.Wrap(err, "queryProxyByName")
 	}
@@ -540,14 +570,15 @@ func (model *Model) deleteProxy(logger zerolog.Logger, client *clientStruct) err
 	return nil
 }
 
-func (model *Model) deleteDomain(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) deleteDomain(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("deleting domain")
-	err := deleteDomain(client, model.Domain)
+	err := deleteDomain(ctx, client, model.Domain)
 	if err != nil {
 		return errors.Wrap(err, "deleteDomain")
 	}
+	model.cleanup.forgetObject(domainResource, string(model.Domain.DomainKey))
 	logger.Debug().Msg("verifying that domain does not exist after test")
-	domains, err := queryDomainByName(client)
+	domains, err := queryDomainByName(ctx, client, model.names.domain)
 	if err != nil {
 		return errors.Wrap(err, "queryDomainByName")
 	}
@@ -559,14 +590,15 @@ func (model *Model) deleteDomain(logger zerolog.Logger, client *clientStruct) er
 	return nil
 }
 
-func (model *Model) deleteZone(logger zerolog.Logger, client *clientStruct) error {
+func (model *Model) deleteZone(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
 	logger.Debug().Msg("deleting zone")
-	err := deleteZone(client, model.Zone)
+	err := deleteZone(ctx, client, model.Zone)
 	if err != nil {
 		return errors.Wrap(err, "deleteZone")
 	}
+	model.cleanup.forgetObject(zoneResource, string(model.Zone.ZoneKey))
 	logger.Debug().Msg("verifying that zone does not exist after test")
-	zones, err := queryZoneByName(client)
+	zones, err := queryZoneByName(ctx, client, model.names.zone)
 	if err != nil {
 		return errors.Wrap(err, "queryZoneByName")
 	}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// namer derives per-run object names, so that concurrent and repeated runs
+// against one control plane do not collide. A base name becomes
+// prefix + base + "-" + runID + suffix.
+type namer struct {
+	prefix string
+	runID  string
+	suffix string
+}
+
+func (n namer) name(base string) string {
+	name := n.prefix + base
+	if n.runID != "" {
+		name += "-" + n.runID
+	}
+	return name + n.suffix
+}
+
+// path places a base route path under a first segment unique to the run.
+func (n namer) path(base string) string {
+	segment := strings.Trim(n.prefix+n.runID+n.suffix, "/")
+	if segment == "" {
+		return base
+	}
+	return "/" + segment + base
+}
+
+// newRunID returns a short random identifier for a run.
+func newRunID() (string, error) {
+	var id [4]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]), nil
+}
+
+// objectNames are the names one run gives the objects in a Model.
+type objectNames struct {
+	zone        string
+	cluster     string
+	domain      string
+	listener    string
+	sharedRules string
+	routePath   string
+	proxy       string
+}
+
+func newObjectNames(n namer) objectNames {
+	return objectNames{
+		zone:        n.name(zoneName),
+		cluster:     n.name(clusterName),
+		domain:      n.name(domainName),
+		listener:    n.name(listenerName),
+		sharedRules: n.name(sharedRulesName),
+		routePath:   n.path(routePath),
+		proxy:       n.name(proxyName),
+	}
+}
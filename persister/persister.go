@@ -0,0 +1,305 @@
+// Package persister reads the backend.json file gm-control-api keeps when
+// it runs with the file persister, and checks it offline: that every
+// object has a key no other object of its type has and a checksum, and
+// that every key an object refers to belongs to an object in the file.
+//
+// The format is pinned to one shape: a JSON object with one member per
+// object type, named zones, clusters, domains, listeners, shared_rules,
+// routes and proxies, each a list of objects in their API form. An empty
+// file is an empty backend. Members that name no object type are recorded
+// in Ignored rather than refused. This is the shape the fake gm-control-api
+// writes and its backup endpoint answers with; it has not been confirmed
+// against a file written by the real server. How the server computes a
+// checksum is not known either, so one is only required to be present;
+// whether it is the right one is for a comparison with the API to show.
+package persister
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Object is one stored object in its JSON form, with numbers decoded as
+// json.Number.
+type Object map[string]interface{}
+
+// Backend is the contents of a persister file.
+type Backend struct {
+	// Objects holds every object by type segment, as in the /v1.0 URLs,
+	// then by key.
+	Objects map[string]map[string]Object
+	// Ignored lists the members of the file that name no object type.
+	Ignored []string
+
+	// problems found while parsing, reported by Validate
+	problems []Problem
+}
+
+// Problem is one inconsistency in a backend.
+type Problem struct {
+	Segment string
+	Key     string
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Key == "" {
+		return fmt.Sprintf("%s: %s", p.Segment, p.Message)
+	}
+	return fmt.Sprintf("%s %s: %s", p.Segment, p.Key, p.Message)
+}
+
+// kind is one object type the persister stores.
+type kind struct {
+	segment    string
+	section    string
+	keyField   string
+	references []Reference
+}
+
+// Reference is a field holding one key, or a list of keys, of objects of
+// another type.
+type Reference struct {
+	Field   string
+	Segment string
+}
+
+var zoneReference = Reference{Field: "zone_key", Segment: "zone"}
+
+var kinds = []kind{
+	{segment: "zone", section: "zones", keyField: "zone_key"},
+	{segment: "cluster", section: "clusters", keyField: "cluster_key", references: []Reference{zoneReference}},
+	{segment: "domain", section: "domains", keyField: "domain_key", references: []Reference{zoneReference}},
+	{
+		segment:  "listener",
+		section:  "listeners",
+		keyField: "listener_key",
+		references: []Reference{
+			zoneReference,
+			{Field: "domain_keys", Segment: "domain"},
+		},
+	},
+	{segment: "shared_rules", section: "shared_rules", keyField: "shared_rules_key", references: []Reference{zoneReference}},
+	{
+		segment:  "route",
+		section:  "routes",
+		keyField: "route_key",
+		references: []Reference{
+			zoneReference,
+			{Field: "domain_key", Segment: "domain"},
+			{Field: "shared_rules_key", Segment: "shared_rules"},
+		},
+	},
+	{
+		segment:  "proxy",
+		section:  "proxies",
+		keyField: "proxy_key",
+		references: []Reference{
+			zoneReference,
+			{Field: "domain_keys", Segment: "domain"},
+			{Field: "listener_keys", Segment: "listener"},
+		},
+	},
+}
+
+// Segments returns the segment of every object type, in dependency order.
+func Segments() []string {
+	segments := make([]string, len(kinds))
+	for i, k := range kinds {
+		segments[i] = k.segment
+	}
+	return segments
+}
+
+// Section returns the name of the file member holding objects of type
+// segment.
+func Section(segment string) string {
+	return kindOf(segment).section
+}
+
+// References returns the fields of objects of type segment that hold keys
+// of other objects.
+func References(segment string) []Reference {
+	return kindOf(segment).references
+}
+
+// Read parses the persister file at path.
+func Read(path string) (*Backend, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+	backend, err := Parse(contents)
+	if err != nil {
+		return nil, errors.Wrap(err, path)
+	}
+	return backend, nil
+}
+
+// Parse parses the contents of a persister file.
+func Parse(contents []byte) (*Backend, error) {
+	backend := Backend{Objects: make(map[string]map[string]Object)}
+	for _, k := range kinds {
+		backend.Objects[k.segment] = make(map[string]Object)
+	}
+	if len(bytes.TrimSpace(contents)) == 0 {
+		return &backend, nil
+	}
+
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(contents, &top); err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+
+	found := 0
+	for _, k := range kinds {
+		section, ok := top[k.section]
+		if !ok {
+			continue
+		}
+		found++
+		if err := backend.parseSection(k, section); err != nil {
+			return nil, errors.Wrap(err, k.section)
+		}
+	}
+	if found == 0 && len(top) > 0 {
+		return nil, errors.New("no member holds a known object type")
+	}
+
+	for name := range top {
+		if !isSection(name) {
+			backend.Ignored = append(backend.Ignored, name)
+		}
+	}
+	sort.Strings(backend.Ignored)
+	return &backend, nil
+}
+
+// parseSection adds the objects of one section, a list of objects.
+func (backend *Backend) parseSection(k kind, section json.RawMessage) error {
+	var objects []Object
+	decoder := json.NewDecoder(bytes.NewReader(section))
+	decoder.UseNumber()
+	if err := decoder.Decode(&objects); err != nil {
+		return errors.Wrap(err, "Decode")
+	}
+	for i, object := range objects {
+		if object == nil {
+			backend.problem(k.segment, "", "element %d is not an object", i)
+			continue
+		}
+		key, _ := object[k.keyField].(string)
+		backend.add(k, key, object)
+	}
+	return nil
+}
+
+func (backend *Backend) add(k kind, key string, object Object) {
+	if key == "" {
+		backend.problem(k.segment, "", "object %v has no %s", object["name"], k.keyField)
+		return
+	}
+	if _, exists := backend.Objects[k.segment][key]; exists {
+		backend.problem(k.segment, key, "appears more than once")
+		return
+	}
+	backend.Objects[k.segment][key] = object
+}
+
+func (backend *Backend) problem(segment string, key string, format string, args ...interface{}) {
+	backend.problems = append(backend.problems, Problem{
+		Segment: segment,
+		Key:     key,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Count returns the number of objects in the backend.
+func (backend *Backend) Count() int {
+	count := 0
+	for _, objects := range backend.Objects {
+		count += len(objects)
+	}
+	return count
+}
+
+// Validate returns every problem found while parsing, then every object
+// with no checksum or referring to a key that no object of the referenced
+// type has.
+func (backend *Backend) Validate() []Problem {
+	problems := append([]Problem(nil), backend.problems...)
+
+	for _, k := range kinds {
+		for _, key := range backend.Keys(k.segment) {
+			object := backend.Objects[k.segment][key]
+			add := func(format string, args ...interface{}) {
+				problems = append(problems, Problem{Segment: k.segment, Key: key, Message: fmt.Sprintf(format, args...)})
+			}
+
+			if checksum, _ := object["checksum"].(string); checksum == "" {
+				add("has no checksum")
+			}
+			for _, ref := range k.references {
+				for _, referenced := range ReferencedKeys(object, ref.Field) {
+					if _, ok := backend.Objects[ref.Segment][referenced]; !ok {
+						add("%s refers to %s %s, which does not exist", ref.Field, ref.Segment, referenced)
+					}
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+// Keys returns the keys of the objects of type segment, sorted.
+func (backend *Backend) Keys(segment string) []string {
+	keys := make([]string, 0, len(backend.Objects[segment]))
+	for key := range backend.Objects[segment] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func kindOf(segment string) kind {
+	for _, k := range kinds {
+		if k.segment == segment {
+			return k
+		}
+	}
+	panic("unknown segment " + segment)
+}
+
+func isSection(name string) bool {
+	for _, k := range kinds {
+		if k.section == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ReferencedKeys returns the non-empty keys held in field, which is either
+// a single key or a list of keys.
+func ReferencedKeys(object map[string]interface{}, field string) []string {
+	var keys []string
+	switch value := object[field].(type) {
+	case string:
+		if value != "" {
+			keys = append(keys, value)
+		}
+	case []interface{}:
+		for _, element := range value {
+			if key, _ := element.(string); key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
@@ -0,0 +1,144 @@
+package persister
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		counts   map[string]int
+		ignored  []string
+		wantErr  bool
+	}{
+		{
+			name:     "empty file",
+			contents: " \n",
+			counts:   map[string]int{},
+		},
+		{
+			name:     "empty object",
+			contents: `{}`,
+			counts:   map[string]int{},
+		},
+		{
+			name: "sections",
+			contents: `{
+				"zones": [{"zone_key": "z1", "name": "zone1", "checksum": "a"}],
+				"clusters": [
+					{"cluster_key": "c1", "zone_key": "z1", "checksum": "b"},
+					{"cluster_key": "c2", "zone_key": "z1", "checksum": "c"}
+				],
+				"routes": []
+			}`,
+			counts: map[string]int{"zone": 1, "cluster": 2},
+		},
+		{
+			name:     "unknown members are ignored",
+			contents: `{"zones": [], "version": 2, "metadata": {}}`,
+			counts:   map[string]int{},
+			ignored:  []string{"metadata", "version"},
+		},
+		{
+			name:     "no known member",
+			contents: `{"version": 2}`,
+			wantErr:  true,
+		},
+		{
+			name:     "not an object",
+			contents: `[]`,
+			wantErr:  true,
+		},
+		{
+			name:     "section not a list",
+			contents: `{"zones": {"z1": {}}}`,
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend, err := Parse([]byte(test.contents))
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("Parse succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			for _, segment := range Segments() {
+				if got := len(backend.Objects[segment]); got != test.counts[segment] {
+					t.Errorf("%d %s objects, want %d", got, segment, test.counts[segment])
+				}
+			}
+			if !reflect.DeepEqual(backend.Ignored, test.ignored) {
+				t.Errorf("Ignored = %v, want %v", backend.Ignored, test.ignored)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     []string
+	}{
+		{
+			name: "valid",
+			contents: `{
+				"zones": [{"zone_key": "z1", "checksum": "a"}],
+				"domains": [{"domain_key": "d1", "zone_key": "z1", "checksum": "b"}],
+				"listeners": [{"listener_key": "l1", "zone_key": "z1", "domain_keys": ["d1"], "checksum": "c"}]
+			}`,
+		},
+		{
+			name:     "missing key",
+			contents: `{"zones": [{"name": "zone1", "checksum": "a"}]}`,
+			want:     []string{"zone: object zone1 has no zone_key"},
+		},
+		{
+			name:     "duplicate key",
+			contents: `{"zones": [{"zone_key": "z1", "checksum": "a"}, {"zone_key": "z1", "checksum": "b"}]}`,
+			want:     []string{"zone z1: appears more than once"},
+		},
+		{
+			name:     "element not an object",
+			contents: `{"zones": [null]}`,
+			want:     []string{"zone: element 0 is not an object"},
+		},
+		{
+			name:     "missing checksum",
+			contents: `{"zones": [{"zone_key": "z1"}]}`,
+			want:     []string{"zone z1: has no checksum"},
+		},
+		{
+			name: "missing reference",
+			contents: `{
+				"zones": [{"zone_key": "z1", "checksum": "a"}],
+				"listeners": [{"listener_key": "l1", "zone_key": "z1", "domain_keys": ["d1", ""], "checksum": "b"}]
+			}`,
+			want: []string{"listener l1: domain_keys refers to domain d1, which does not exist"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend, err := Parse([]byte(test.contents))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			var got []string
+			for _, problem := range backend.Validate() {
+				got = append(got, problem.String())
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Validate() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
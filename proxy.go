@@ -1,14 +1,7 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
-
-	"github.com/pkg/errors"
+	"context"
 
 	api "github.com/deciphernow/gm-control-api/api"
 	service "github.com/deciphernow/gm-control-api/api/service"
@@ -16,156 +9,76 @@ import (
 
 const proxyName = "proxy-name"
 
-func createProxy(
-	client *clientStruct,
-	zone api.Zone,
-	domain api.Domain,
-	listener api.Listener,
-) (api.Proxy, error) {
-	var reqProxy api.Proxy
-	var respProxy api.Proxy
-	var buffer bytes.Buffer
-	var request http.Request
-
-	reqProxy.Name = proxyName
-	reqProxy.ZoneKey = zone.ZoneKey
-	reqProxy.DomainKeys = []api.DomainKey{domain.DomainKey}
-	reqProxy.ListenerKeys = []api.ListenerKey{listener.ListenerKey}
-
-	if err := json.NewEncoder(&buffer).Encode(&reqProxy); err != nil {
-		return api.Proxy{}, errors.Wrap(err, "Encode")
-	}
-
-	request.Method = "POST"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   "/v1.0/proxy",
-	}
-	request.Body = ioutil.NopCloser(&buffer)
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Proxy{}, errors.Wrap(err, "doHTTP")
-	}
-
-	if err = json.Unmarshal(rawMessage, &respProxy); err != nil {
-		return api.Proxy{}, errors.Wrap(err, "Unmarshal")
-	}
-
-	return respProxy, nil
+var proxyResource = resource{
+	segment:   "proxy",
+	newObject: func() interface{} { return &api.Proxy{} },
+	identify: func(object interface{}) (string, string) {
+		proxy := object.(*api.Proxy)
+		return string(proxy.ProxyKey), proxy.Checksum.Checksum
+	},
+	equal: func(a interface{}, b interface{}) bool {
+		return a.(*api.Proxy).Equals(*b.(*api.Proxy))
+	},
 }
 
-func queryProxyByName(client *clientStruct) (api.Proxies, error) {
-	var buffer bytes.Buffer
-	var request http.Request
-
-	nameFilter := service.ProxyFilter{Name: proxyName}
-	nameFilters := []service.ProxyFilter{nameFilter}
-
-	if err := json.NewEncoder(&buffer).Encode(nameFilters); err != nil {
-		return nil, errors.Wrap(err, "Encode filters")
-	}
-
-	request.Method = "GET"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   "/v1.0/proxy",
-	}
-
-	values := url.Values{}
-	values.Add("filters", buffer.String())
-	request.URL.RawQuery = values.Encode()
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return nil, errors.Wrap(err, "doHTTP")
-	}
-
-	var proxys []api.Proxy
-
-	if err = json.Unmarshal(rawMessage, &proxys); err != nil {
-		return nil, errors.Wrap(err, "Unmarshal")
-	}
+type proxyClient struct{ resourceClient }
 
-	return proxys, nil
+func (client *clientStruct) proxies() proxyClient {
+	return proxyClient{resourceClient{client: client, resource: proxyResource}}
 }
 
-func getProxyByKey(client *clientStruct, proxyKey api.ProxyKey) (api.Proxy, error) {
-	var respProxy api.Proxy
-	var request http.Request
-
-	request.Method = "GET"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   fmt.Sprintf("/v1.0/proxy/%s", url.PathEscape(string(proxyKey))),
-	}
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Proxy{}, errors.Wrap(err, "doHTTP")
-	}
+func (c proxyClient) Create(ctx context.Context, proxy api.Proxy) (result api.Proxy, err error) {
+	err = c.create(ctx, &proxy, &result)
+	return result, err
+}
 
-	if err = json.Unmarshal(rawMessage, &respProxy); err != nil {
-		return api.Proxy{}, errors.Wrap(err, "Unmarshal")
-	}
+func (c proxyClient) List(ctx context.Context, filters ...service.ProxyFilter) (result api.Proxies, err error) {
+	err = c.list(ctx, filters, &result)
+	return result, err
+}
 
-	return respProxy, nil
+func (c proxyClient) Get(ctx context.Context, proxyKey api.ProxyKey) (result api.Proxy, err error) {
+	err = c.get(ctx, string(proxyKey), &result)
+	return result, err
 }
 
-func editProxy(client *clientStruct, proxy api.Proxy) (api.Proxy, error) {
-	var respProxy api.Proxy
-	var buffer bytes.Buffer
-	var request http.Request
-
-	if err := json.NewEncoder(&buffer).Encode(&proxy); err != nil {
-		return api.Proxy{}, errors.Wrap(err, "Encode")
-	}
-
-	request.Method = "PUT"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   proxyKeyPath(proxy),
-	}
-	request.Body = ioutil.NopCloser(&buffer)
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Proxy{}, errors.Wrap(err, "doHTTP")
-	}
-
-	if err = json.Unmarshal(rawMessage, &respProxy); err != nil {
-		return api.Proxy{}, errors.Wrap(err, "Unmarshal")
-	}
-
-	return respProxy, nil
+func (c proxyClient) Update(ctx context.Context, proxy api.Proxy) (result api.Proxy, err error) {
+	err = c.update(ctx, &proxy, &result)
+	return result, err
 }
 
-func deleteProxy(client *clientStruct, proxy api.Proxy) error {
-	var request http.Request
+func (c proxyClient) Delete(ctx context.Context, proxy api.Proxy) error {
+	return c.remove(ctx, &proxy)
+}
 
-	request.Method = "DELETE"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   proxyKeyPath(proxy),
-	}
+func createProxy(
+	ctx context.Context,
+	client *clientStruct,
+	zone api.Zone,
+	domain api.Domain,
+	listener api.Listener,
+	name string,
+) (api.Proxy, error) {
+	return client.proxies().Create(ctx, api.Proxy{
+		Name:         name,
+		ZoneKey:      zone.ZoneKey,
+		DomainKeys:   []api.DomainKey{domain.DomainKey},
+		ListenerKeys: []api.ListenerKey{listener.ListenerKey},
+	})
+}
 
-	values := url.Values{}
-	values.Add("checksum", proxy.Checksum.Checksum)
-	request.URL.RawQuery = values.Encode()
+func queryProxyByName(ctx context.Context, client *clientStruct, name string) (api.Proxies, error) {
+	return client.proxies().List(ctx, service.ProxyFilter{Name: name})
+}
 
-	_, err := client.doHTTP(&request)
-	if err != nil {
-		return errors.Wrap(err, "doHTTP")
-	}
+func getProxyByKey(ctx context.Context, client *clientStruct, proxyKey api.ProxyKey) (api.Proxy, error) {
+	return client.proxies().Get(ctx, proxyKey)
+}
 
-	return nil
+func editProxy(ctx context.Context, client *clientStruct, proxy api.Proxy) (api.Proxy, error) {
+	return client.proxies().Update(ctx, proxy)
 }
 
-func proxyKeyPath(proxy api.Proxy) string {
-	return fmt.Sprintf("/v1.0/proxy/%s", url.PathEscape(string(proxy.ProxyKey)))
+func deleteProxy(ctx context.Context, client *clientStruct, proxy api.Proxy) error {
+	return client.proxies().Delete(ctx, proxy)
 }
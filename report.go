@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// runReport is everything a run produced, for the machine-readable
+// reports.
+type runReport struct {
+	runID    string
+	started  time.Time
+	duration time.Duration
+	results  []stepResult
+	cleanup  []cleanupResult
+}
+
+type junitTestSuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Time       float64          `xml:"time,attr"`
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       float64         `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr,omitempty"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	TestCases  []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// writeJUnitReport writes one testsuite per scenario, one testcase per
+// step, and a cleanup testsuite when anything was cleaned up.
+func writeJUnitReport(path string, report runReport) error {
+	suites := junitTestSuites{
+		Name: "gm-control-api-integration",
+		Time: report.duration.Seconds(),
+	}
+
+	indexes := make(map[string]int)
+	for _, result := range report.results {
+		i, ok := indexes[result.scenario]
+		if !ok {
+			i = len(suites.TestSuites)
+			indexes[result.scenario] = i
+			suites.TestSuites = append(suites.TestSuites, junitTestSuite{
+				Name:       result.scenario,
+				Timestamp:  result.started.UTC().Format("2006-01-02T15:04:05"),
+				Properties: []junitProperty{{Name: "run_id", Value: report.runID}},
+			})
+		}
+		suite := &suites.TestSuites[i]
+
+		testCase := junitTestCase{
+			Name:      result.step,
+			ClassName: result.scenario,
+			Time:      result.duration.Seconds(),
+		}
+		switch result.status {
+		case stepFailed:
+			testCase.Failure = &junitFailure{
+				Message: result.err.Error(),
+				Text:    result.description + "\n" + result.err.Error(),
+			}
+			suite.Failures++
+		case stepSkipped:
+			testCase.Skipped = &junitSkipped{Message: result.reason}
+			suite.Skipped++
+		}
+		suite.Tests++
+		suite.Time += testCase.Time
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+
+	if len(report.cleanup) > 0 {
+		suite := junitTestSuite{
+			Name:       "cleanup",
+			Properties: []junitProperty{{Name: "run_id", Value: report.runID}},
+		}
+		for _, result := range report.cleanup {
+			testCase := junitTestCase{Name: result.name, ClassName: "cleanup"}
+			if result.err != nil {
+				testCase.Failure = &junitFailure{
+					Message: result.err.Error(),
+					Text:    result.err.Error(),
+				}
+				suite.Failures++
+			}
+			suite.Tests++
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+		suites.TestSuites = append(suites.TestSuites, suite)
+	}
+
+	for _, suite := range suites.TestSuites {
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+	}
+
+	contents, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "MarshalIndent")
+	}
+	contents = append([]byte(xml.Header), contents...)
+
+	return errors.Wrap(ioutil.WriteFile(path, contents, 0644), "WriteFile")
+}
+
+type jsonReport struct {
+	RunID      string              `json:"run_id"`
+	Started    time.Time           `json:"started"`
+	DurationMS int64               `json:"duration_ms"`
+	Passed     int                 `json:"passed"`
+	Failed     int                 `json:"failed"`
+	Skipped    int                 `json:"skipped"`
+	Steps      []jsonStepResult    `json:"steps"`
+	Cleanup    []jsonCleanupResult `json:"cleanup"`
+}
+
+type jsonStepResult struct {
+	Scenario    string    `json:"scenario"`
+	Step        string    `json:"step"`
+	Description string    `json:"description"`
+	Status      string    `json:"status"`
+	Started     time.Time `json:"started"`
+	DurationMS  int64     `json:"duration_ms"`
+	Error       string    `json:"error,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+type jsonCleanupResult struct {
+	Object string `json:"object"`
+	Error  string `json:"error,omitempty"`
+}
+
+func writeJSONReport(path string, report runReport) error {
+	output := jsonReport{
+		RunID:      report.runID,
+		Started:    report.started.UTC(),
+		DurationMS: milliseconds(report.duration),
+		Steps:      []jsonStepResult{},
+		Cleanup:    []jsonCleanupResult{},
+	}
+	output.Passed, output.Failed, output.Skipped = countResults(report.results)
+
+	for _, result := range report.results {
+		step := jsonStepResult{
+			Scenario:    result.scenario,
+			Step:        result.step,
+			Description: result.description,
+			Status:      string(result.status),
+			Started:     result.started.UTC(),
+			DurationMS:  milliseconds(result.duration),
+			Reason:      result.reason,
+		}
+		if result.err != nil {
+			step.Error = result.err.Error()
+		}
+		output.Steps = append(output.Steps, step)
+	}
+
+	for _, result := range report.cleanup {
+		cleanup := jsonCleanupResult{Object: result.name}
+		if result.err != nil {
+			cleanup.Error = result.err.Error()
+		}
+		output.Cleanup = append(output.Cleanup, cleanup)
+	}
+
+	contents, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "MarshalIndent")
+	}
+
+	return errors.Wrap(ioutil.WriteFile(path, append(contents, '\n'), 0644), "WriteFile")
+}
+
+func milliseconds(duration time.Duration) int64 {
+	return int64(duration / time.Millisecond)
+}
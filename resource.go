@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// resource describes one gm-control-api object type: the URL segment it
+// lives under, how to allocate one of its objects, how to read the key
+// and checksum of a pointer to one and how to compare two pointers with
+// the api type's Equals method.
+type resource struct {
+	segment   string
+	newObject func() interface{}
+	identify  func(object interface{}) (key string, checksum string)
+	equal     func(a interface{}, b interface{}) bool
+}
+
+func (r resource) collectionPath() string {
+	return fmt.Sprintf("/v1.0/%s", r.segment)
+}
+
+func (r resource) objectPath(key string) string {
+	return fmt.Sprintf("/v1.0/%s/%s", r.segment, url.PathEscape(key))
+}
+
+// resourceClient performs the create, list, get, update and delete
+// requests shared by every object type. The typed clients embed it and
+// supply pointers to their own api values.
+type resourceClient struct {
+	client   *clientStruct
+	resource resource
+}
+
+func (rc resourceClient) create(ctx context.Context, object interface{}, result interface{}) error {
+	request, err := rc.client.newRequest(ctx, "POST", rc.resource.collectionPath(), nil, object)
+	if err != nil {
+		return errors.Wrap(err, "newRequest")
+	}
+
+	return rc.client.doJSON(request, result)
+}
+
+// list queries the collection. filters must be a slice of the service
+// filter type for this resource; an empty slice lists every object.
+func (rc resourceClient) list(ctx context.Context, filters interface{}, result interface{}) error {
+	var values url.Values
+
+	if reflect.ValueOf(filters).Len() > 0 {
+		filterJSON, err := json.Marshal(filters)
+		if err != nil {
+			return errors.Wrap(err, "Encode filters")
+		}
+		values = url.Values{}
+		values.Add("filters", string(filterJSON))
+	}
+
+	request, err := rc.client.newRequest(ctx, "GET", rc.resource.collectionPath(), values, nil)
+	if err != nil {
+		return errors.Wrap(err, "newRequest")
+	}
+
+	return rc.client.doJSON(request, result)
+}
+
+func (rc resourceClient) get(ctx context.Context, key string, result interface{}) error {
+	request, err := rc.client.newRequest(ctx, "GET", rc.resource.objectPath(key), nil, nil)
+	if err != nil {
+		return errors.Wrap(err, "newRequest")
+	}
+
+	return rc.client.doJSON(request, result)
+}
+
+func (rc resourceClient) update(ctx context.Context, object interface{}, result interface{}) error {
+	key, _ := rc.resource.identify(object)
+
+	request, err := rc.client.newRequest(ctx, "PUT", rc.resource.objectPath(key), nil, object)
+	if err != nil {
+		return errors.Wrap(err, "newRequest")
+	}
+
+	return rc.client.doJSON(request, result)
+}
+
+func (rc resourceClient) remove(ctx context.Context, object interface{}) error {
+	key, checksum := rc.resource.identify(object)
+
+	values := url.Values{}
+	values.Add("checksum", checksum)
+
+	request, err := rc.client.newRequest(ctx, "DELETE", rc.resource.objectPath(key), values, nil)
+	if err != nil {
+		return errors.Wrap(err, "newRequest")
+	}
+
+	return rc.client.doJSON(request, nil)
+}
+
+// removeByKey deletes the object stored under key, fetching it first so the
+// delete carries its current checksum.
+func (rc resourceClient) removeByKey(ctx context.Context, key string) error {
+	object := rc.resource.newObject()
+	if err := rc.get(ctx, key, object); err != nil {
+		return errors.Wrap(err, "get")
+	}
+
+	return rc.remove(ctx, object)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// retryPredicate reports whether a failed attempt should be retried.
+// statusCode is zero when the attempt received no response.
+type retryPredicate func(statusCode int, err error) bool
+
+// retryPolicy controls how the client retries failed requests: up to
+// maxAttempts tries, sleeping an exponentially growing, jittered delay
+// between them. Only idempotent methods are retried unless retryPOST is set.
+// The zero value makes a single attempt.
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	retryPOST   bool
+	retryOn     []retryPredicate
+}
+
+func defaultRetryPolicy(maxAttempts int, baseDelay, maxDelay time.Duration, retryPOST bool) retryPolicy {
+	return retryPolicy{
+		maxAttempts: maxAttempts,
+		baseDelay:   baseDelay,
+		maxDelay:    maxDelay,
+		retryPOST:   retryPOST,
+		retryOn: []retryPredicate{
+			retryOnConnectionError,
+			retryOnStatus(
+				http.StatusBadGateway,
+				http.StatusServiceUnavailable,
+				http.StatusGatewayTimeout,
+			),
+		},
+	}
+}
+
+func (policy retryPolicy) shouldRetry(method string, attempt int, statusCode int, err error) bool {
+	if attempt >= policy.maxAttempts {
+		return false
+	}
+
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE":
+	case "POST":
+		if !policy.retryPOST {
+			return false
+		}
+	default:
+		return false
+	}
+
+	for _, retryOn := range policy.retryOn {
+		if retryOn(statusCode, err) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff returns the delay before the attempt following attempt: half of
+// the capped exponential delay plus a random share of the other half. A
+// zero maxDelay leaves the delay uncapped.
+func (policy retryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.baseDelay
+	for i := 1; i < attempt && delay < math.MaxInt64/2; i++ {
+		if policy.maxDelay > 0 && delay >= policy.maxDelay {
+			break
+		}
+		delay *= 2
+	}
+	if policy.maxDelay > 0 && delay > policy.maxDelay {
+		delay = policy.maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// retryOnConnectionError retries attempts that failed before a response
+// arrived, such as a refused connection while gm-control-api starts up.
+func retryOnConnectionError(statusCode int, err error) bool {
+	if statusCode != 0 {
+		return false
+	}
+
+	urlErr, ok := errors.Cause(err).(*url.Error)
+	if !ok {
+		return false
+	}
+	if urlErr.Timeout() {
+		return true
+	}
+	_, ok = urlErr.Err.(net.Error)
+	return ok
+}
+
+func retryOnStatus(statusCodes ...int) retryPredicate {
+	return func(statusCode int, err error) bool {
+		for _, code := range statusCodes {
+			if statusCode == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// minWaitDelay is the least waitForAPI sleeps between polls, so that a
+// zero base delay does not make it spin.
+const minWaitDelay = 100 * time.Millisecond
+
+// waitForAPI polls gm-control-api until it answers a zone listing or ctx is
+// done, so the suite does not start against a control plane that is still
+// coming up. Each poll is a single attempt; the wait itself backs off as
+// the retry policy would, but by at least minWaitDelay.
+func waitForAPI(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	probe := *client
+	probe.retryPolicy = retryPolicy{}
+
+	for attempt := 1; ; attempt++ {
+		_, err := probe.zones().List(ctx)
+		if err == nil {
+			logger.Debug().Int("attempt", attempt).Msg("gm-control-api is ready")
+			return nil
+		}
+
+		delay := client.retryPolicy.backoff(attempt)
+		if delay < minWaitDelay {
+			delay = minWaitDelay
+		}
+		logger.Debug().Err(err).Int("attempt", attempt).Dur("delay", delay).
+			Msg("waiting for gm-control-api")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrapf(err, "gm-control-api not ready: %s", ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   retryPolicy
+		attempt  int
+		min, max time.Duration
+	}{
+		{
+			name:    "zero policy",
+			attempt: 1,
+		},
+		{
+			name:    "first attempt",
+			policy:  retryPolicy{baseDelay: 100 * time.Millisecond, maxDelay: time.Second},
+			attempt: 1,
+			min:     50 * time.Millisecond,
+			max:     100 * time.Millisecond,
+		},
+		{
+			name:    "doubles",
+			policy:  retryPolicy{baseDelay: 100 * time.Millisecond, maxDelay: time.Second},
+			attempt: 3,
+			min:     200 * time.Millisecond,
+			max:     400 * time.Millisecond,
+		},
+		{
+			name:    "capped",
+			policy:  retryPolicy{baseDelay: 100 * time.Millisecond, maxDelay: time.Second},
+			attempt: 10,
+			min:     500 * time.Millisecond,
+			max:     time.Second,
+		},
+		{
+			name:    "no cap",
+			policy:  retryPolicy{baseDelay: time.Millisecond},
+			attempt: 4,
+			min:     4 * time.Millisecond,
+			max:     8 * time.Millisecond,
+		},
+		{
+			name:    "base above cap",
+			policy:  retryPolicy{baseDelay: 2 * time.Second, maxDelay: time.Second},
+			attempt: 1,
+			min:     500 * time.Millisecond,
+			max:     time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				delay := test.policy.backoff(test.attempt)
+				if delay < test.min || delay > test.max {
+					t.Fatalf("backoff(%d) = %s, want between %s and %s", test.attempt, delay, test.min, test.max)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryShouldRetry(t *testing.T) {
+	policy := defaultRetryPolicy(3, time.Millisecond, time.Second, false)
+	unavailable := &APIError{StatusCode: http.StatusServiceUnavailable}
+
+	tests := []struct {
+		name       string
+		policy     retryPolicy
+		method     string
+		attempt    int
+		statusCode int
+		err        error
+		want       bool
+	}{
+		{"unavailable get", policy, "GET", 1, http.StatusServiceUnavailable, unavailable, true},
+		{"unavailable delete", policy, "DELETE", 2, http.StatusServiceUnavailable, unavailable, true},
+		{"attempts used up", policy, "GET", 3, http.StatusServiceUnavailable, unavailable, false},
+		{"post", policy, "POST", 1, http.StatusServiceUnavailable, unavailable, false},
+		{
+			"post allowed",
+			defaultRetryPolicy(3, time.Millisecond, time.Second, true),
+			"POST", 1, http.StatusServiceUnavailable, unavailable, true,
+		},
+		{"patch", policy, "PATCH", 1, http.StatusServiceUnavailable, unavailable, false},
+		{"not found", policy, "GET", 1, http.StatusNotFound, &APIError{StatusCode: http.StatusNotFound}, false},
+		{"server error", policy, "GET", 1, http.StatusInternalServerError, &APIError{StatusCode: 500}, false},
+		{"no response, not a url error", policy, "GET", 1, 0, errors.New("broken"), false},
+		{"zero policy", retryPolicy{}, "GET", 1, http.StatusServiceUnavailable, unavailable, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.policy.shouldRetry(test.method, test.attempt, test.statusCode, test.err)
+			if got != test.want {
+				t.Errorf("shouldRetry(%s, %d, %d) = %v, want %v",
+					test.method, test.attempt, test.statusCode, got, test.want)
+			}
+		})
+	}
+}
@@ -1,14 +1,7 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
-
-	"github.com/pkg/errors"
+	"context"
 
 	api "github.com/deciphernow/gm-control-api/api"
 	service "github.com/deciphernow/gm-control-api/api/service"
@@ -16,156 +9,76 @@ import (
 
 const routePath = "/path/metrics"
 
-func createRoute(
-	client *clientStruct,
-	zone api.Zone,
-	domain api.Domain,
-	sharedRules api.SharedRules,
-) (api.Route, error) {
-	var reqRoute api.Route
-	var respRoute api.Route
-	var buffer bytes.Buffer
-	var request http.Request
-
-	reqRoute.Path = routePath
-	reqRoute.ZoneKey = zone.ZoneKey
-	reqRoute.DomainKey = domain.DomainKey
-	reqRoute.SharedRulesKey = sharedRules.SharedRulesKey
-
-	if err := json.NewEncoder(&buffer).Encode(&reqRoute); err != nil {
-		return api.Route{}, errors.Wrap(err, "Encode")
-	}
-
-	request.Method = "POST"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   "/v1.0/route",
-	}
-	request.Body = ioutil.NopCloser(&buffer)
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Route{}, errors.Wrap(err, "doHTTP")
-	}
-
-	if err = json.Unmarshal(rawMessage, &respRoute); err != nil {
-		return api.Route{}, errors.Wrap(err, "Unmarshal")
-	}
-
-	return respRoute, nil
+var routeResource = resource{
+	segment:   "route",
+	newObject: func() interface{} { return &api.Route{} },
+	identify: func(object interface{}) (string, string) {
+		route := object.(*api.Route)
+		return string(route.RouteKey), route.Checksum.Checksum
+	},
+	equal: func(a interface{}, b interface{}) bool {
+		return a.(*api.Route).Equals(*b.(*api.Route))
+	},
 }
 
-func queryRouteByPath(client *clientStruct) (api.Routes, error) {
-	var buffer bytes.Buffer
-	var request http.Request
-
-	pathFilter := service.RouteFilter{Path: routePath}
-	pathFilters := []service.RouteFilter{pathFilter}
-
-	if err := json.NewEncoder(&buffer).Encode(pathFilters); err != nil {
-		return nil, errors.Wrap(err, "Encode filters")
-	}
-
-	request.Method = "GET"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   "/v1.0/route",
-	}
-
-	values := url.Values{}
-	values.Add("filters", buffer.String())
-	request.URL.RawQuery = values.Encode()
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return nil, errors.Wrap(err, "doHTTP")
-	}
-
-	var routes []api.Route
-
-	if err = json.Unmarshal(rawMessage, &routes); err != nil {
-		return nil, errors.Wrap(err, "Unmarshal")
-	}
+type routeClient struct{ resourceClient }
 
-	return routes, nil
+func (client *clientStruct) routes() routeClient {
+	return routeClient{resourceClient{client: client, resource: routeResource}}
 }
 
-func getRouteByKey(client *clientStruct, routeKey api.RouteKey) (api.Route, error) {
-	var respRoute api.Route
-	var request http.Request
-
-	request.Method = "GET"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   fmt.Sprintf("/v1.0/route/%s", url.PathEscape(string(routeKey))),
-	}
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Route{}, errors.Wrap(err, "doHTTP")
-	}
+func (c routeClient) Create(ctx context.Context, route api.Route) (result api.Route, err error) {
+	err = c.create(ctx, &route, &result)
+	return result, err
+}
 
-	if err = json.Unmarshal(rawMessage, &respRoute); err != nil {
-		return api.Route{}, errors.Wrap(err, "Unmarshal")
-	}
+func (c routeClient) List(ctx context.Context, filters ...service.RouteFilter) (result api.Routes, err error) {
+	err = c.list(ctx, filters, &result)
+	return result, err
+}
 
-	return respRoute, nil
+func (c routeClient) Get(ctx context.Context, routeKey api.RouteKey) (result api.Route, err error) {
+	err = c.get(ctx, string(routeKey), &result)
+	return result, err
 }
 
-func editRoute(client *clientStruct, route api.Route) (api.Route, error) {
-	var respRoute api.Route
-	var buffer bytes.Buffer
-	var request http.Request
-
-	if err := json.NewEncoder(&buffer).Encode(&route); err != nil {
-		return api.Route{}, errors.Wrap(err, "Encode")
-	}
-
-	request.Method = "PUT"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   routeKeyPath(route),
-	}
-	request.Body = ioutil.NopCloser(&buffer)
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Route{}, errors.Wrap(err, "doHTTP")
-	}
-
-	if err = json.Unmarshal(rawMessage, &respRoute); err != nil {
-		return api.Route{}, errors.Wrap(err, "Unmarshal")
-	}
-
-	return respRoute, nil
+func (c routeClient) Update(ctx context.Context, route api.Route) (result api.Route, err error) {
+	err = c.update(ctx, &route, &result)
+	return result, err
 }
 
-func deleteRoute(client *clientStruct, route api.Route) error {
-	var request http.Request
+func (c routeClient) Delete(ctx context.Context, route api.Route) error {
+	return c.remove(ctx, &route)
+}
 
-	request.Method = "DELETE"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   routeKeyPath(route),
-	}
+func createRoute(
+	ctx context.Context,
+	client *clientStruct,
+	zone api.Zone,
+	domain api.Domain,
+	sharedRules api.SharedRules,
+	path string,
+) (api.Route, error) {
+	return client.routes().Create(ctx, api.Route{
+		Path:           path,
+		ZoneKey:        zone.ZoneKey,
+		DomainKey:      domain.DomainKey,
+		SharedRulesKey: sharedRules.SharedRulesKey,
+	})
+}
 
-	values := url.Values{}
-	values.Add("checksum", route.Checksum.Checksum)
-	request.URL.RawQuery = values.Encode()
+func queryRouteByPath(ctx context.Context, client *clientStruct, path string) (api.Routes, error) {
+	return client.routes().List(ctx, service.RouteFilter{Path: path})
+}
 
-	_, err := client.doHTTP(&request)
-	if err != nil {
-		return errors.Wrap(err, "doHTTP")
-	}
+func getRouteByKey(ctx context.Context, client *clientStruct, routeKey api.RouteKey) (api.Route, error) {
+	return client.routes().Get(ctx, routeKey)
+}
 
-	return nil
+func editRoute(ctx context.Context, client *clientStruct, route api.Route) (api.Route, error) {
+	return client.routes().Update(ctx, route)
 }
 
-func routeKeyPath(route api.Route) string {
-	return fmt.Sprintf("/v1.0/route/%s", url.PathEscape(string(route.RouteKey)))
+func deleteRoute(ctx context.Context, client *clientStruct, route api.Route) error {
+	return client.routes().Delete(ctx, route)
 }
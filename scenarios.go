@@ -0,0 +1,406 @@
+package main
+
+// transportScenario checks the client's transport and authentication
+// settings before any objects are created.
+func transportScenario() scenario {
+	return scenario{
+		name:        "transport",
+		description: "client transport and authentication",
+		steps: []step{
+			{
+				name:        "tlsStandIn",
+				description: "exercise TLS settings against a local self-signed stand-in",
+				tags:        []string{"tls", "local"},
+				run:         checkTLSStandIn,
+			},
+			{
+				name:        "credentialsRequired",
+				description: "verify requests without credentials are rejected",
+				tags:        []string{"auth"},
+				run:         checkCredentialsRequired,
+			},
+		},
+	}
+}
+
+// lifecycleScenario creates one object of each type, edits and reads each
+// back, then deletes them again.
+func lifecycleScenario(model *Model) scenario {
+	steps := loadSteps(model)
+	steps = append(steps, []step{
+		{
+			name:        "getZone",
+			description: "read the zone back by key",
+			dependsOn:   []string{"loadZone"},
+			tags:        []string{"zone", "get"},
+			run:         model.getZone,
+		},
+		{
+			name:        "modifyCluster",
+			description: "edit the cluster and add and remove an instance",
+			dependsOn:   []string{"loadCluster"},
+			tags:        []string{"cluster", "edit", "instance"},
+			run:         model.modifyCluster,
+		},
+		{
+			name:        "modifyDomain",
+			description: "edit the domain port",
+			dependsOn:   []string{"loadDomain"},
+			tags:        []string{"domain", "edit"},
+			run:         model.modifyDomain,
+		},
+		{
+			name:        "modifyListener",
+			description: "edit the listener port",
+			dependsOn:   []string{"loadListener"},
+			tags:        []string{"listener", "edit"},
+			run:         model.modifyListener,
+		},
+		{
+			name:        "modifySharedRules",
+			description: "edit the shared rules properties",
+			dependsOn:   []string{"loadSharedRules"},
+			tags:        []string{"shared_rules", "edit"},
+			run:         model.modifySharedRules,
+		},
+		{
+			name:        "modifyRoute",
+			description: "edit the route prefix rewrite",
+			dependsOn:   []string{"loadRoute"},
+			tags:        []string{"route", "edit"},
+			run:         model.modifyRoute,
+		},
+		{
+			name:        "modifyProxy",
+			description: "edit the proxy active filters",
+			dependsOn:   []string{"loadProxy"},
+			tags:        []string{"proxy", "edit"},
+			run:         model.modifyProxy,
+		},
+	}...)
+	steps = append(steps, deleteSteps(model)...)
+
+	return scenario{
+		name:        "lifecycle",
+		description: "create, modify and delete one object of every type",
+		steps:       steps,
+	}
+}
+
+// checksumScenario builds its own set of objects and checks that every
+// write made with an out of date checksum is refused as a conflict.
+func checksumScenario(model *Model) scenario {
+	steps := loadSteps(model)
+	steps = append(steps, []step{
+		{
+			name:        "staleZoneChecksum",
+			description: "reject zone writes with a stale checksum",
+			dependsOn:   []string{"loadZone"},
+			tags:        []string{"zone", "checksum"},
+			run:         model.staleZoneChecksum,
+		},
+		{
+			name:        "staleClusterChecksum",
+			description: "reject cluster and instance writes with a stale checksum",
+			dependsOn:   []string{"loadCluster"},
+			tags:        []string{"cluster", "instance", "checksum"},
+			run:         model.staleClusterChecksum,
+		},
+		{
+			name:        "staleDomainChecksum",
+			description: "reject domain writes with a stale checksum",
+			dependsOn:   []string{"loadDomain"},
+			tags:        []string{"domain", "checksum"},
+			run:         model.staleDomainChecksum,
+		},
+		{
+			name:        "staleListenerChecksum",
+			description: "reject listener writes with a stale checksum",
+			dependsOn:   []string{"loadListener"},
+			tags:        []string{"listener", "checksum"},
+			run:         model.staleListenerChecksum,
+		},
+		{
+			name:        "staleSharedRulesChecksum",
+			description: "reject shared rules writes with a stale checksum",
+			dependsOn:   []string{"loadSharedRules"},
+			tags:        []string{"shared_rules", "checksum"},
+			run:         model.staleSharedRulesChecksum,
+		},
+		{
+			name:        "staleRouteChecksum",
+			description: "reject route writes with a stale checksum",
+			dependsOn:   []string{"loadRoute"},
+			tags:        []string{"route", "checksum"},
+			run:         model.staleRouteChecksum,
+		},
+		{
+			name:        "staleProxyChecksum",
+			description: "reject proxy writes with a stale checksum",
+			dependsOn:   []string{"loadProxy"},
+			tags:        []string{"proxy", "checksum"},
+			run:         model.staleProxyChecksum,
+		},
+	}...)
+	steps = append(steps, deleteSteps(model)...)
+
+	return scenario{
+		name:        "checksum",
+		description: "optimistic concurrency: writes with stale checksums are refused",
+		steps:       steps,
+	}
+}
+
+// integrityScenario checks that gm-control-api refuses to delete objects
+// that others still refer to, and to create a route naming objects that do
+// not exist.
+func integrityScenario(model *Model) scenario {
+	steps := loadSteps(model)
+	steps = append(steps, []step{
+		{
+			name:        "refuseDeleteZoneInUse",
+			description: "refuse to delete a zone with a live cluster",
+			dependsOn:   []string{"loadZone"},
+			tags:        []string{"zone", "integrity"},
+			run:         model.refuseDeleteZoneInUse,
+		},
+		{
+			name:        "refuseDeleteDomainInUse",
+			description: "refuse to delete a domain referenced by a listener or a proxy",
+			dependsOn:   []string{"loadZone"},
+			tags:        []string{"domain", "integrity"},
+			run:         model.refuseDeleteDomainInUse,
+		},
+		{
+			name:        "refuseDeleteSharedRulesInUse",
+			description: "refuse to delete shared rules referenced by a route",
+			dependsOn:   []string{"loadDomain"},
+			tags:        []string{"shared_rules", "integrity"},
+			run:         model.refuseDeleteSharedRulesInUse,
+		},
+		{
+			name:        "refuseRouteWithMissingSharedRules",
+			description: "refuse to create a route naming shared rules that do not exist",
+			dependsOn:   []string{"loadDomain"},
+			tags:        []string{"route", "integrity"},
+			run:         model.refuseRouteWithMissingSharedRules,
+		},
+		{
+			name:        "refuseRouteWithMissingDomain",
+			description: "refuse to create a route naming a domain that does not exist",
+			dependsOn:   []string{"loadSharedRules"},
+			tags:        []string{"route", "integrity"},
+			run:         model.refuseRouteWithMissingDomain,
+		},
+	}...)
+	steps = append(steps, deleteSteps(model)...)
+
+	return scenario{
+		name:        "integrity",
+		description: "referential integrity between dependent objects",
+		steps:       steps,
+	}
+}
+
+// filterScenario seeds two zones of objects and checks the list filters of
+// every object type against them.
+func filterScenario(seed *filterSeed) scenario {
+	return scenario{
+		name:        "filters",
+		description: "list filters over seeded objects",
+		steps: []step{
+			{
+				name:        "seedFilterData",
+				description: "create two zones with two objects of every type in each",
+				tags:        []string{"filter", "create"},
+				run:         seed.seedFilterData,
+			},
+			{
+				name:        "filterZones",
+				description: "list zones by key, name and org key",
+				dependsOn:   []string{"seedFilterData"},
+				tags:        []string{"filter", "zone"},
+				run:         seed.filterZones,
+			},
+			{
+				name:        "filterClusters",
+				description: "list clusters by key, name, zone and org key",
+				dependsOn:   []string{"seedFilterData"},
+				tags:        []string{"filter", "cluster"},
+				run:         seed.filterClusters,
+			},
+			{
+				name:        "filterDomains",
+				description: "list domains by key, name, zone and org key",
+				dependsOn:   []string{"seedFilterData"},
+				tags:        []string{"filter", "domain"},
+				run:         seed.filterDomains,
+			},
+			{
+				name:        "filterListeners",
+				description: "list listeners by key, name, zone and org key",
+				dependsOn:   []string{"seedFilterData"},
+				tags:        []string{"filter", "listener"},
+				run:         seed.filterListeners,
+			},
+			{
+				name:        "filterSharedRules",
+				description: "list shared rules by key, name, zone and org key",
+				dependsOn:   []string{"seedFilterData"},
+				tags:        []string{"filter", "shared_rules"},
+				run:         seed.filterSharedRules,
+			},
+			{
+				name:        "filterRoutes",
+				description: "list routes by key, path, path prefix, zone, domain, shared rules and org key",
+				dependsOn:   []string{"seedFilterData"},
+				tags:        []string{"filter", "route"},
+				run:         seed.filterRoutes,
+			},
+			{
+				name:        "filterProxies",
+				description: "list proxies by key, name, zone and org key",
+				dependsOn:   []string{"seedFilterData"},
+				tags:        []string{"filter", "proxy"},
+				run:         seed.filterProxies,
+			},
+			{
+				name:        "removeFilterData",
+				description: "delete the seeded objects",
+				dependsOn:   []string{"seedFilterData"},
+				tags:        []string{"filter", "delete"},
+				run:         seed.removeFilterData,
+			},
+		},
+	}
+}
+
+// stepsNamed returns the steps with the given names, in the order named.
+func stepsNamed(steps []step, names ...string) []step {
+	byName := make(map[string]step, len(steps))
+	for _, st := range steps {
+		byName[st.name] = st
+	}
+
+	named := make([]step, len(names))
+	for i, name := range names {
+		st, ok := byName[name]
+		if !ok {
+			panic("no step named " + name)
+		}
+		named[i] = st
+	}
+	return named
+}
+
+// loadSteps create one object of every type in model, in dependency order.
+func loadSteps(model *Model) []step {
+	return []step{
+		{
+			name:        "loadZone",
+			description: "create the zone",
+			tags:        []string{"zone", "create"},
+			run:         model.loadZone,
+		},
+		{
+			name:        "loadCluster",
+			description: "create the cluster",
+			dependsOn:   []string{"loadZone"},
+			tags:        []string{"cluster", "create"},
+			run:         model.loadCluster,
+		},
+		{
+			name:        "loadDomain",
+			description: "create the domain",
+			dependsOn:   []string{"loadZone"},
+			tags:        []string{"domain", "create"},
+			run:         model.loadDomain,
+		},
+		{
+			name:        "loadListener",
+			description: "create the listener on the domain",
+			dependsOn:   []string{"loadDomain"},
+			tags:        []string{"listener", "create"},
+			run:         model.loadListener,
+		},
+		{
+			name:        "loadSharedRules",
+			description: "create the shared rules",
+			dependsOn:   []string{"loadZone"},
+			tags:        []string{"shared_rules", "create"},
+			run:         model.loadSharedRules,
+		},
+		{
+			name:        "loadRoute",
+			description: "create the route on the domain with the shared rules",
+			dependsOn:   []string{"loadDomain", "loadSharedRules"},
+			tags:        []string{"route", "create"},
+			run:         model.loadRoute,
+		},
+		{
+			name:        "loadProxy",
+			description: "create the proxy for the domain and listener",
+			dependsOn:   []string{"loadDomain", "loadListener"},
+			tags:        []string{"proxy", "create"},
+			run:         model.loadProxy,
+		},
+	}
+}
+
+// deleteSteps delete the objects created by loadSteps, dependents first.
+// The route goes before the shared rules it names: the integrity scenario
+// expects the control plane to refuse deleting shared rules a route still
+// refers to, so the original order, shared rules first, would fail.
+func deleteSteps(model *Model) []step {
+	return []step{
+		{
+			name:        "deleteProxy",
+			description: "delete the proxy",
+			dependsOn:   []string{"loadProxy"},
+			tags:        []string{"proxy", "delete"},
+			run:         model.deleteProxy,
+		},
+		{
+			name:        "deleteRoute",
+			description: "delete the route",
+			dependsOn:   []string{"loadRoute"},
+			tags:        []string{"route", "delete"},
+			run:         model.deleteRoute,
+		},
+		{
+			name:        "deleteSharedRules",
+			description: "delete the shared rules",
+			dependsOn:   []string{"loadSharedRules"},
+			tags:        []string{"shared_rules", "delete"},
+			run:         model.deleteSharedRules,
+		},
+		{
+			name:        "deleteListener",
+			description: "delete the listener",
+			dependsOn:   []string{"loadListener"},
+			tags:        []string{"listener", "delete"},
+			run:         model.deleteListener,
+		},
+		{
+			name:        "deleteDomain",
+			description: "delete the domain",
+			dependsOn:   []string{"loadDomain"},
+			tags:        []string{"domain", "delete"},
+			run:         model.deleteDomain,
+		},
+		{
+			name:        "deleteCluster",
+			description: "delete the cluster",
+			dependsOn:   []string{"loadCluster"},
+			tags:        []string{"cluster", "delete"},
+			run:         model.deleteCluster,
+		},
+		{
+			name:        "deleteZone",
+			description: "delete the zone",
+			dependsOn:   []string{"loadZone"},
+			tags:        []string{"zone", "delete"},
+			run:         model.deleteZone,
+		},
+	}
+}
@@ -1,14 +1,8 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
-
-	"github.com/pkg/errors"
 
 	api "github.com/deciphernow/gm-control-api/api"
 	service "github.com/deciphernow/gm-control-api/api/service"
@@ -16,152 +10,96 @@ import (
 
 const sharedRulesName = "sharedRules1"
 
-func createSharedRules(
-	client *clientStruct,
-	zone api.Zone,
-) (api.SharedRules, error) {
-	var reqSharedRules api.SharedRules
-	var respSharedRules api.SharedRules
-	var buffer bytes.Buffer
-	var request http.Request
-
-	reqSharedRules.ZoneKey = zone.ZoneKey
-	reqSharedRules.Name = sharedRulesName
-
-	if err := json.NewEncoder(&buffer).Encode(&reqSharedRules); err != nil {
-		return api.SharedRules{}, errors.Wrap(err, "Encode")
-	}
-
-	request.Method = "POST"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   "/v1.0/shared_rules",
-	}
-	request.Body = ioutil.NopCloser(&buffer)
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.SharedRules{}, errors.Wrap(err, "doHTTP")
-	}
-
-	if err = json.Unmarshal(rawMessage, &respSharedRules); err != nil {
-		return api.SharedRules{}, errors.Wrap(err, "Unmarshal")
-	}
-
-	return respSharedRules, nil
+var sharedRulesResource = resource{
+	segment:   "shared_rules",
+	newObject: func() interface{} { return &api.SharedRules{} },
+	identify: func(object interface{}) (string, string) {
+		sharedRules := object.(*api.SharedRules)
+		return string(sharedRules.SharedRulesKey), sharedRules.Checksum.Checksum
+	},
+	equal: func(a interface{}, b interface{}) bool {
+		return a.(*api.SharedRules).Equals(*b.(*api.SharedRules))
+	},
 }
 
-func querySharedRulesByName(client *clientStruct) (api.SharedRulesSlice, error) {
-	var buffer bytes.Buffer
-	var request http.Request
-
-	nameFilter := service.SharedRulesFilter{Name: sharedRulesName}
-	sharedRulesFilters := []service.SharedRulesFilter{nameFilter}
-
-	if err := json.NewEncoder(&buffer).Encode(sharedRulesFilters); err != nil {
-		return nil, errors.Wrap(err, "Encode filters")
-	}
-
-	request.Method = "GET"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   "/v1.0/shared_rules",
-	}
-
-	values := url.Values{}
-	values.Add("filters", buffer.String())
-	request.URL.RawQuery = values.Encode()
+type sharedRulesClient struct{ resourceClient }
 
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return nil, errors.Wrap(err, "doHTTP")
-	}
-
-	var sharedRulesSlice api.SharedRulesSlice
-
-	if err = json.Unmarshal(rawMessage, &sharedRulesSlice); err != nil {
-		return nil, errors.Wrap(err, "Unmarshal")
-	}
-
-	return sharedRulesSlice, nil
+func (client *clientStruct) sharedRules() sharedRulesClient {
+	return sharedRulesClient{resourceClient{client: client, resource: sharedRulesResource}}
 }
 
-func getSharedRulesByKey(client *clientStruct, sharedRulesKey api.SharedRulesKey) (api.SharedRules, error) {
-	var respSharedRules api.SharedRules
-	var request http.Request
-
-	request.Method = "GET"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   fmt.Sprintf("/v1.0/shared_rules/%s", url.PathEscape(string(sharedRulesKey))),
-	}
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.SharedRules{}, errors.Wrap(err, "doHTTP")
-	}
-
-	if err = json.Unmarshal(rawMessage, &respSharedRules); err != nil {
-		return api.SharedRules{}, errors.Wrap(err, "Unmarshal")
-	}
-
-	return respSharedRules, nil
+func (c sharedRulesClient) Create(ctx context.Context, sharedRules api.SharedRules) (result api.SharedRules, err error) {
+	err = c.create(ctx, &sharedRules, &result)
+	return result, err
 }
 
-func editSharedRules(client *clientStruct, sharedRules api.SharedRules) (api.SharedRules, error) {
-	var respSharedRules api.SharedRules
-	var buffer bytes.Buffer
-	var request http.Request
-
-	if err := json.NewEncoder(&buffer).Encode(&sharedRules); err != nil {
-		return api.SharedRules{}, errors.Wrap(err, "Encode")
-	}
-
-	request.Method = "PUT"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   sharedRulesKeyPath(sharedRules),
-	}
-	request.Body = ioutil.NopCloser(&buffer)
+func (c sharedRulesClient) List(ctx context.Context, filters ...service.SharedRulesFilter) (result api.SharedRulesSlice, err error) {
+	err = c.list(ctx, filters, &result)
+	return result, err
+}
 
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.SharedRules{}, errors.Wrap(err, "doHTTP")
-	}
+func (c sharedRulesClient) Get(ctx context.Context, sharedRulesKey api.SharedRulesKey) (result api.SharedRules, err error) {
+	err = c.get(ctx, string(sharedRulesKey), &result)
+	return result, err
+}
 
-	if err = json.Unmarshal(rawMessage, &respSharedRules); err != nil {
-		return api.SharedRules{}, errors.Wrap(err, "Unmarshal")
-	}
+func (c sharedRulesClient) Update(ctx context.Context, sharedRules api.SharedRules) (result api.SharedRules, err error) {
+	err = c.update(ctx, &sharedRules, &result)
+	return result, err
+}
 
-	return respSharedRules, nil
+func (c sharedRulesClient) Delete(ctx context.Context, sharedRules api.SharedRules) error {
+	return c.remove(ctx, &sharedRules)
 }
 
-func deleteSharedRules(client *clientStruct, sharedRules api.SharedRules) error {
-	var request http.Request
+func createSharedRules(
+	ctx context.Context,
+	client *clientStruct,
+	zone api.Zone,
+	name string,
+) (api.SharedRules, error) {
+	return client.sharedRules().Create(ctx, api.SharedRules{
+		ZoneKey: zone.ZoneKey,
+		Name:    name,
+	})
+}
 
-	request.Method = "DELETE"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   sharedRulesKeyPath(sharedRules),
-	}
+// createSplitSharedRules creates shared rules that send traffic to
+// clusters in equal shares.
+func createSplitSharedRules(
+	ctx context.Context,
+	client *clientStruct,
+	zone api.Zone,
+	name string,
+	clusters []api.Cluster,
+) (api.SharedRules, error) {
+	var constraints []api.ClusterConstraint
+	for i, cluster := range clusters {
+		constraints = append(constraints, api.ClusterConstraint{
+			ConstraintKey: fmt.Sprintf("%s-%d", name, i),
+			ClusterKey:    cluster.ClusterKey,
+			Weight:        1,
+		})
+	}
+	return client.sharedRules().Create(ctx, api.SharedRules{
+		ZoneKey: zone.ZoneKey,
+		Name:    name,
+		Default: api.AllConstraints{Light: constraints},
+	})
+}
 
-	values := url.Values{}
-	values.Add("checksum", sharedRules.Checksum.Checksum)
-	request.URL.RawQuery = values.Encode()
+func querySharedRulesByName(ctx context.Context, client *clientStruct, name string) (api.SharedRulesSlice, error) {
+	return client.sharedRules().List(ctx, service.SharedRulesFilter{Name: name})
+}
 
-	_, err := client.doHTTP(&request)
-	if err != nil {
-		return errors.Wrap(err, "doHTTP")
-	}
+func getSharedRulesByKey(ctx context.Context, client *clientStruct, sharedRulesKey api.SharedRulesKey) (api.SharedRules, error) {
+	return client.sharedRules().Get(ctx, sharedRulesKey)
+}
 
-	return nil
+func editSharedRules(ctx context.Context, client *clientStruct, sharedRules api.SharedRules) (api.SharedRules, error) {
+	return client.sharedRules().Update(ctx, sharedRules)
 }
 
-func sharedRulesKeyPath(sharedRules api.SharedRules) string {
-	return fmt.Sprintf("/v1.0/shared_rules/%s", url.PathEscape(string(sharedRules.SharedRulesKey)))
+func deleteSharedRules(ctx context.Context, client *clientStruct, sharedRules api.SharedRules) error {
+	return client.sharedRules().Delete(ctx, sharedRules)
 }
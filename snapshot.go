@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+
+	api "github.com/deciphernow/gm-control-api/api"
+	service "github.com/deciphernow/gm-control-api/api/service"
+)
+
+// snapshot is control plane state in a portable form: a list of zones,
+// each holding its objects, with every reference to another object in the
+// zone written as that object's name instead of its generated key. apply
+// reads snapshots and export writes them.
+type snapshot struct {
+	Zones []snapshotZone `json:"zones"`
+}
+
+type snapshotZone struct {
+	Name        string           `json:"name"`
+	ZoneKey     string           `json:"zone_key,omitempty"`
+	Checksum    string           `json:"checksum,omitempty"`
+	Clusters    []snapshotObject `json:"clusters,omitempty"`
+	Domains     []snapshotObject `json:"domains,omitempty"`
+	SharedRules []snapshotObject `json:"shared_rules,omitempty"`
+	Listeners   []snapshotObject `json:"listeners,omitempty"`
+	Routes      []snapshotObject `json:"routes,omitempty"`
+	Proxies     []snapshotObject `json:"proxies,omitempty"`
+}
+
+// snapshotObject is one object in its JSON form, with numbers decoded as
+// json.Number so they survive a round trip unchanged.
+type snapshotObject map[string]interface{}
+
+// snapshotKind is one type of object held in a snapshot zone.
+type snapshotKind struct {
+	resource resource
+	keyField string
+	section  func(zone *snapshotZone) *[]snapshotObject
+}
+
+// snapshotKinds are listed so that every object refers only to objects of
+// types listed before its own: creating in this order and deleting in the
+// reverse order never leaves a dangling reference.
+var snapshotKinds = []snapshotKind{
+	{
+		resource: clusterResource,
+		keyField: "cluster_key",
+		section:  func(zone *snapshotZone) *[]snapshotObject { return &zone.Clusters },
+	},
+	{
+		resource: domainResource,
+		keyField: "domain_key",
+		section:  func(zone *snapshotZone) *[]snapshotObject { return &zone.Domains },
+	},
+	{
+		resource: sharedRulesResource,
+		keyField: "shared_rules_key",
+		section:  func(zone *snapshotZone) *[]snapshotObject { return &zone.SharedRules },
+	},
+	{
+		resource: listenerResource,
+		keyField: "listener_key",
+		section:  func(zone *snapshotZone) *[]snapshotObject { return &zone.Listeners },
+	},
+	{
+		resource: routeResource,
+		keyField: "route_key",
+		section:  func(zone *snapshotZone) *[]snapshotObject { return &zone.Routes },
+	},
+	{
+		resource: proxyResource,
+		keyField: "proxy_key",
+		section:  func(zone *snapshotZone) *[]snapshotObject { return &zone.Proxies },
+	},
+}
+
+// snapshotReference is a field holding the key, or a list of keys, of
+// objects of another type, and the field a snapshot holds their names in.
+type snapshotReference struct {
+	keyField  string
+	nameField string
+	segment   string
+}
+
+var snapshotReferences = []snapshotReference{
+	{keyField: "cluster_key", nameField: "cluster", segment: "cluster"},
+	{keyField: "domain_key", nameField: "domain", segment: "domain"},
+	{keyField: "domain_keys", nameField: "domains", segment: "domain"},
+	{keyField: "listener_keys", nameField: "listeners", segment: "listener"},
+	{keyField: "shared_rules_key", nameField: "shared_rules", segment: "shared_rules"},
+}
+
+// identity is how an object is told apart from the others of its type in
+// a zone: its name, or for a route, which has none, its domain and quoted
+// path. Quoting keeps the path from running into the domain, so that no
+// two routes share an identity unless they share both.
+func (kind snapshotKind) identity(object snapshotObject) string {
+	if kind.resource.segment == "route" {
+		return fmt.Sprintf("%v %q", object["domain"], fmt.Sprint(object["path"]))
+	}
+	return fmt.Sprint(object["name"])
+}
+
+// zoneIndex maps between the names and keys of the objects in one zone.
+type zoneIndex struct {
+	keys  map[string]map[string]string
+	names map[string]map[string]string
+}
+
+func newZoneIndex() *zoneIndex {
+	return &zoneIndex{
+		keys:  make(map[string]map[string]string),
+		names: make(map[string]map[string]string),
+	}
+}
+
+func (index *zoneIndex) add(segment string, name string, key string) {
+	if index.keys[segment] == nil {
+		index.keys[segment] = make(map[string]string)
+		index.names[segment] = make(map[string]string)
+	}
+	index.keys[segment][name] = key
+	index.names[segment][key] = name
+}
+
+func (index *zoneIndex) key(segment string, name string) (string, error) {
+	key, ok := index.keys[segment][name]
+	if !ok {
+		return "", errors.Errorf("no %s named %q", segment, name)
+	}
+	return key, nil
+}
+
+func (index *zoneIndex) name(segment string, key string) (string, error) {
+	name, ok := index.names[segment][key]
+	if !ok {
+		return "", errors.Errorf("no %s with key %q", segment, key)
+	}
+	return name, nil
+}
+
+// toSnapshotObject converts the JSON form of an object as gm-control-api
+// returns it into snapshot form. The zone and org keys are dropped, since
+// the enclosing zone and the server supply them, and so is the checksum
+// unless keepChecksum is set.
+func toSnapshotObject(kind snapshotKind, fields snapshotObject, index *zoneIndex, keepChecksum bool) (snapshotObject, error) {
+	converted, err := rewriteReferences(fields, false, kind.keyField, index.name)
+	if err != nil {
+		return nil, err
+	}
+	object := converted.(map[string]interface{})
+	delete(object, "zone_key")
+	delete(object, "org_key")
+	if !keepChecksum {
+		delete(object, "checksum")
+	}
+	return object, nil
+}
+
+// fromSnapshotObject converts a snapshot object into the JSON form
+// gm-control-api accepts for zoneKey. Its own key and checksum are
+// dropped; the caller supplies them when updating.
+func fromSnapshotObject(kind snapshotKind, object snapshotObject, zoneKey api.ZoneKey, index *zoneIndex) (snapshotObject, error) {
+	converted, err := rewriteReferences(object, true, kind.keyField, index.key)
+	if err != nil {
+		return nil, err
+	}
+	fields := snapshotObject(converted.(map[string]interface{}))
+	delete(fields, kind.keyField)
+	delete(fields, "checksum")
+	fields["zone_key"] = string(zoneKey)
+	return fields, nil
+}
+
+// rewriteReferences returns a copy of value in which every reference
+// field, at any depth, is rewritten through lookup: from names to keys
+// when toKeys is set, otherwise from keys to names. ownKeyField, the
+// object's own key, is left alone at the top level.
+func rewriteReferences(
+	value interface{},
+	toKeys bool,
+	ownKeyField string,
+	lookup func(segment string, from string) (string, error),
+) (interface{}, error) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return rewriteReferenceFields(value, toKeys, ownKeyField, lookup)
+	case snapshotObject:
+		return rewriteReferenceFields(value, toKeys, ownKeyField, lookup)
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, element := range value {
+			converted, err := rewriteReferences(element, toKeys, "", lookup)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = converted
+		}
+		return result, nil
+	}
+	return value, nil
+}
+
+func rewriteReferenceFields(
+	fields map[string]interface{},
+	toKeys bool,
+	ownKeyField string,
+	lookup func(segment string, from string) (string, error),
+) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(fields))
+	for field, value := range fields {
+		reference, ok := findReference(field, toKeys)
+		if !ok || field == ownKeyField {
+			converted, err := rewriteReferences(value, toKeys, "", lookup)
+			if err != nil {
+				return nil, err
+			}
+			result[field] = converted
+			continue
+		}
+
+		target := reference.nameField
+		if toKeys {
+			target = reference.keyField
+		}
+		converted, err := lookupReferences(value, reference.segment, lookup)
+		if err != nil {
+			return nil, errors.Wrap(err, field)
+		}
+		result[target] = converted
+	}
+	return result, nil
+}
+
+func findReference(field string, byName bool) (snapshotReference, bool) {
+	for _, reference := range snapshotReferences {
+		if (byName && reference.nameField == field) || (!byName && reference.keyField == field) {
+			return reference, true
+		}
+	}
+	return snapshotReference{}, false
+}
+
+// lookupReferences converts a single reference or a list of them. Empty
+// references are kept as they are.
+func lookupReferences(
+	value interface{},
+	segment string,
+	lookup func(segment string, from string) (string, error),
+) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		if value == "" {
+			return value, nil
+		}
+		return lookup(segment, value)
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, element := range value {
+			converted, err := lookupReferences(element, segment, lookup)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = converted
+		}
+		return result, nil
+	}
+	return value, nil
+}
+
+// toFields returns the JSON form of value.
+func toFields(value interface{}) (snapshotObject, error) {
+	contents, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+	var fields snapshotObject
+	if err = decodeJSON(contents, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// fromFields decodes the JSON form of an object into a new value of the
+// resource's api type.
+func fromFields(r resource, fields snapshotObject) (interface{}, error) {
+	contents, err := json.Marshal(fields)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+	object := r.newObject()
+	if err = json.Unmarshal(contents, object); err != nil {
+		return nil, errors.Wrapf(err, "decode %s", r.segment)
+	}
+	return object, nil
+}
+
+func decodeJSON(contents []byte, result interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.UseNumber()
+	return errors.Wrap(decoder.Decode(result), "Decode")
+}
+
+// readSnapshot reads a snapshot from a YAML or JSON file.
+func readSnapshot(path string) (snapshot, error) {
+	var result snapshot
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return result, errors.Wrap(err, "ReadFile")
+	}
+
+	var document interface{}
+	if err = yaml.Unmarshal(contents, &document); err != nil {
+		return result, errors.Wrapf(err, "parse %s", path)
+	}
+	contents, err = json.Marshal(yamlToJSON(document))
+	if err != nil {
+		return result, errors.Wrapf(err, "convert %s", path)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&result); err != nil {
+		return result, errors.Wrapf(err, "decode %s", path)
+	}
+
+	return result, result.validate()
+}
+
+// writeSnapshot writes s as indented JSON or, when asYAML is set, as YAML.
+// Zones are in name order and objects in identity order, and map members
+// are sorted, so exporting unchanged state twice gives identical files.
+func writeSnapshot(w io.Writer, s snapshot, asYAML bool) error {
+	contents, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "MarshalIndent")
+	}
+	contents = append(contents, '\n')
+
+	if asYAML {
+		var document interface{}
+		if err = decodeJSON(contents, &document); err != nil {
+			return err
+		}
+		contents, err = yaml.Marshal(jsonToYAML(document))
+		if err != nil {
+			return errors.Wrap(err, "yaml.Marshal")
+		}
+	}
+
+	_, err = w.Write(contents)
+	return err
+}
+
+// jsonToYAML replaces the json.Number values of a decoded document with
+// numbers, which yaml.v2 would otherwise quote as strings.
+func jsonToYAML(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		if f, err := value.Float64(); err == nil {
+			return f
+		}
+		return value.String()
+	case map[string]interface{}:
+		for key, element := range value {
+			value[key] = jsonToYAML(element)
+		}
+	case []interface{}:
+		for i, element := range value {
+			value[i] = jsonToYAML(element)
+		}
+	}
+	return value
+}
+
+// yamlToJSON converts the maps yaml.v2 decodes, which have interface{}
+// keys, into maps encoding/json can marshal.
+func yamlToJSON(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for key, element := range value {
+			result[fmt.Sprint(key)] = yamlToJSON(element)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, element := range value {
+			result[i] = yamlToJSON(element)
+		}
+		return result
+	}
+	return value
+}
+
+// validate checks that every zone is named once and that no two objects
+// of a type in a zone share an identity.
+func (s snapshot) validate() error {
+	zones := make(map[string]bool)
+	for i := range s.Zones {
+		zone := &s.Zones[i]
+		if zone.Name == "" {
+			return errors.Errorf("zone %d has no name", i)
+		}
+		if zones[zone.Name] {
+			return errors.Errorf("zone %s appears twice", zone.Name)
+		}
+		zones[zone.Name] = true
+
+		for _, kind := range snapshotKinds {
+			seen := make(map[string]bool)
+			for _, object := range *kind.section(zone) {
+				identity := kind.identity(object)
+				if seen[identity] {
+					return errors.Errorf("zone %s: %s %s appears twice", zone.Name, kind.resource.segment, identity)
+				}
+				seen[identity] = true
+			}
+		}
+	}
+	return nil
+}
+
+// liveObject is one object read from gm-control-api.
+type liveObject struct {
+	key      string
+	value    interface{}
+	fields   snapshotObject
+	snapshot snapshotObject
+}
+
+// liveZone is one zone as gm-control-api holds it, with its objects by
+// type segment, sorted by identity.
+type liveZone struct {
+	zone    api.Zone
+	index   *zoneIndex
+	objects map[string][]liveObject
+}
+
+// readLiveZone reads every object in zone.
+func readLiveZone(ctx context.Context, client *clientStruct, zone api.Zone, keepChecksums bool) (liveZone, error) {
+	live := liveZone{
+		zone:    zone,
+		index:   newZoneIndex(),
+		objects: make(map[string][]liveObject),
+	}
+
+	for _, kind := range snapshotKinds {
+		values, err := listZoneObjects(ctx, client, kind.resource, zone.ZoneKey)
+		if err != nil {
+			return live, errors.Wrapf(err, "list %s", kind.resource.segment)
+		}
+		for _, value := range values {
+			fields, err := toFields(value)
+			if err != nil {
+				return live, err
+			}
+			key, _ := kind.resource.identify(value)
+			if name, ok := fields["name"].(string); ok {
+				live.index.add(kind.resource.segment, name, key)
+			}
+			live.objects[kind.resource.segment] = append(live.objects[kind.resource.segment], liveObject{
+				key:    key,
+				value:  value,
+				fields: fields,
+			})
+		}
+	}
+
+	for _, kind := range snapshotKinds {
+		objects := live.objects[kind.resource.segment]
+		for i := range objects {
+			converted, err := toSnapshotObject(kind, objects[i].fields, live.index, keepChecksums)
+			if err != nil {
+				return live, errors.Wrapf(err, "%s %s", kind.resource.segment, objects[i].key)
+			}
+			objects[i].snapshot = converted
+		}
+		sort.SliceStable(objects, func(i, j int) bool {
+			return kind.identity(objects[i].snapshot) < kind.identity(objects[j].snapshot)
+		})
+	}
+
+	return live, nil
+}
+
+// listZoneObjects lists the objects of one type in a zone, each as a
+// pointer to its api type.
+func listZoneObjects(ctx context.Context, client *clientStruct, r resource, zoneKey api.ZoneKey) ([]interface{}, error) {
+	rc := resourceClient{client: client, resource: r}
+
+	var raw []json.RawMessage
+	filters := []map[string]api.ZoneKey{{"zone_key": zoneKey}}
+	if err := rc.list(ctx, filters, &raw); err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, 0, len(raw))
+	for _, message := range raw {
+		value := r.newObject()
+		if err := json.Unmarshal(message, value); err != nil {
+			return nil, errors.Wrapf(err, "decode %s", r.segment)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// exportSnapshot reads every zone gm-control-api holds and the objects in
+// each. A zone name or object identity held twice is an error, since the
+// snapshot could not tell them apart.
+func exportSnapshot(ctx context.Context, client *clientStruct, keepChecksums bool) (snapshot, error) {
+	var result snapshot
+
+	zones, err := client.zones().List(ctx)
+	if err != nil {
+		return result, errors.Wrap(err, "list zones")
+	}
+	sort.SliceStable(zones, func(i, j int) bool { return zones[i].Name < zones[j].Name })
+
+	for _, zone := range zones {
+		live, err := readLiveZone(ctx, client, zone, keepChecksums)
+		if err != nil {
+			return result, errors.Wrapf(err, "zone %s", zone.Name)
+		}
+		exported := snapshotZone{Name: zone.Name, ZoneKey: string(zone.ZoneKey)}
+		if keepChecksums {
+			exported.Checksum = zone.Checksum.Checksum
+		}
+		for _, kind := range snapshotKinds {
+			section := kind.section(&exported)
+			for _, object := range live.objects[kind.resource.segment] {
+				*section = append(*section, object.snapshot)
+			}
+		}
+		result.Zones = append(result.Zones, exported)
+	}
+
+	return result, result.validate()
+}
+
+// findZone returns the zone named name, if there is one.
+func findZone(ctx context.Context, client *clientStruct, name string) (api.Zone, bool, error) {
+	zones, err := client.zones().List(ctx, service.ZoneFilter{Name: name})
+	if err != nil {
+		return api.Zone{}, false, errors.Wrap(err, "list zones")
+	}
+	switch len(zones) {
+	case 0:
+		return api.Zone{}, false, nil
+	case 1:
+		return zones[0], true, nil
+	}
+	return api.Zone{}, false, errors.Errorf("%d zones named %s", len(zones), name)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func snapshotKindFor(t *testing.T, segment string) snapshotKind {
+	for _, kind := range snapshotKinds {
+		if kind.resource.segment == segment {
+			return kind
+		}
+	}
+	t.Fatalf("no snapshot kind %s", segment)
+	return snapshotKind{}
+}
+
+func testZoneIndex() *zoneIndex {
+	index := newZoneIndex()
+	index.add("cluster", "cluster1", "C1")
+	index.add("domain", "domain1", "D1")
+	index.add("domain", "domain2", "D2")
+	index.add("listener", "listener1", "L1")
+	index.add("shared_rules", "rules1", "S1")
+	return index
+}
+
+func TestToSnapshotObject(t *testing.T) {
+	tests := []struct {
+		name         string
+		segment      string
+		fields       snapshotObject
+		keepChecksum bool
+		want         snapshotObject
+		wantErr      string
+	}{
+		{
+			name:    "route",
+			segment: "route",
+			fields: snapshotObject{
+				"route_key": "R1", "zone_key": "Z1", "org_key": "O1", "checksum": "x",
+				"domain_key": "D1", "shared_rules_key": "S1", "path": "/a",
+			},
+			want: snapshotObject{"route_key": "R1", "domain": "domain1", "shared_rules": "rules1", "path": "/a"},
+		},
+		{
+			name:         "checksum kept",
+			segment:      "cluster",
+			fields:       snapshotObject{"cluster_key": "C1", "name": "cluster1", "checksum": "x"},
+			keepChecksum: true,
+			want:         snapshotObject{"cluster_key": "C1", "name": "cluster1", "checksum": "x"},
+		},
+		{
+			name:    "key lists",
+			segment: "proxy",
+			fields: snapshotObject{
+				"proxy_key": "P1", "name": "proxy1",
+				"domain_keys": []interface{}{"D2", "D1"}, "listener_keys": []interface{}{"L1"},
+			},
+			want: snapshotObject{
+				"proxy_key": "P1", "name": "proxy1",
+				"domains": []interface{}{"domain2", "domain1"}, "listeners": []interface{}{"listener1"},
+			},
+		},
+		{
+			name:    "nested reference",
+			segment: "shared_rules",
+			fields: snapshotObject{
+				"shared_rules_key": "S1", "name": "rules1",
+				"default": map[string]interface{}{
+					"light": []interface{}{map[string]interface{}{"cluster_key": "C1", "weight": 1}},
+				},
+			},
+			want: snapshotObject{
+				"shared_rules_key": "S1", "name": "rules1",
+				"default": map[string]interface{}{
+					"light": []interface{}{map[string]interface{}{"cluster": "cluster1", "weight": 1}},
+				},
+			},
+		},
+		{
+			name:    "empty reference kept",
+			segment: "route",
+			fields:  snapshotObject{"route_key": "R1", "domain_key": "", "path": "/a"},
+			want:    snapshotObject{"route_key": "R1", "domain": "", "path": "/a"},
+		},
+		{
+			name:    "unknown key",
+			segment: "route",
+			fields:  snapshotObject{"route_key": "R1", "domain_key": "D9"},
+			wantErr: `domain_key: no domain with key "D9"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := toSnapshotObject(snapshotKindFor(t, test.segment), test.fields, testZoneIndex(), test.keepChecksum)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("toSnapshotObject: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("toSnapshotObject() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFromSnapshotObject(t *testing.T) {
+	tests := []struct {
+		name    string
+		segment string
+		object  snapshotObject
+		want    snapshotObject
+		wantErr string
+	}{
+		{
+			name:    "route",
+			segment: "route",
+			object: snapshotObject{
+				"route_key": "old", "checksum": "x",
+				"domain": "domain1", "shared_rules": "rules1", "path": "/a",
+			},
+			want: snapshotObject{"zone_key": "Z2", "domain_key": "D1", "shared_rules_key": "S1", "path": "/a"},
+		},
+		{
+			name:    "name lists",
+			segment: "listener",
+			object:  snapshotObject{"name": "listener1", "domains": []interface{}{"domain1", "domain2"}},
+			want:    snapshotObject{"zone_key": "Z2", "name": "listener1", "domain_keys": []interface{}{"D1", "D2"}},
+		},
+		{
+			name:    "unknown name",
+			segment: "listener",
+			object:  snapshotObject{"name": "listener1", "domains": []interface{}{"domain9"}},
+			wantErr: `domains: no domain named "domain9"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := fromSnapshotObject(snapshotKindFor(t, test.segment), test.object, "Z2", testZoneIndex())
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fromSnapshotObject: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("fromSnapshotObject() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotIdentity(t *testing.T) {
+	route := snapshotKindFor(t, "route")
+	tests := []struct {
+		name string
+		a, b snapshotObject
+		same bool
+	}{
+		{
+			name: "same route",
+			a:    snapshotObject{"domain": "domain1", "path": "/a"},
+			b:    snapshotObject{"domain": "domain1", "path": "/a"},
+			same: true,
+		},
+		{
+			name: "path running into the domain",
+			a:    snapshotObject{"domain": "a", "path": "b/c"},
+			b:    snapshotObject{"domain": "ab", "path": "/c"},
+		},
+		{
+			name: "space and quote in the path",
+			a:    snapshotObject{"domain": `a "b"`, "path": "c"},
+			b:    snapshotObject{"domain": "a", "path": `b" "c`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := route.identity(test.a), route.identity(test.b)
+			if (a == b) != test.same {
+				t.Errorf("identities %q and %q: same = %v, want %v", a, b, a == b, test.same)
+			}
+		})
+	}
+}
+
+func TestSnapshotValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       snapshot
+		wantErr string
+	}{
+		{
+			name: "valid",
+			s: snapshot{Zones: []snapshotZone{
+				{
+					Name:     "zone1",
+					Clusters: []snapshotObject{{"name": "cluster1"}, {"name": "cluster2"}},
+					Routes: []snapshotObject{
+						{"domain": "domain1", "path": "/a"},
+						{"domain": "domain2", "path": "/a"},
+					},
+				},
+				{Name: "zone2", Clusters: []snapshotObject{{"name": "cluster1"}}},
+			}},
+		},
+		{
+			name:    "unnamed zone",
+			s:       snapshot{Zones: []snapshotZone{{}}},
+			wantErr: "zone 0 has no name",
+		},
+		{
+			name:    "zone twice",
+			s:       snapshot{Zones: []snapshotZone{{Name: "zone1"}, {Name: "zone1"}}},
+			wantErr: "zone zone1 appears twice",
+		},
+		{
+			name: "cluster twice",
+			s: snapshot{Zones: []snapshotZone{
+				{Name: "zone1", Clusters: []snapshotObject{{"name": "cluster1"}, {"name": "cluster1"}}},
+			}},
+			wantErr: "zone zone1: cluster cluster1 appears twice",
+		},
+		{
+			name: "route twice",
+			s: snapshot{Zones: []snapshotZone{
+				{Name: "zone1", Routes: []snapshotObject{
+					{"domain": "domain1", "path": "/a"},
+					{"domain": "domain1", "path": "/a"},
+				}},
+			}},
+			wantErr: `zone zone1: route domain1 "/a" appears twice`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.s.validate()
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("validate: %v", err)
+			case test.wantErr != "" && (err == nil || err.Error() != test.wantErr):
+				t.Errorf("validate() = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
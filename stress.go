@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	api "github.com/deciphernow/gm-control-api/api"
+)
+
+// stressTest runs many writers against one cluster at once: writers that
+// edit the cluster, and instances that register and deregister themselves
+// as discovery sidecars do. Each writer
+// uses its own copy of the client with retries turned off, so that a
+// server error is seen rather than retried away and a write is never sent
+// twice.
+type stressTest struct {
+	model   *Model
+	writers int
+	edits   int
+	// instances register and deregister registrations times each
+	instances     int
+	registrations int
+	// maxAttempts bounds the fetch, modify and write attempts made for one
+	// edit before the writer gives up on it
+	maxAttempts int
+}
+
+// stressOutcome counts what the writers of one stress step saw.
+type stressOutcome struct {
+	mu        sync.Mutex
+	succeeded int
+	conflicts int
+	failures  []error
+}
+
+func (outcome *stressOutcome) add(succeeded int, conflicts int, err error) {
+	outcome.mu.Lock()
+	defer outcome.mu.Unlock()
+
+	outcome.succeeded += succeeded
+	outcome.conflicts += conflicts
+	if err != nil {
+		outcome.failures = append(outcome.failures, err)
+	}
+}
+
+// stressScenario creates a cluster and has many writers edit it, then many
+// instances register with it, at once.
+func stressScenario(stress *stressTest) scenario {
+	model := stress.model
+	steps := stepsNamed(loadSteps(model), "loadZone", "loadCluster")
+	steps = append(steps,
+		step{
+			name:        "concurrentClusterEdits",
+			description: "increment a cluster counter from many writers at once",
+			dependsOn:   []string{"loadCluster"},
+			tags:        []string{"cluster", "edit", "checksum", "stress"},
+			run:         stress.concurrentClusterEdits,
+		},
+		step{
+			name:        "concurrentInstanceRegistration",
+			description: "register and deregister many cluster instances at once",
+			dependsOn:   []string{"loadCluster"},
+			tags:        []string{"cluster", "instance", "checksum", "stress"},
+			run:         stress.concurrentInstanceRegistration,
+		},
+	)
+	steps = append(steps, stepsNamed(deleteSteps(model), "deleteCluster", "deleteZone")...)
+
+	return scenario{
+		name:        "stress",
+		description: "concurrent checksum-guarded writes to one cluster",
+		steps:       steps,
+	}
+}
+
+// writerClient returns a copy of client that makes a single attempt at
+// each request.
+func writerClient(client *clientStruct) *clientStruct {
+	writer := *client
+	writer.retryPolicy = retryPolicy{}
+	return &writer
+}
+
+// concurrentClusterEdits has every writer repeatedly fetch the cluster,
+// add one to its circuit breaker max connections and write it back,
+// starting over when the write is refused for a stale checksum. The
+// counter must end up raised by exactly the number of edits that
+// succeeded, and no request may fail other than by conflict.
+func (stress *stressTest) concurrentClusterEdits(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	clusterKey := stress.model.Cluster1.ClusterKey
+	start, err := getClusterByKey(ctx, client, clusterKey)
+	if err != nil {
+		return errors.Wrap(err, "getClusterByKey")
+	}
+	initial := clusterCounter(start)
+
+	var outcome stressOutcome
+	var wg sync.WaitGroup
+	for w := 0; w < stress.writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			writer := writerClient(client)
+			writerLogger := logger.With().Int("writer", w).Logger()
+
+			for e := 0; e < stress.edits; e++ {
+				conflicts, err := stress.incrementCounter(ctx, writer, clusterKey)
+				if err != nil {
+					writerLogger.Error().Err(err).Int("edit", e).Msg("edit failed")
+					outcome.add(0, conflicts, errors.Wrapf(err, "writer %d edit %d", w, e))
+					continue
+				}
+				outcome.add(1, conflicts, nil)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	final, err := getClusterByKey(ctx, client, clusterKey)
+	if err != nil {
+		return errors.Wrap(err, "getClusterByKey")
+	}
+	stress.model.Cluster1 = final
+
+	logger.Info().Int("writers", stress.writers).Int("succeeded", outcome.succeeded).
+		Int("conflicts", outcome.conflicts).Int("failed", len(outcome.failures)).
+		Msg("concurrent cluster edits")
+
+	if len(outcome.failures) > 0 {
+		return errors.Wrapf(outcome.failures[0], "%d of %d edits failed, first",
+			len(outcome.failures), stress.writers*stress.edits)
+	}
+	if got, want := clusterCounter(final), initial+outcome.succeeded; got != want {
+		return errors.Errorf("lost updates: counter is %d after %d successful edits from %d; want %d",
+			got, outcome.succeeded, initial, want)
+	}
+
+	return nil
+}
+
+// incrementCounter adds one to the cluster counter, fetching again after
+// every conflict. It returns the number of conflicts met.
+func (stress *stressTest) incrementCounter(ctx context.Context, client *clientStruct, clusterKey api.ClusterKey) (int, error) {
+	return stress.retryConflicts(ctx, func() error {
+		cluster, err := getClusterByKey(ctx, client, clusterKey)
+		if err != nil {
+			return errors.Wrap(err, "getClusterByKey")
+		}
+
+		counter := clusterCounter(cluster) + 1
+		breakers := api.CircuitBreakers{}
+		if cluster.CircuitBreakers != nil {
+			breakers = *cluster.CircuitBreakers
+		}
+		breakers.MaxConnections = &counter
+		cluster.CircuitBreakers = &breakers
+
+		_, err = editCluster(ctx, client, cluster)
+		return errors.Wrap(err, "editCluster")
+	})
+}
+
+// retryConflicts calls attempt until it succeeds, fails other than by a
+// checksum conflict or has been called maxAttempts times. It returns the
+// number of conflicts met.
+func (stress *stressTest) retryConflicts(ctx context.Context, attempt func() error) (int, error) {
+	conflicts := 0
+	for i := 0; i < stress.maxAttempts; i++ {
+		err := attempt()
+		if err == nil {
+			return conflicts, nil
+		}
+		if !IsConflict(err) {
+			return conflicts, err
+		}
+		conflicts++
+		if ctx.Err() != nil {
+			return conflicts, ctx.Err()
+		}
+	}
+
+	return conflicts, errors.Errorf("still conflicting after %d attempts", stress.maxAttempts)
+}
+
+// concurrentInstanceRegistration has every instance register with the
+// cluster and deregister again, registrations times, each change guarded by
+// the checksum of a freshly fetched cluster and retried after a conflict.
+// Every other instance then registers a last time and stays. The cluster
+// must end up listing exactly the instances that stayed, each once.
+func (stress *stressTest) concurrentInstanceRegistration(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	clusterKey := stress.model.Cluster1.ClusterKey
+
+	var outcome stressOutcome
+	var registered sync.Map
+	var wg sync.WaitGroup
+	for i := 0; i < stress.instances; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			writer := writerClient(client)
+			instance := api.Instance{Host: fmt.Sprintf("10.0.%d.%d", i/250, i%250+1), Port: 8000 + i}
+			instanceLogger := logger.With().Str("instance", instance.Key()).Logger()
+
+			changes := make([]bool, 0, 2*stress.registrations+1)
+			for r := 0; r < stress.registrations; r++ {
+				changes = append(changes, true, false)
+			}
+			if i%2 == 0 {
+				changes = append(changes, true)
+			}
+
+			for c, register := range changes {
+				conflicts, err := stress.changeRegistration(ctx, writer, clusterKey, instance, register)
+				if err != nil {
+					instanceLogger.Error().Err(err).Int("change", c).Msg("registration failed")
+					outcome.add(0, conflicts, errors.Wrapf(err, "instance %s change %d", instance.Key(), c))
+					return
+				}
+				outcome.add(1, conflicts, nil)
+				if register {
+					registered.Store(instance.Key(), instance)
+				} else {
+					registered.Delete(instance.Key())
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	final, err := getClusterByKey(ctx, client, clusterKey)
+	if err != nil {
+		return errors.Wrap(err, "getClusterByKey")
+	}
+	stress.model.Cluster1 = final
+
+	logger.Info().Int("instances", stress.instances).Int("succeeded", outcome.succeeded).
+		Int("conflicts", outcome.conflicts).Int("failed", len(outcome.failures)).
+		Msg("concurrent instance registration")
+
+	if len(outcome.failures) > 0 {
+		return errors.Wrapf(outcome.failures[0], "%d of %d instances failed, first",
+			len(outcome.failures), stress.instances)
+	}
+
+	var want []string
+	registered.Range(func(key interface{}, value interface{}) bool {
+		want = append(want, key.(string))
+		return true
+	})
+	var got []string
+	for _, instance := range final.Instances {
+		got = append(got, instance.Key())
+	}
+	sort.Strings(want)
+	sort.Strings(got)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		return errors.Errorf("cluster lists instances %v; registered are %v", got, want)
+	}
+
+	return nil
+}
+
+// changeRegistration registers or deregisters instance, fetching the
+// cluster again after every conflict. It returns the number of conflicts
+// met.
+func (stress *stressTest) changeRegistration(
+	ctx context.Context,
+	client *clientStruct,
+	clusterKey api.ClusterKey,
+	instance api.Instance,
+	register bool,
+) (int, error) {
+	return stress.retryConflicts(ctx, func() error {
+		cluster, err := getClusterByKey(ctx, client, clusterKey)
+		if err != nil {
+			return errors.Wrap(err, "getClusterByKey")
+		}
+
+		if register {
+			_, err = putClusterInstance(ctx, client, cluster, instance)
+			return errors.Wrap(err, "putClusterInstance")
+		}
+		_, err = deleteClusterInstance(ctx, client, cluster, instance)
+		return errors.Wrap(err, "deleteClusterInstance")
+	})
+}
+
+// clusterCounter is the value the stress writers increment.
+func clusterCounter(cluster api.Cluster) int {
+	if cluster.CircuitBreakers == nil || cluster.CircuitBreakers.MaxConnections == nil {
+		return 0
+	}
+	return *cluster.CircuitBreakers.MaxConnections
+}
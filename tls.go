@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// tlsSettings configure an https connection to gm-control-api. caFile
+// replaces the system roots; certFile and keyFile together enable mutual
+// TLS; serverName overrides the name checked against the server
+// certificate.
+type tlsSettings struct {
+	caFile             string
+	certFile           string
+	keyFile            string
+	serverName         string
+	insecureSkipVerify bool
+}
+
+func (settings tlsSettings) config() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         settings.serverName,
+		InsecureSkipVerify: settings.insecureSkipVerify,
+	}
+
+	if settings.caFile != "" {
+		caPEM, err := ioutil.ReadFile(settings.caFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "ReadFile CA")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.Errorf("no certificates found in %s", settings.caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if settings.certFile != "" || settings.keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(settings.certFile, settings.keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "LoadX509KeyPair")
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
+
+// useTLS switches client to https using settings.
+func (client *clientStruct) useTLS(settings tlsSettings) error {
+	config, err := settings.config()
+	if err != nil {
+		return errors.Wrap(err, "tls config")
+	}
+
+	client.scheme = "https"
+	client.httpClient.Transport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: config,
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// checkTLSStandIn exercises the client's TLS settings against a local
+// https server that requires client certificates. All certificates are
+// self-signed and generated for this run.
+func checkTLSStandIn(ctx context.Context, logger zerolog.Logger, client *clientStruct) error {
+	dir, err := ioutil.TempDir("", "gm-control-api-tls")
+	if err != nil {
+		return errors.Wrap(err, "TempDir")
+	}
+	defer os.RemoveAll(dir)
+
+	logger.Debug().Str("dir", dir).Msg("generating stand-in certificates")
+	files, serverCert, caPool, err := writeStandInCertificates(dir)
+	if err != nil {
+		return errors.Wrap(err, "writeStandInCertificates")
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"result":[]}`)
+		},
+	))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    caPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	// rejected handshakes are expected; keep them out of the run log
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	address := strings.TrimPrefix(server.URL, "https://")
+
+	for _, check := range []struct {
+		name     string
+		settings tlsSettings
+		// expectErr, if set, accepts the handshake failure the request must
+		// end in
+		expectErr func(error) bool
+	}{
+		{
+			name:     "mutual TLS with CA bundle",
+			settings: tlsSettings{caFile: files.ca, certFile: files.clientCert, keyFile: files.clientKey},
+		},
+		{
+			name: "server name override",
+			settings: tlsSettings{
+				caFile:     files.ca,
+				certFile:   files.clientCert,
+				keyFile:    files.clientKey,
+				serverName: "localhost",
+			},
+		},
+		{
+			name: "mismatched server name",
+			settings: tlsSettings{
+				caFile:     files.ca,
+				certFile:   files.clientCert,
+				keyFile:    files.clientKey,
+				serverName: "elsewhere.invalid",
+			},
+			expectErr: isHostnameError,
+		},
+		{
+			name:      "untrusted server certificate",
+			settings:  tlsSettings{certFile: files.clientCert, keyFile: files.clientKey},
+			expectErr: isUnknownAuthorityError,
+		},
+		{
+			name:     "insecure skip verify",
+			settings: tlsSettings{certFile: files.clientCert, keyFile: files.clientKey, insecureSkipVerify: true},
+		},
+		{
+			name:      "missing client certificate",
+			settings:  tlsSettings{caFile: files.ca},
+			expectErr: isRemoteTLSAlert,
+		},
+	} {
+		logger.Debug().Str("check", check.name).Msg("TLS stand-in request")
+		standIn := clientStruct{
+			logger:         logger,
+			serverAddress:  address,
+			requestTimeout: client.requestTimeout,
+		}
+		if err = standIn.useTLS(check.settings); err != nil {
+			return errors.Wrapf(err, "%s: useTLS", check.name)
+		}
+
+		_, err = standIn.zones().List(ctx)
+		standIn.httpClient.CloseIdleConnections()
+		switch {
+		case check.expectErr == nil && err != nil:
+			return errors.Wrapf(err, "%s", check.name)
+		case check.expectErr != nil && err == nil:
+			return errors.Errorf("%s: expected TLS failure, request succeeded", check.name)
+		case check.expectErr != nil && !hasCause(err, check.expectErr):
+			return errors.Wrapf(err, "%s: not the expected TLS failure", check.name)
+		}
+	}
+
+	return nil
+}
+
+// hasCause reports whether match accepts err or any error it wraps.
+func hasCause(err error, match func(error) bool) bool {
+	for err != nil {
+		if match(err) {
+			return true
+		}
+		switch wrapper := err.(type) {
+		case *url.Error:
+			err = wrapper.Err
+		case interface{ Cause() error }:
+			err = wrapper.Cause()
+		case interface{ Unwrap() error }:
+			err = wrapper.Unwrap()
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+func isHostnameError(err error) bool {
+	_, ok := err.(x509.HostnameError)
+	return ok
+}
+
+func isUnknownAuthorityError(err error) bool {
+	_, ok := err.(x509.UnknownAuthorityError)
+	return ok
+}
+
+// isRemoteTLSAlert reports whether err is an alert the server sent to end
+// the handshake, as it does for a missing client certificate.
+func isRemoteTLSAlert(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "remote error"
+}
+
+type standInFiles struct {
+	ca         string
+	clientCert string
+	clientKey  string
+}
+
+// writeStandInCertificates creates a CA, a server certificate for
+// localhost and a client certificate, writing the CA and the client pair
+// as PEM files under dir.
+func writeStandInCertificates(dir string) (standInFiles, tls.Certificate, *x509.CertPool, error) {
+	var files standInFiles
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return files, tls.Certificate{}, nil, errors.Wrap(err, "GenerateKey CA")
+	}
+	caTemplate := certificateTemplate("gm-control-api stand-in CA")
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return files, tls.Certificate{}, nil, errors.Wrap(err, "CreateCertificate CA")
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return files, tls.Certificate{}, nil, errors.Wrap(err, "ParseCertificate CA")
+	}
+	caPool := x509.NewCertPool()
+	caPool.AddCert(caCert)
+
+	serverTemplate := certificateTemplate("localhost")
+	serverTemplate.DNSNames = []string{"localhost"}
+	serverTemplate.IPAddresses = []net.IP{net.ParseIP("127.0.0.1"), net.IPv6loopback}
+	serverTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	serverCertPEM, serverKeyPEM, err := signCertificate(serverTemplate, caCert, caKey)
+	if err != nil {
+		return files, tls.Certificate{}, nil, errors.Wrap(err, "server certificate")
+	}
+	serverCert, err := tls.X509KeyPair(serverCertPEM, serverKeyPEM)
+	if err != nil {
+		return files, tls.Certificate{}, nil, errors.Wrap(err, "X509KeyPair server")
+	}
+
+	clientTemplate := certificateTemplate("gm-control-api-integration")
+	clientTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	clientCertPEM, clientKeyPEM, err := signCertificate(clientTemplate, caCert, caKey)
+	if err != nil {
+		return files, tls.Certificate{}, nil, errors.Wrap(err, "client certificate")
+	}
+
+	files = standInFiles{
+		ca:         filepath.Join(dir, "ca.pem"),
+		clientCert: filepath.Join(dir, "client.pem"),
+		clientKey:  filepath.Join(dir, "client-key.pem"),
+	}
+	for path, contents := range map[string][]byte{
+		files.ca:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		files.clientCert: clientCertPEM,
+		files.clientKey:  clientKeyPEM,
+	} {
+		if err = ioutil.WriteFile(path, contents, 0600); err != nil {
+			return files, tls.Certificate{}, nil, errors.Wrap(err, "WriteFile")
+		}
+	}
+
+	return files, serverCert, caPool, nil
+}
+
+func certificateTemplate(commonName string) *x509.Certificate {
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+}
+
+// signCertificate issues template from the CA and returns the certificate
+// and its new private key, both PEM encoded.
+func signCertificate(
+	template *x509.Certificate,
+	caCert *x509.Certificate,
+	caKey *ecdsa.PrivateKey,
+) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "GenerateKey")
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "CreateCertificate")
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "MarshalECPrivateKey")
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
@@ -1,14 +1,7 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
-
-	"github.com/pkg/errors"
+	"context"
 
 	api "github.com/deciphernow/gm-control-api/api"
 	service "github.com/deciphernow/gm-control-api/api/service"
@@ -16,115 +9,60 @@ import (
 
 const zoneName = "workregion"
 
-func createZone(client *clientStruct) (api.Zone, error) {
-	var reqZone api.Zone
-	var respZone api.Zone
-	var buffer bytes.Buffer
-	var request http.Request
-
-	reqZone.Name = zoneName
-
-	if err := json.NewEncoder(&buffer).Encode(&reqZone); err != nil {
-		return api.Zone{}, errors.Wrap(err, "Encode")
-	}
-
-	request.Method = "POST"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   "/v1.0/zone",
-	}
-	request.Body = ioutil.NopCloser(&buffer)
-
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Zone{}, errors.Wrap(err, "doHTTP")
-	}
-
-	if err = json.Unmarshal(rawMessage, &respZone); err != nil {
-		return api.Zone{}, errors.Wrap(err, "Unmarshal")
-	}
-
-	return respZone, nil
+var zoneResource = resource{
+	segment:   "zone",
+	newObject: func() interface{} { return &api.Zone{} },
+	identify: func(object interface{}) (string, string) {
+		zone := object.(*api.Zone)
+		return string(zone.ZoneKey), zone.Checksum.Checksum
+	},
+	equal: func(a interface{}, b interface{}) bool {
+		return a.(*api.Zone).Equals(*b.(*api.Zone))
+	},
 }
 
-func queryZoneByName(client *clientStruct) (api.Zones, error) {
-	var buffer bytes.Buffer
-	var request http.Request
-
-	nameFilter := service.ZoneFilter{Name: zoneName}
-	zoneFilters := []service.ZoneFilter{nameFilter}
-
-	if err := json.NewEncoder(&buffer).Encode(zoneFilters); err != nil {
-		return nil, errors.Wrap(err, "Encode filters")
-	}
-
-	request.Method = "GET"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   "/v1.0/zone",
-	}
-
-	values := url.Values{}
-	values.Add("filters", buffer.String())
-	request.URL.RawQuery = values.Encode()
+type zoneClient struct{ resourceClient }
 
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return nil, errors.Wrap(err, "doHTTP")
-	}
-
-	var zones []api.Zone
-
-	if err = json.Unmarshal(rawMessage, &zones); err != nil {
-		return nil, errors.Wrap(err, "Unmarshal")
-	}
-
-	return zones, nil
+func (client *clientStruct) zones() zoneClient {
+	return zoneClient{resourceClient{client: client, resource: zoneResource}}
 }
 
-func getZoneByKey(client *clientStruct, zoneKey api.ZoneKey) (api.Zone, error) {
-	var respZone api.Zone
-	var request http.Request
-
-	request.Method = "GET"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   fmt.Sprintf("/v1.0/zone/%s", url.PathEscape(string(zoneKey))),
-	}
+func (c zoneClient) Create(ctx context.Context, zone api.Zone) (result api.Zone, err error) {
+	err = c.create(ctx, &zone, &result)
+	return result, err
+}
 
-	rawMessage, err := client.doHTTP(&request)
-	if err != nil {
-		return api.Zone{}, errors.Wrap(err, "doHTTP")
-	}
+func (c zoneClient) List(ctx context.Context, filters ...service.ZoneFilter) (result api.Zones, err error) {
+	err = c.list(ctx, filters, &result)
+	return result, err
+}
 
-	if err = json.Unmarshal(rawMessage, &respZone); err != nil {
-		return api.Zone{}, errors.Wrap(err, "Unmarshal")
-	}
+func (c zoneClient) Get(ctx context.Context, zoneKey api.ZoneKey) (result api.Zone, err error) {
+	err = c.get(ctx, string(zoneKey), &result)
+	return result, err
+}
 
-	return respZone, nil
+func (c zoneClient) Update(ctx context.Context, zone api.Zone) (result api.Zone, err error) {
+	err = c.update(ctx, &zone, &result)
+	return result, err
 }
 
-func deleteZone(client *clientStruct, zone api.Zone) error {
-	var request http.Request
+func (c zoneClient) Delete(ctx context.Context, zone api.Zone) error {
+	return c.remove(ctx, &zone)
+}
 
-	request.Method = "DELETE"
-	request.URL = &url.URL{
-		Scheme: "http",
-		Host:   client.serverAddress,
-		Path:   fmt.Sprintf("/v1.0/zone/%s", url.PathEscape(string(zone.ZoneKey))),
-	}
+func createZone(ctx context.Context, client *clientStruct, name string) (api.Zone, error) {
+	return client.zones().Create(ctx, api.Zone{Name: name})
+}
 
-	values := url.Values{}
-	values.Add("checksum", zone.Checksum.Checksum)
-	request.URL.RawQuery = values.Encode()
+func queryZoneByName(ctx context.Context, client *clientStruct, name string) (api.Zones, error) {
+	return client.zones().List(ctx, service.ZoneFilter{Name: name})
+}
 
-	_, err := client.doHTTP(&request)
-	if err != nil {
-		return errors.Wrap(err, "doHTTP")
-	}
+func getZoneByKey(ctx context.Context, client *clientStruct, zoneKey api.ZoneKey) (api.Zone, error) {
+	return client.zones().Get(ctx, zoneKey)
+}
 
-	return nil
+func deleteZone(ctx context.Context, client *clientStruct, zone api.Zone) error {
+	return client.zones().Delete(ctx, zone)
 }